@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/15mga/kiwi/cmd/protoc-gen-kiwi/options"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const (
+	contextPkg = protogen.GoImportPath("context")
+	fmtPkg     = protogen.GoImportPath("fmt")
+	kiwiPkg    = protogen.GoImportPath("github.com/15mga/kiwi")
+	corePkg    = protogen.GoImportPath("github.com/15mga/kiwi/core")
+	utilPkg    = protogen.GoImportPath("github.com/15mga/kiwi/util")
+)
+
+const (
+	// 和kiwi.Mod一致,svc*mod+code不能超过TSvcCode
+	mod     = 1000
+	maxSvc  = (1<<16 - 1 - 255) / mod
+	maxCode = 1<<8 - 1
+)
+
+type service struct {
+	*protogen.Service
+	svc     uint32
+	methods []*method
+}
+
+type method struct {
+	*protogen.Method
+	req    uint32
+	res    uint32
+	worker options.Worker
+	key    *protogen.Field
+}
+
+func (m *method) stream() bool {
+	return m.Desc.IsStreamingClient() || m.Desc.IsStreamingServer()
+}
+
+func generateFile(plugin *protogen.Plugin, file *protogen.File) error {
+	services, err := parseServices(file)
+	if err != nil {
+		return err
+	}
+	g := plugin.NewGeneratedFile(file.GeneratedFilenamePrefix+"_kiwi.pb.go", file.GoImportPath)
+	g.P("// Code generated by protoc-gen-kiwi. DO NOT EDIT.")
+	g.P("// source: ", file.Desc.Path())
+	g.P()
+	g.P("package ", file.GoPackageName)
+	g.P()
+	for _, s := range services {
+		genConsts(g, s)
+		genCodec(g, s)
+		genServer(g, s)
+		genClient(g, s)
+	}
+	return nil
+}
+
+// parseServices 检查编号,同一个文件中消息只能对应一个编号,否则MsgToSvcCode无法区分
+func parseServices(file *protogen.File) ([]*service, error) {
+	msgToCode := make(map[protoreflect.FullName]string)
+	bindMsg := func(msg *protogen.Message, svc, code uint32) error {
+		sc := fmt.Sprintf("%d.%d", svc, code)
+		if old, ok := msgToCode[msg.Desc.FullName()]; ok && old != sc {
+			return fmt.Errorf("%s: message %s bound to %s and %s", file.Desc.Path(), msg.Desc.FullName(), old, sc)
+		}
+		msgToCode[msg.Desc.FullName()] = sc
+		return nil
+	}
+	svcToName := make(map[uint32]string)
+	services := make([]*service, 0, len(file.Services))
+	for _, s := range file.Services {
+		svc, _ := proto.GetExtension(s.Desc.Options(), options.E_Svc).(uint32)
+		if svc == 0 || svc > maxSvc {
+			return nil, fmt.Errorf("%s: service %s need option (kiwi.svc) in [1,%d]", file.Desc.Path(), s.Desc.Name(), maxSvc)
+		}
+		if old, ok := svcToName[svc]; ok {
+			return nil, fmt.Errorf("%s: service %s and %s use the same svc %d", file.Desc.Path(), old, s.Desc.Name(), svc)
+		}
+		svcToName[svc] = string(s.Desc.Name())
+		codes := make(map[uint32]string)
+		useCode := func(code uint32, name string) error {
+			if code == 0 || code > maxCode {
+				return fmt.Errorf("%s: %s.%s need code in [1,%d]", file.Desc.Path(), s.Desc.Name(), name, maxCode)
+			}
+			if old, ok := codes[code]; ok {
+				return fmt.Errorf("%s: %s.%s and %s use the same code %d", file.Desc.Path(), s.Desc.Name(), old, name, code)
+			}
+			codes[code] = name
+			return nil
+		}
+		srv := &service{
+			Service: s,
+			svc:     svc,
+		}
+		for _, m := range s.Methods {
+			opts := m.Desc.Options()
+			mtd := &method{
+				Method: m,
+			}
+			mtd.req, _ = proto.GetExtension(opts, options.E_Req).(uint32)
+			mtd.res, _ = proto.GetExtension(opts, options.E_Res).(uint32)
+			mtd.worker, _ = proto.GetExtension(opts, options.E_Worker).(options.Worker)
+			err := useCode(mtd.req, string(m.Desc.Name())+" (kiwi.req)")
+			if err != nil {
+				return nil, err
+			}
+			err = useCode(mtd.res, string(m.Desc.Name())+" (kiwi.res)")
+			if err != nil {
+				return nil, err
+			}
+			err = bindMsg(m.Input, svc, mtd.req)
+			if err != nil {
+				return nil, err
+			}
+			err = bindMsg(m.Output, svc, mtd.res)
+			if err != nil {
+				return nil, err
+			}
+			key, _ := proto.GetExtension(opts, options.E_WorkerKey).(string)
+			if key != "" {
+				if mtd.worker != options.Worker_WORKER_ACTIVE && mtd.worker != options.Worker_WORKER_SHARE {
+					return nil, fmt.Errorf("%s: %s.%s (kiwi.worker_key) only for active and share", file.Desc.Path(), s.Desc.Name(), m.Desc.Name())
+				}
+				for _, field := range m.Input.Fields {
+					if string(field.Desc.Name()) == key {
+						mtd.key = field
+						break
+					}
+				}
+				if mtd.key == nil || mtd.key.Desc.IsList() || mtd.key.Desc.IsMap() || mtd.key.Desc.Message() != nil {
+					return nil, fmt.Errorf("%s: %s.%s (kiwi.worker_key) %s is not a scalar field of %s",
+						file.Desc.Path(), s.Desc.Name(), m.Desc.Name(), key, m.Input.Desc.Name())
+				}
+			}
+			srv.methods = append(srv.methods, mtd)
+		}
+		services = append(services, srv)
+	}
+	return services, nil
+}
+
+func svcName(s *service) string {
+	return "Svc" + s.GoName
+}
+
+func codeName(msg *protogen.Message) string {
+	return "Code" + msg.GoIdent.GoName
+}
+
+func genConsts(g *protogen.GeneratedFile, s *service) {
+	g.P("const ", svcName(s), " ", g.QualifiedGoIdent(kiwiPkg.Ident("TSvc")), " = ", s.svc)
+	g.P()
+	g.P("const (")
+	code := g.QualifiedGoIdent(kiwiPkg.Ident("TCode"))
+	for _, m := range s.methods {
+		g.P(codeName(m.Input), " ", code, " = ", m.req)
+		g.P(codeName(m.Output), " ", code, " = ", m.res)
+	}
+	g.P(")")
+	g.P()
+}
+
+func genCodec(g *protogen.GeneratedFile, s *service) {
+	codec := g.QualifiedGoIdent(kiwiPkg.Ident("Codec"))
+	msg := g.QualifiedGoIdent(utilPkg.Ident("IMsg"))
+	g.P("// Bind", s.GoName, "Codec 注册消息工厂和请求响应的对应关系,客户端和服务端都需要")
+	g.P("func Bind", s.GoName, "Codec() {")
+	for _, m := range s.methods {
+		for _, message := range []*protogen.Message{m.Input, m.Output} {
+			g.P(codec, "().BindFac(", svcName(s), ", ", codeName(message), ", func() ", msg, " {")
+			g.P("return &", message.GoIdent, "{}")
+			g.P("})")
+		}
+		g.P(codec, "().BindReqToRes(", svcName(s), ", ", codeName(m.Input), ", ", codeName(m.Output), ")")
+	}
+	g.P("}")
+	g.P()
+}
+
+func genServer(g *protogen.GeneratedFile, s *service) {
+	rcvReq := g.QualifiedGoIdent(kiwiPkg.Ident("IRcvRequest"))
+	rcvStream := g.QualifiedGoIdent(kiwiPkg.Ident("IRcvStream"))
+	name := s.GoName + "Server"
+	g.P("// ", name, " ", s.GoName, "的处理,请求方法在对应的worker中调用")
+	g.P("type ", name, " interface {")
+	for _, m := range s.methods {
+		if m.stream() {
+			g.P(m.GoName, "(stream ", rcvStream, ")")
+		} else {
+			g.P(m.GoName, "(pkt ", rcvReq, ", req *", g.QualifiedGoIdent(m.Input.GoIdent), ", res *", g.QualifiedGoIdent(m.Output.GoIdent), ")")
+		}
+	}
+	g.P("}")
+	g.P()
+	g.P("// Bind", name, " 注册", name, "的处理,包括Bind", s.GoName, "Codec")
+	g.P("func Bind", name, "(s ", name, ") {")
+	g.P("Bind", s.GoName, "Codec()")
+	router := g.QualifiedGoIdent(kiwiPkg.Ident("Router"))
+	for _, m := range s.methods {
+		if m.stream() {
+			g.P(router, "().BindStream(", svcName(s), ", ", codeName(m.Input), ", s.", m.GoName, ")")
+			continue
+		}
+		g.P(router, "().BindReq(", svcName(s), ", ", codeName(m.Input), ", func(pkt ", rcvReq, ") {")
+		types := "[*" + g.QualifiedGoIdent(m.Input.GoIdent) + ", *" + g.QualifiedGoIdent(m.Output.GoIdent) + "]"
+		switch m.worker {
+		case options.Worker_WORKER_ACTIVE, options.Worker_WORKER_SHARE:
+			fn := "ActivePrcReq"
+			if m.worker == options.Worker_WORKER_SHARE {
+				fn = "SharePrcReq"
+			}
+			g.P(g.QualifiedGoIdent(corePkg.Ident(fn)), types, "(pkt, ", workerKey(g, m), ", s.", m.GoName, ")")
+		case options.Worker_WORKER_GLOBAL:
+			g.P(g.QualifiedGoIdent(corePkg.Ident("GlobalPrcReq")), types, "(pkt, s.", m.GoName, ")")
+		case options.Worker_WORKER_SELF:
+			g.P(g.QualifiedGoIdent(corePkg.Ident("SelfPrcReq")), types, "(pkt, s.", m.GoName, ")")
+		default:
+			g.P(g.QualifiedGoIdent(corePkg.Ident("GoPrcReq")), types, "(pkt, s.", m.GoName, ")")
+		}
+		g.P("})")
+	}
+	g.P("}")
+	g.P()
+}
+
+func workerKey(g *protogen.GeneratedFile, m *method) string {
+	if m.key == nil {
+		return "pkt.HeadId()"
+	}
+	getter := "pkt.Msg().(*" + g.QualifiedGoIdent(m.Input.GoIdent) + ").Get" + m.key.GoName + "()"
+	if m.key.Desc.Kind() == protoreflect.StringKind {
+		return getter
+	}
+	return g.QualifiedGoIdent(fmtPkg.Ident("Sprint")) + "(" + getter + ")"
+}
+
+func genClient(g *protogen.GeneratedFile, s *service) {
+	ctx := g.QualifiedGoIdent(contextPkg.Ident("Context"))
+	m := g.QualifiedGoIdent(utilPkg.Ident("M"))
+	for _, mtd := range s.methods {
+		name := s.GoName + mtd.GoName
+		req := "*" + g.QualifiedGoIdent(mtd.Input.GoIdent)
+		res := "*" + g.QualifiedGoIdent(mtd.Output.GoIdent)
+		switch {
+		case mtd.Desc.IsStreamingClient() && mtd.Desc.IsStreamingServer():
+			g.P("// ", name, " 打开双向流,first为第一条请求")
+			g.P("func ", name, "(ctx ", ctx, ", pid int64, head ", m, ", first ", req, ") (",
+				g.QualifiedGoIdent(kiwiPkg.Ident("IStream")), ", *", g.QualifiedGoIdent(utilPkg.Ident("Err")), ") {")
+			g.P("return ", g.QualifiedGoIdent(corePkg.Ident("OpenStream")), "(ctx, pid, head, first)")
+			g.P("}")
+		case mtd.Desc.IsStreamingServer():
+			g.P("// ", name, " fn返回false时取消,返回服务端的结束码")
+			g.P("func ", name, "(ctx ", ctx, ", pid int64, head ", m, ", req ", req, ", fn func(", res, ") bool) uint16 {")
+			g.P("return ", g.QualifiedGoIdent(corePkg.Ident("ServerStream")), "[", res, "](ctx, pid, head, req, fn)")
+			g.P("}")
+		case mtd.Desc.IsStreamingClient():
+			g.P("// ", name, " first打开流,之后发送next的请求直到返回false")
+			g.P("func ", name, "(ctx ", ctx, ", pid int64, head ", m, ", first ", req, ", next func() (", req, ", bool)) (", res, ", uint16) {")
+			g.P("return ", g.QualifiedGoIdent(corePkg.Ident("ClientStream")), "[", res, "](ctx, pid, head, first, func() (",
+				g.QualifiedGoIdent(utilPkg.Ident("IMsg")), ", bool) {")
+			g.P("return next()")
+			g.P("})")
+			g.P("}")
+		default:
+			g.P("func ", name, "(pid int64, head ", m, ", req ", req, ") (", res, ", ", m, ", uint16) {")
+			g.P("return ", g.QualifiedGoIdent(corePkg.Ident("Req")), "[", res, "](pid, head, req)")
+			g.P("}")
+			g.P()
+			g.P("func ", name, "Ctx(ctx ", ctx, ", pid int64, head ", m, ", req ", req, ") (", res, ", ", m, ", uint16) {")
+			g.P("return ", g.QualifiedGoIdent(corePkg.Ident("ReqCtx")), "[", res, "](ctx, pid, head, req)")
+			g.P("}")
+			g.P()
+			g.P("func Async", name, "(pid int64, head ", m, ", req ", req, ", onFail ",
+				g.QualifiedGoIdent(utilPkg.Ident("FnInt64MUint16")), ", onOk func(int64, ", m, ", ", res, ")) {")
+			g.P(g.QualifiedGoIdent(corePkg.Ident("AsyncReq")), "(pid, head, req, onFail, func(tid int64, head ", m, ", msg ",
+				g.QualifiedGoIdent(utilPkg.Ident("IMsg")), ") {")
+			g.P("res, ok := msg.(", res, ")")
+			g.P("if !ok {")
+			g.P("onFail(tid, head, ", g.QualifiedGoIdent(utilPkg.Ident("EcWrongType")), ")")
+			g.P("return")
+			g.P("}")
+			g.P("onOk(tid, head, res)")
+			g.P("})")
+			g.P("}")
+		}
+		g.P()
+	}
+}
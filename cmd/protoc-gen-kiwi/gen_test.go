@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+
+	"github.com/15mga/kiwi/cmd/protoc-gen-kiwi/options"
+	"google.golang.org/protobuf/cmd/protoc-gen-go/internal_gengo"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func testMethod(name, in, out string, req, res uint32, worker options.Worker, key string) *descriptorpb.MethodDescriptorProto {
+	opts := &descriptorpb.MethodOptions{}
+	proto.SetExtension(opts, options.E_Req, req)
+	proto.SetExtension(opts, options.E_Res, res)
+	proto.SetExtension(opts, options.E_Worker, worker)
+	if key != "" {
+		proto.SetExtension(opts, options.E_WorkerKey, key)
+	}
+	return &descriptorpb.MethodDescriptorProto{
+		Name:       proto.String(name),
+		InputType:  proto.String(".user." + in),
+		OutputType: proto.String(".user." + out),
+		Options:    opts,
+	}
+}
+
+// testGenerate 同时生成消息代码,返回kiwi生成的内容和所有文件
+func testGenerate(methods ...*descriptorpb.MethodDescriptorProto) (string, map[string]string, error) {
+	svcOpts := &descriptorpb.ServiceOptions{}
+	proto.SetExtension(svcOpts, options.E_Svc, uint32(3))
+	var messages []*descriptorpb.DescriptorProto
+	for _, name := range []string{"LoginReq", "LoginRes", "WatchReq", "WatchRes"} {
+		messages = append(messages, &descriptorpb.DescriptorProto{
+			Name: proto.String(name),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:     proto.String("room_id"),
+				Number:   proto.Int32(1),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum(),
+				JsonName: proto.String("roomId"),
+			}},
+		})
+	}
+	file := &descriptorpb.FileDescriptorProto{
+		Name:        proto.String("user.proto"),
+		Package:     proto.String("user"),
+		Syntax:      proto.String("proto3"),
+		Dependency:  []string{"kiwi.proto"},
+		Options:     &descriptorpb.FileOptions{GoPackage: proto.String("example.com/user")},
+		MessageType: messages,
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name:    proto.String("User"),
+			Method:  methods,
+			Options: svcOpts,
+		}},
+	}
+	req := &pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{"user.proto"},
+		ProtoFile: []*descriptorpb.FileDescriptorProto{
+			protodesc.ToFileDescriptorProto(descriptorpb.File_google_protobuf_descriptor_proto),
+			protodesc.ToFileDescriptorProto(options.File_kiwi_proto),
+			file,
+		},
+	}
+	plugin, err := protogen.Options{}.New(req)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, f := range plugin.Files {
+		if !f.Generate {
+			continue
+		}
+		internal_gengo.GenerateFile(plugin, f)
+		err = generateFile(plugin, f)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	res := plugin.Response()
+	if res.Error != nil {
+		return "", nil, errors.New(res.GetError())
+	}
+	var content string
+	nameToContent := make(map[string]string, len(res.File))
+	for _, f := range res.File {
+		nameToContent[f.GetName()] = f.GetContent()
+		if strings.HasSuffix(f.GetName(), "_kiwi.pb.go") {
+			content = f.GetContent()
+		}
+	}
+	return content, nameToContent, nil
+}
+
+// typeCheck 生成的代码和消息代码作为一个包做类型检查
+func typeCheck(nameToContent map[string]string) error {
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for name, content := range nameToContent {
+		f, err := parser.ParseFile(fset, name, content, 0)
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+	}
+	conf := types.Config{
+		Importer: importer.ForCompiler(fset, "source", nil),
+	}
+	_, err := conf.Check("example.com/user", fset, files, nil)
+	return err
+}
+
+func TestGenerate(t *testing.T) {
+	login := testMethod("Login", "LoginReq", "LoginRes", 1, 2, options.Worker_WORKER_SHARE, "room_id")
+	watch := testMethod("Watch", "WatchReq", "WatchRes", 3, 4, options.Worker_WORKER_GO, "")
+	watch.ServerStreaming = proto.Bool(true)
+	content, nameToContent, err := testGenerate(login, watch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = typeCheck(nameToContent)
+	if err != nil {
+		t.Fatal(err, "\n", content)
+	}
+	for _, s := range []string{
+		"SvcUser kiwi.TSvc = 3",
+		"CodeLoginReq kiwi.TCode = 1",
+		"CodeWatchRes kiwi.TCode = 4",
+		"kiwi.Codec().BindReqToRes(SvcUser, CodeLoginReq, CodeLoginRes)",
+		"core.SharePrcReq[*LoginReq, *LoginRes](pkt, fmt.Sprint(pkt.Msg().(*LoginReq).GetRoomId()), s.Login)",
+		"kiwi.Router().BindStream(SvcUser, CodeWatchReq, s.Watch)",
+		"func UserLogin(pid int64, head util.M, req *LoginReq) (*LoginRes, util.M, uint16)",
+		"core.ServerStream[*WatchRes](ctx, pid, head, req, fn)",
+	} {
+		if !strings.Contains(content, s) {
+			t.Fatal("missing", s, "\n", content)
+		}
+	}
+}
+
+func TestGenerateErr(t *testing.T) {
+	cases := [][]*descriptorpb.MethodDescriptorProto{
+		{testMethod("Login", "LoginReq", "LoginRes", 1, 1, options.Worker_WORKER_GO, "")},
+		{testMethod("Login", "LoginReq", "LoginRes", 1, 256, options.Worker_WORKER_GO, "")},
+		{testMethod("Login", "LoginReq", "LoginRes", 1, 2, options.Worker_WORKER_GLOBAL, "room_id")},
+		{testMethod("Login", "LoginReq", "LoginRes", 1, 2, options.Worker_WORKER_ACTIVE, "uid")},
+		{
+			testMethod("Login", "LoginReq", "LoginRes", 1, 2, options.Worker_WORKER_GO, ""),
+			testMethod("Relogin", "LoginReq", "WatchRes", 3, 4, options.Worker_WORKER_GO, ""),
+		},
+	}
+	for i, methods := range cases {
+		_, _, err := testGenerate(methods...)
+		if err == nil {
+			t.Fatal("expect error", i)
+		}
+	}
+}
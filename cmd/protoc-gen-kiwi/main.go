@@ -0,0 +1,26 @@
+// protoc-gen-kiwi 根据proto的service生成编号常量、消息注册、服务端接口和客户端方法,
+// 编号和worker通过options/kiwi.proto中的选项声明:
+//
+//	protoc -I . -I $KIWI/cmd/protoc-gen-kiwi/options --go_out=. --kiwi_out=. user.proto
+package main
+
+import (
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func main() {
+	protogen.Options{}.Run(func(plugin *protogen.Plugin) error {
+		plugin.SupportedFeatures = uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+		for _, file := range plugin.Files {
+			if !file.Generate || len(file.Services) == 0 {
+				continue
+			}
+			err := generateFile(plugin, file)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,242 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.30.0
+// 	protoc        v3.21.12
+// source: kiwi.proto
+
+package options
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	descriptorpb "google.golang.org/protobuf/types/descriptorpb"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Worker 请求处理使用的worker,对应core的*PrcReq
+type Worker int32
+
+const (
+	Worker_WORKER_GO     Worker = 0
+	Worker_WORKER_ACTIVE Worker = 1
+	Worker_WORKER_SHARE  Worker = 2
+	Worker_WORKER_GLOBAL Worker = 3
+	Worker_WORKER_SELF   Worker = 4
+)
+
+// Enum value maps for Worker.
+var (
+	Worker_name = map[int32]string{
+		0: "WORKER_GO",
+		1: "WORKER_ACTIVE",
+		2: "WORKER_SHARE",
+		3: "WORKER_GLOBAL",
+		4: "WORKER_SELF",
+	}
+	Worker_value = map[string]int32{
+		"WORKER_GO":     0,
+		"WORKER_ACTIVE": 1,
+		"WORKER_SHARE":  2,
+		"WORKER_GLOBAL": 3,
+		"WORKER_SELF":   4,
+	}
+)
+
+func (x Worker) Enum() *Worker {
+	p := new(Worker)
+	*p = x
+	return p
+}
+
+func (x Worker) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Worker) Descriptor() protoreflect.EnumDescriptor {
+	return file_kiwi_proto_enumTypes[0].Descriptor()
+}
+
+func (Worker) Type() protoreflect.EnumType {
+	return &file_kiwi_proto_enumTypes[0]
+}
+
+func (x Worker) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Worker.Descriptor instead.
+func (Worker) EnumDescriptor() ([]byte, []int) {
+	return file_kiwi_proto_rawDescGZIP(), []int{0}
+}
+
+var file_kiwi_proto_extTypes = []protoimpl.ExtensionInfo{
+	{
+		ExtendedType:  (*descriptorpb.ServiceOptions)(nil),
+		ExtensionType: (*uint32)(nil),
+		Field:         52000,
+		Name:          "kiwi.svc",
+		Tag:           "varint,52000,opt,name=svc",
+		Filename:      "kiwi.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.MethodOptions)(nil),
+		ExtensionType: (*uint32)(nil),
+		Field:         52001,
+		Name:          "kiwi.req",
+		Tag:           "varint,52001,opt,name=req",
+		Filename:      "kiwi.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.MethodOptions)(nil),
+		ExtensionType: (*uint32)(nil),
+		Field:         52002,
+		Name:          "kiwi.res",
+		Tag:           "varint,52002,opt,name=res",
+		Filename:      "kiwi.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.MethodOptions)(nil),
+		ExtensionType: (*Worker)(nil),
+		Field:         52003,
+		Name:          "kiwi.worker",
+		Tag:           "varint,52003,opt,name=worker,enum=kiwi.Worker",
+		Filename:      "kiwi.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.MethodOptions)(nil),
+		ExtensionType: (*string)(nil),
+		Field:         52004,
+		Name:          "kiwi.worker_key",
+		Tag:           "bytes,52004,opt,name=worker_key",
+		Filename:      "kiwi.proto",
+	},
+}
+
+// Extension fields to descriptorpb.ServiceOptions.
+var (
+	// svc 服务编号
+	//
+	// optional uint32 svc = 52000;
+	E_Svc = &file_kiwi_proto_extTypes[0]
+)
+
+// Extension fields to descriptorpb.MethodOptions.
+var (
+	// req 请求编号,流的编号
+	//
+	// optional uint32 req = 52001;
+	E_Req = &file_kiwi_proto_extTypes[1]
+	// res 响应编号,流中服务端发送的消息
+	//
+	// optional uint32 res = 52002;
+	E_Res = &file_kiwi_proto_extTypes[2]
+	// optional kiwi.Worker worker = 52003;
+	E_Worker = &file_kiwi_proto_extTypes[3]
+	// worker_key active和share的key,为请求的字段名,为空时使用head的id
+	//
+	// optional string worker_key = 52004;
+	E_WorkerKey = &file_kiwi_proto_extTypes[4]
+)
+
+var File_kiwi_proto protoreflect.FileDescriptor
+
+var file_kiwi_proto_rawDesc = []byte{
+	0x0a, 0x0a, 0x6b, 0x69, 0x77, 0x69, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x04, 0x6b, 0x69,
+	0x77, 0x69, 0x1a, 0x20, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x62, 0x75, 0x66, 0x2f, 0x64, 0x65, 0x73, 0x63, 0x72, 0x69, 0x70, 0x74, 0x6f, 0x72, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x2a, 0x60, 0x0a, 0x06, 0x57, 0x6f, 0x72, 0x6b, 0x65, 0x72, 0x12, 0x0d,
+	0x0a, 0x09, 0x57, 0x4f, 0x52, 0x4b, 0x45, 0x52, 0x5f, 0x47, 0x4f, 0x10, 0x00, 0x12, 0x11, 0x0a,
+	0x0d, 0x57, 0x4f, 0x52, 0x4b, 0x45, 0x52, 0x5f, 0x41, 0x43, 0x54, 0x49, 0x56, 0x45, 0x10, 0x01,
+	0x12, 0x10, 0x0a, 0x0c, 0x57, 0x4f, 0x52, 0x4b, 0x45, 0x52, 0x5f, 0x53, 0x48, 0x41, 0x52, 0x45,
+	0x10, 0x02, 0x12, 0x11, 0x0a, 0x0d, 0x57, 0x4f, 0x52, 0x4b, 0x45, 0x52, 0x5f, 0x47, 0x4c, 0x4f,
+	0x42, 0x41, 0x4c, 0x10, 0x03, 0x12, 0x0f, 0x0a, 0x0b, 0x57, 0x4f, 0x52, 0x4b, 0x45, 0x52, 0x5f,
+	0x53, 0x45, 0x4c, 0x46, 0x10, 0x04, 0x3a, 0x33, 0x0a, 0x03, 0x73, 0x76, 0x63, 0x12, 0x1f, 0x2e,
+	0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e,
+	0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x4f, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0xa0,
+	0x96, 0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x03, 0x73, 0x76, 0x63, 0x3a, 0x32, 0x0a, 0x03, 0x72,
+	0x65, 0x71, 0x12, 0x1e, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x62, 0x75, 0x66, 0x2e, 0x4d, 0x65, 0x74, 0x68, 0x6f, 0x64, 0x4f, 0x70, 0x74, 0x69, 0x6f,
+	0x6e, 0x73, 0x18, 0xa1, 0x96, 0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x03, 0x72, 0x65, 0x71, 0x3a,
+	0x32, 0x0a, 0x03, 0x72, 0x65, 0x73, 0x12, 0x1e, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x4d, 0x65, 0x74, 0x68, 0x6f, 0x64, 0x4f,
+	0x70, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0xa2, 0x96, 0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x03,
+	0x72, 0x65, 0x73, 0x3a, 0x46, 0x0a, 0x06, 0x77, 0x6f, 0x72, 0x6b, 0x65, 0x72, 0x12, 0x1e, 0x2e,
+	0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e,
+	0x4d, 0x65, 0x74, 0x68, 0x6f, 0x64, 0x4f, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0xa3, 0x96,
+	0x03, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x0c, 0x2e, 0x6b, 0x69, 0x77, 0x69, 0x2e, 0x57, 0x6f, 0x72,
+	0x6b, 0x65, 0x72, 0x52, 0x06, 0x77, 0x6f, 0x72, 0x6b, 0x65, 0x72, 0x3a, 0x3f, 0x0a, 0x0a, 0x77,
+	0x6f, 0x72, 0x6b, 0x65, 0x72, 0x5f, 0x6b, 0x65, 0x79, 0x12, 0x1e, 0x2e, 0x67, 0x6f, 0x6f, 0x67,
+	0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x4d, 0x65, 0x74, 0x68,
+	0x6f, 0x64, 0x4f, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0xa4, 0x96, 0x03, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x09, 0x77, 0x6f, 0x72, 0x6b, 0x65, 0x72, 0x4b, 0x65, 0x79, 0x42, 0x33, 0x5a, 0x31,
+	0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x31, 0x35, 0x6d, 0x67, 0x61,
+	0x2f, 0x6b, 0x69, 0x77, 0x69, 0x2f, 0x63, 0x6d, 0x64, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x63,
+	0x2d, 0x67, 0x65, 0x6e, 0x2d, 0x6b, 0x69, 0x77, 0x69, 0x2f, 0x6f, 0x70, 0x74, 0x69, 0x6f, 0x6e,
+	0x73, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_kiwi_proto_rawDescOnce sync.Once
+	file_kiwi_proto_rawDescData = file_kiwi_proto_rawDesc
+)
+
+func file_kiwi_proto_rawDescGZIP() []byte {
+	file_kiwi_proto_rawDescOnce.Do(func() {
+		file_kiwi_proto_rawDescData = protoimpl.X.CompressGZIP(file_kiwi_proto_rawDescData)
+	})
+	return file_kiwi_proto_rawDescData
+}
+
+var file_kiwi_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_kiwi_proto_goTypes = []interface{}{
+	(Worker)(0),                         // 0: kiwi.Worker
+	(*descriptorpb.ServiceOptions)(nil), // 1: google.protobuf.ServiceOptions
+	(*descriptorpb.MethodOptions)(nil),  // 2: google.protobuf.MethodOptions
+}
+var file_kiwi_proto_depIdxs = []int32{
+	1, // 0: kiwi.svc:extendee -> google.protobuf.ServiceOptions
+	2, // 1: kiwi.req:extendee -> google.protobuf.MethodOptions
+	2, // 2: kiwi.res:extendee -> google.protobuf.MethodOptions
+	2, // 3: kiwi.worker:extendee -> google.protobuf.MethodOptions
+	2, // 4: kiwi.worker_key:extendee -> google.protobuf.MethodOptions
+	0, // 5: kiwi.worker:type_name -> kiwi.Worker
+	6, // [6:6] is the sub-list for method output_type
+	6, // [6:6] is the sub-list for method input_type
+	5, // [5:6] is the sub-list for extension type_name
+	0, // [0:5] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_kiwi_proto_init() }
+func file_kiwi_proto_init() {
+	if File_kiwi_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_kiwi_proto_rawDesc,
+			NumEnums:      1,
+			NumMessages:   0,
+			NumExtensions: 5,
+			NumServices:   0,
+		},
+		GoTypes:           file_kiwi_proto_goTypes,
+		DependencyIndexes: file_kiwi_proto_depIdxs,
+		EnumInfos:         file_kiwi_proto_enumTypes,
+		ExtensionInfos:    file_kiwi_proto_extTypes,
+	}.Build()
+	File_kiwi_proto = out.File
+	file_kiwi_proto_rawDesc = nil
+	file_kiwi_proto_goTypes = nil
+	file_kiwi_proto_depIdxs = nil
+}
@@ -3,6 +3,7 @@ package kiwi
 import (
 	"github.com/15mga/kiwi/util"
 	"reflect"
+	"time"
 )
 
 var (
@@ -29,9 +30,27 @@ type ICodec interface {
 	BindFac(svc TSvc, mtd TCode, fac util.ToMsg)
 	BindReqToRes(svc TSvc, req, res TCode)
 	ReqToResCode(svc TSvc, req TCode) (TCode, *util.Err)
+	BindRetry(svc TSvc, req TCode, policy *RetryPolicy)
+	GetRetry(svc TSvc, req TCode) (*RetryPolicy, bool)
 	MsgToSvcCode(msg util.IMsg) (svc TSvc, code TCode)
 }
 
+// RetryPolicy 请求的重试和对冲策略,只能用于幂等的请求,所有尝试使用相同的tid,最先到达的响应生效,
+// 其他节点收到取消,还没开始处理时丢弃,已经开始的处理照常执行,响应到达后直接丢弃
+type RetryPolicy struct {
+	// MaxAttempts 包括第一次发送和对冲的总次数
+	MaxAttempts int
+	// Backoff 第n次重试前等待Backoff*2^(n-1),不超过MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Codes 可以重试的失败码,为空时只重试EcUnavailable、EcBusy、EcSendErr
+	Codes []uint16
+	// Hedge 超过HedgeDelay没有响应时向其他节点再发送一次
+	Hedge bool
+	// HedgeDelay 为0时使用最近响应耗时的p95
+	HedgeDelay time.Duration
+}
+
 func CodecSpawn[T any](svc TSvc, mtd TCode) (T, *util.Err) {
 	o, err := _Codec.Spawn(svc, mtd)
 	if err != nil {
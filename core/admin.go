@@ -0,0 +1,481 @@
+package core
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/ecs"
+	"github.com/15mga/kiwi/util"
+	"github.com/15mga/kiwi/worker"
+)
+
+var (
+	// AdminTimeout 等待worker返回信息的时间
+	AdminTimeout = 3 * time.Second
+)
+
+type (
+	AdminOption func(option *adminOption)
+	adminOption struct {
+		token string
+	}
+	infoGetter interface {
+		Info(fn util.FnM)
+	}
+)
+
+// AdminToken 请求需要带上Authorization: Bearer token
+func AdminToken(token string) AdminOption {
+	return func(option *adminOption) {
+		option.token = token
+	}
+}
+
+// StartAdmin 管理控制台,与gate分开监听,没有设置AdminToken时只能监听回环地址
+func StartAdmin(addr string, opts ...AdminOption) *util.Err {
+	o := &adminOption{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	ln, e := net.Listen("tcp", addr)
+	if e != nil {
+		return util.WrapErr(util.EcListenErr, e)
+	}
+	if o.token == "" {
+		if tcpAddr, ok := ln.Addr().(*net.TCPAddr); !ok || !tcpAddr.IP.IsLoopback() {
+			_ = ln.Close()
+			return util.NewErr(util.EcParamsErr, util.M{
+				"addr":  addr,
+				"error": "admin token required for non-loopback address",
+			})
+		}
+	}
+	a := &admin{
+		option: o,
+		mux:    http.NewServeMux(),
+	}
+	a.handle("/", a.index)
+	a.handle("/node", a.node)
+	a.handle("/dialers", a.dialers)
+	a.handle("/agents", a.agents)
+	a.handle("/handlers", a.handlers)
+	a.handle("/workers", a.workers)
+	a.handle("/ecs", a.ecs)
+	a.handle("/agent/kick", a.kick)
+	a.handle("/node/disconnect", a.disconnect)
+	a.handle("/log/level", a.logLevel)
+	a.handle("/log/rule", a.logRule)
+	a.handle("/deadletter", a.deadLetter)
+	a.handle("/deadletter/replay", a.replayDeadLetter)
+	a.handle("/deadletter/del", a.delDeadLetter)
+	svr := &http.Server{
+		Handler: a.mux,
+	}
+	go func() {
+		e := svr.Serve(ln)
+		if e != nil && !errors.Is(e, http.ErrServerClosed) {
+			kiwi.Error(util.WrapErr(util.EcListenErr, e))
+		}
+	}()
+	go func() {
+		<-util.Ctx().Done()
+		_ = svr.Close()
+	}()
+	kiwi.Info("admin serve", util.M{
+		"addr": ln.Addr().String(),
+	})
+	return nil
+}
+
+type admin struct {
+	option *adminOption
+	mux    *http.ServeMux
+}
+
+func (a *admin) handle(path string, fn func(r *http.Request) (any, *util.Err)) {
+	a.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if a.option.token != "" && subtle.ConstantTimeCompare(
+			[]byte(r.Header.Get("Authorization")), []byte("Bearer "+a.option.token)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		res, err := fn(r)
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			switch err.Code() {
+			case util.EcParamsErr, util.EcNotExist:
+				w.WriteHeader(http.StatusBadRequest)
+			case util.EcIllegalOp:
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			default:
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			res = util.M{
+				"error": err.Error(),
+			}
+		}
+		bytes, err := util.JsonMarshal(res)
+		if err != nil {
+			kiwi.Error(err)
+			return
+		}
+		_, _ = w.Write(bytes)
+	})
+}
+
+func (a *admin) index(r *http.Request) (any, *util.Err) {
+	if r.URL.Path != "/" {
+		return nil, util.NewErr(util.EcNotExist, util.M{
+			"path": r.URL.Path,
+		})
+	}
+	return []string{
+		"GET /node", "GET /dialers", "GET /agents", "GET /handlers", "GET /workers", "GET /ecs",
+		"POST /agent/kick?id=", "POST /node/disconnect?svc=&node=", "POST /log/level?logger=&log=&trace=&broadcast=1",
+		"GET /log/rule", "POST /log/rule?svc=&code=&ratio=&trace=&broadcast=1",
+		"GET /deadletter?start=&limit=", "POST /deadletter/replay?id=", "POST /deadletter/del?id=",
+	}, nil
+}
+
+func (a *admin) node(*http.Request) (any, *util.Err) {
+	return kiwi.GetNodeMeta(), nil
+}
+
+func (a *admin) dialers(*http.Request) (any, *util.Err) {
+	getter, ok := kiwi.Node().(infoGetter)
+	if !ok {
+		return util.M{}, nil
+	}
+	return waitInfo(getter.Info)
+}
+
+func (a *admin) agents(*http.Request) (any, *util.Err) {
+	getter, ok := kiwi.Gate().(infoGetter)
+	if !ok {
+		return util.M{}, nil
+	}
+	return waitInfo(getter.Info)
+}
+
+func (a *admin) handlers(*http.Request) (any, *util.Err) {
+	r, ok := kiwi.Router().(*router)
+	if !ok {
+		return util.M{}, nil
+	}
+	return r.Handlers(), nil
+}
+
+func (a *admin) workers(*http.Request) (any, *util.Err) {
+	m := util.M{}
+	if s := worker.Share(); s != nil {
+		m["share"] = s.Len()
+	}
+	if g := worker.Global(); g != nil {
+		m["global"] = g.Len()
+	}
+	if act := worker.Active(); act != nil {
+		ids, err := waitInfo(act.Info)
+		if err != nil {
+			return nil, err
+		}
+		m["active"] = ids
+		m["active len"] = act.Len()
+	}
+	return m, nil
+}
+
+func (a *admin) ecs(*http.Request) (any, *util.Err) {
+	var (
+		mtx    sync.Mutex
+		frames []util.M
+		wg     sync.WaitGroup
+	)
+	ecs.IterFrames(func(frame *ecs.Frame) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m, err := waitInfo(frame.Info)
+			if err != nil {
+				return
+			}
+			mtx.Lock()
+			frames = append(frames, m)
+			mtx.Unlock()
+		}()
+	})
+	wg.Wait()
+	return frames, nil
+}
+
+func (a *admin) kick(r *http.Request) (any, *util.Err) {
+	if r.Method != http.MethodPost {
+		return nil, util.NewErr(util.EcIllegalOp, util.M{
+			"method": r.Method,
+		})
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		return nil, util.NewErr(util.EcParamsErr, util.M{
+			"id": id,
+		})
+	}
+	if kiwi.Gate() == nil {
+		return nil, util.NewErr(util.EcNotExist, util.M{
+			"error": "gate not exist",
+		})
+	}
+	kiwi.Gate().CloseWithId(0, id, nil, nil)
+	kiwi.Info("admin kick agent", util.M{
+		"id": id,
+	})
+	return util.M{"id": id}, nil
+}
+
+func (a *admin) disconnect(r *http.Request) (any, *util.Err) {
+	if r.Method != http.MethodPost {
+		return nil, util.NewErr(util.EcIllegalOp, util.M{
+			"method": r.Method,
+		})
+	}
+	q := r.URL.Query()
+	svc, e1 := strconv.ParseUint(q.Get("svc"), 10, 16)
+	nodeId, e2 := strconv.ParseInt(q.Get("node"), 10, 64)
+	if e1 != nil || e2 != nil {
+		return nil, util.NewErr(util.EcParamsErr, util.M{
+			"svc":  q.Get("svc"),
+			"node": q.Get("node"),
+		})
+	}
+	kiwi.Node().Disconnect(kiwi.TSvc(svc), nodeId)
+	kiwi.Info("admin disconnect node", util.M{
+		"svc":     svc,
+		"node id": nodeId,
+	})
+	return util.M{"svc": svc, "node": nodeId}, nil
+}
+
+func (a *admin) logLevel(r *http.Request) (any, *util.Err) {
+	if r.Method != http.MethodPost {
+		return nil, util.NewErr(util.EcIllegalOp, util.M{
+			"method": r.Method,
+		})
+	}
+	q := r.URL.Query()
+	var logLvl, traceLvl kiwi.TLevel
+	if str := q.Get("log"); str != "" {
+		logLvl = kiwi.StrLvlToMask(strings.Split(str, ",")...)
+	}
+	if str := q.Get("trace"); str != "" {
+		traceLvl = kiwi.StrLvlToMask(strings.Split(str, ",")...)
+	}
+	if logLvl == 0 && traceLvl == 0 {
+		return nil, util.NewErr(util.EcParamsErr, util.M{
+			"log":   q.Get("log"),
+			"trace": q.Get("trace"),
+		})
+	}
+	ctrl := &LogCtrl{
+		Logger:   q.Get("logger"),
+		LogLvl:   logLvl,
+		TraceLvl: traceLvl,
+	}
+	if ctrl.Logger == "" {
+		ctrl.Logger = "*"
+	}
+	err := applyLogCtrl(ctrl, q.Get("broadcast") == "1")
+	if err != nil {
+		return nil, err
+	}
+	kiwi.Info("admin log level", util.M{
+		"logger": ctrl.Logger,
+		"log":    q.Get("log"),
+		"trace":  q.Get("trace"),
+	})
+	return ctrl, nil
+}
+
+// logRule GET返回所有规则,POST设置规则,不传code时作用于整个服务,不传ratio时删除
+func (a *admin) logRule(r *http.Request) (any, *util.Err) {
+	if r.Method == http.MethodGet {
+		return LogRules(), nil
+	}
+	if r.Method != http.MethodPost {
+		return nil, util.NewErr(util.EcIllegalOp, util.M{
+			"method": r.Method,
+		})
+	}
+	q := r.URL.Query()
+	svc, e := strconv.ParseUint(q.Get("svc"), 10, 16)
+	if e != nil {
+		return nil, util.NewErr(util.EcParamsErr, util.M{
+			"svc": q.Get("svc"),
+		})
+	}
+	ctrl := &LogCtrl{
+		Svc:     kiwi.TSvc(svc),
+		AllCode: q.Get("code") == "",
+	}
+	if !ctrl.AllCode {
+		code, e := strconv.ParseUint(q.Get("code"), 10, 16)
+		if e != nil {
+			return nil, util.NewErr(util.EcParamsErr, util.M{
+				"code": q.Get("code"),
+			})
+		}
+		ctrl.Code = kiwi.TCode(code)
+	}
+	if str := q.Get("ratio"); str != "" {
+		ratio, e := strconv.ParseFloat(str, 64)
+		if e != nil || ratio < 0 || ratio > 1 {
+			return nil, util.NewErr(util.EcParamsErr, util.M{
+				"ratio": str,
+			})
+		}
+		ctrl.Rule = &LogRule{
+			Ratio: ratio,
+		}
+		if str := q.Get("trace"); str != "" {
+			ctrl.Rule.TraceLvl = kiwi.StrLvlToMask(strings.Split(str, ",")...)
+		}
+	}
+	err := applyLogCtrl(ctrl, q.Get("broadcast") == "1")
+	if err != nil {
+		return nil, err
+	}
+	kiwi.Info("admin log rule", util.M{
+		"svc":   q.Get("svc"),
+		"code":  q.Get("code"),
+		"ratio": q.Get("ratio"),
+		"trace": q.Get("trace"),
+	})
+	return ctrl, nil
+}
+
+// deadLetter 默认返回前100条
+func (a *admin) deadLetter(r *http.Request) (any, *util.Err) {
+	if _DeadLetter == nil {
+		return nil, util.NewErr(util.EcNotExist, util.M{
+			"error": "dead letter not exist",
+		})
+	}
+	q := r.URL.Query()
+	start, limit := 0, 100
+	if str := q.Get("start"); str != "" {
+		v, e := strconv.Atoi(str)
+		if e != nil || v < 0 {
+			return nil, util.NewErr(util.EcParamsErr, util.M{
+				"start": str,
+			})
+		}
+		start = v
+	}
+	if str := q.Get("limit"); str != "" {
+		v, e := strconv.Atoi(str)
+		if e != nil || v <= 0 {
+			return nil, util.NewErr(util.EcParamsErr, util.M{
+				"limit": str,
+			})
+		}
+		limit = v
+	}
+	letters, total, err := _DeadLetter.List(start, limit)
+	if err != nil {
+		return nil, err
+	}
+	return util.M{
+		"total":   total,
+		"letters": letters,
+	}, nil
+}
+
+// replayDeadLetter 不传id时重放所有死信
+func (a *admin) replayDeadLetter(r *http.Request) (any, *util.Err) {
+	if r.Method != http.MethodPost {
+		return nil, util.NewErr(util.EcIllegalOp, util.M{
+			"method": r.Method,
+		})
+	}
+	str := r.URL.Query().Get("id")
+	if str == "" {
+		ok, fail, err := ReplayDeadLetters()
+		if err != nil {
+			return nil, err
+		}
+		kiwi.Info("admin replay dead letters", util.M{
+			"ok":   ok,
+			"fail": fail,
+		})
+		return util.M{"ok": ok, "fail": fail}, nil
+	}
+	id, e := strconv.ParseInt(str, 10, 64)
+	if e != nil {
+		return nil, util.NewErr(util.EcParamsErr, util.M{
+			"id": str,
+		})
+	}
+	err := ReplayDeadLetter(id)
+	if err != nil {
+		return nil, err
+	}
+	kiwi.Info("admin replay dead letter", util.M{
+		"id": id,
+	})
+	return util.M{"id": id}, nil
+}
+
+func (a *admin) delDeadLetter(r *http.Request) (any, *util.Err) {
+	if r.Method != http.MethodPost {
+		return nil, util.NewErr(util.EcIllegalOp, util.M{
+			"method": r.Method,
+		})
+	}
+	str := r.URL.Query().Get("id")
+	id, e := strconv.ParseInt(str, 10, 64)
+	if e != nil {
+		return nil, util.NewErr(util.EcParamsErr, util.M{
+			"id": str,
+		})
+	}
+	if _DeadLetter == nil {
+		return nil, util.NewErr(util.EcNotExist, util.M{
+			"error": "dead letter not exist",
+		})
+	}
+	err := _DeadLetter.Del(id)
+	if err != nil {
+		return nil, err
+	}
+	kiwi.Info("admin delete dead letter", util.M{
+		"id": id,
+	})
+	return util.M{"id": id}, nil
+}
+
+func applyLogCtrl(ctrl *LogCtrl, broadcast bool) *util.Err {
+	if broadcast {
+		return BroadcastLogCtrl(ctrl)
+	}
+	return ApplyLogCtrl(ctrl)
+}
+
+func waitInfo(push func(util.FnM)) (util.M, *util.Err) {
+	ch := make(chan util.M, 1)
+	push(func(m util.M) {
+		ch <- m
+	})
+	timer := time.NewTimer(AdminTimeout)
+	defer timer.Stop()
+	select {
+	case m := <-ch:
+		return m, nil
+	case <-timer.C:
+		return nil, util.NewErr(util.EcTimeout, nil)
+	}
+}
@@ -0,0 +1,37 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/15mga/kiwi/util"
+)
+
+func TestAdminAuth(t *testing.T) {
+	if err := StartAdmin("0.0.0.0:0"); err == nil || err.Code() != util.EcParamsErr {
+		t.Fatal("non-loopback without token", err)
+	}
+	a := &admin{
+		option: &adminOption{token: "secret"},
+		mux:    http.NewServeMux(),
+	}
+	a.handle("/x", func(*http.Request) (any, *util.Err) {
+		return util.M{}, nil
+	})
+	for auth, status := range map[string]int{
+		"":              http.StatusUnauthorized,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"Bearer secret": http.StatusOK,
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/x", nil)
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		a.mux.ServeHTTP(w, r)
+		if w.Code != status {
+			t.Fatal(auth, w.Code)
+		}
+	}
+}
@@ -10,6 +10,7 @@ func InitCodec() {
 		fac:          make(map[kiwi.TSvcCode]util.ToMsg),
 		msgToSvcCode: make(map[string]kiwi.TSvcCode),
 		reqToRes:     make(map[kiwi.TSvcCode]kiwi.TCode),
+		retry:        make(map[kiwi.TSvcCode]*kiwi.RetryPolicy),
 	})
 }
 
@@ -17,6 +18,7 @@ type codec struct {
 	fac          map[kiwi.TSvcCode]util.ToMsg
 	msgToSvcCode map[string]kiwi.TSvcCode
 	reqToRes     map[kiwi.TSvcCode]kiwi.TCode
+	retry        map[kiwi.TSvcCode]*kiwi.RetryPolicy
 }
 
 func (c *codec) PbMarshal(obj util.IMsg) ([]byte, *util.Err) {
@@ -34,7 +36,11 @@ func (c *codec) PbUnmarshal(data []byte, msg util.IMsg) *util.Err {
 	if len(data) == 0 {
 		return nil
 	}
-	return util.WrapErr(util.EcUnmarshallErr, util.PbUnmarshal(data, msg))
+	e := util.PbUnmarshal(data, msg)
+	if e != nil {
+		return util.WrapErr(util.EcUnmarshallErr, e)
+	}
+	return nil
 }
 
 func (c *codec) PbUnmarshal2(svc kiwi.TSvc, code kiwi.TCode, data []byte) (util.IMsg, *util.Err) {
@@ -71,7 +77,7 @@ func (c *codec) JsonUnmarshal(data []byte, msg util.IMsg) *util.Err {
 	if len(data) == 0 {
 		return nil
 	}
-	return util.WrapErr(util.EcUnmarshallErr, util.JsonUnmarshal(data, msg))
+	return util.JsonUnmarshal(data, msg)
 }
 
 func (c *codec) JsonUnmarshal2(svc kiwi.TSvc, code kiwi.TCode, data []byte) (util.IMsg, *util.Err) {
@@ -140,6 +146,16 @@ func (c *codec) ReqToResCode(svc kiwi.TSvc, req kiwi.TCode) (kiwi.TCode, *util.E
 	return res, nil
 }
 
+// BindRetry 和BindReqToRes一样在启动时调用
+func (c *codec) BindRetry(svc kiwi.TSvc, req kiwi.TCode, policy *kiwi.RetryPolicy) {
+	c.retry[kiwi.MergeSvcCode(svc, req)] = policy
+}
+
+func (c *codec) GetRetry(svc kiwi.TSvc, req kiwi.TCode) (*kiwi.RetryPolicy, bool) {
+	policy, ok := c.retry[kiwi.MergeSvcCode(svc, req)]
+	return policy, ok
+}
+
 func (c *codec) MsgToSvcCode(msg util.IMsg) (kiwi.TSvc, kiwi.TCode) {
 	name := string(msg.ProtoReflect().Descriptor().Name())
 	sc := c.msgToSvcCode[name]
@@ -8,6 +8,15 @@ const (
 	HdFail
 	HdWatch
 	HdNotify
+	HdLog
+	HdGate
+	HdStreamOpen
+	HdStreamData
+	HdStreamEnd
+	HdStreamCancel
+	HdStreamCredit
+	HdResume
+	HdCancel
 )
 
 var (
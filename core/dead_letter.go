@@ -0,0 +1,218 @@
+package core
+
+import (
+	"time"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/sid"
+	"github.com/15mga/kiwi/util"
+	"github.com/15mga/kiwi/worker"
+)
+
+var (
+	// _DeadLetter 为nil时无法投递的推送和通知只记录日志
+	_DeadLetter kiwi.IDeadLetter
+	// _DeadLetterWorker 写入存储可能有io,不占用节点的worker
+	_DeadLetterWorker *worker.FnWorker
+)
+
+// BindDeadLetter 保存无法投递的推送和通知,可以通过ReplayDeadLetter重新投递
+func BindDeadLetter(store kiwi.IDeadLetter) {
+	if _DeadLetterWorker == nil {
+		_DeadLetterWorker = worker.NewFnWorker()
+		_DeadLetterWorker.Start()
+	}
+	_DeadLetter = store
+}
+
+func DeadLetter() kiwi.IDeadLetter {
+	return _DeadLetter
+}
+
+func addDeadLetter(typ uint8, tid int64, head util.M, bytes []byte, reason *util.Err) {
+	if _DeadLetter == nil || reason == nil {
+		return
+	}
+	letter := &kiwi.DeadLetter{
+		Id:      sid.GetId(),
+		Ts:      time.Now().UnixMilli(),
+		Tid:     tid,
+		Type:    typ,
+		Head:    head,
+		Bytes:   bytes,
+		ErrCode: reason.Code(),
+		Reason:  reason.Error(),
+	}
+	letter.Svc, _ = util.MGet[kiwi.TSvc](head, HeadSvc)
+	letter.Code, _ = util.MGet[kiwi.TCode](head, HeadCode)
+	_DeadLetterWorker.Push(storeDeadLetter, _DeadLetter, letter)
+}
+
+func storeDeadLetter(params []any) {
+	store, letter := util.SplitSlc2[kiwi.IDeadLetter, *kiwi.DeadLetter](params)
+	err := store.Add(letter)
+	if err != nil {
+		kiwi.TE(letter.Tid, err)
+	}
+}
+
+// addRcvDeadLetter 已经解包的消息重新打包,格式与UnpackPush、UnpackNotify一致
+func addRcvDeadLetter(typ uint8, pkt kiwi.IRcvPkt, reason *util.Err) {
+	if _DeadLetter == nil {
+		return
+	}
+	var (
+		payload []byte
+		err     *util.Err
+	)
+	if pkt.Json() {
+		payload, err = kiwi.Codec().JsonMarshal(pkt.Msg())
+	} else {
+		payload, err = kiwi.Codec().PbMarshal(pkt.Msg())
+	}
+	if err != nil {
+		kiwi.TE(pkt.Tid(), err)
+		return
+	}
+	var buffer util.ByteBuffer
+	buffer.InitCap(len(payload) + 128)
+	buffer.WUint8(typ)
+	buffer.WInt64(pkt.Tid())
+	err = buffer.WMAny(pkt.Head())
+	if err != nil {
+		kiwi.TE(pkt.Tid(), err)
+		return
+	}
+	buffer.WBool(pkt.Json())
+	_, _ = buffer.Write(payload)
+	addDeadLetter(typ, pkt.Tid(), pkt.Head(), buffer.All(), reason)
+}
+
+// ReplayDeadLetter 重新投递,本节点有处理方法时交给router,否则发送到服务所在节点,成功后删除
+func ReplayDeadLetter(id int64) *util.Err {
+	if _DeadLetter == nil {
+		return util.NewErr(util.EcNotExist, util.M{
+			"error": "dead letter not exist",
+		})
+	}
+	letter, err := _DeadLetter.Get(id)
+	if err != nil {
+		return err
+	}
+	err = replayLetter(letter)
+	if err != nil {
+		return err
+	}
+	return _DeadLetter.Del(id)
+}
+
+// ReplayDeadLetters 按写入顺序重新投递所有死信,成功的一次删除,返回失败的id
+func ReplayDeadLetters() (ok int, fail []int64, err *util.Err) {
+	if _DeadLetter == nil {
+		return 0, nil, util.NewErr(util.EcNotExist, util.M{
+			"error": "dead letter not exist",
+		})
+	}
+	_, total, err := _DeadLetter.List(0, 0)
+	if err != nil {
+		return
+	}
+	letters, _, err := _DeadLetter.List(0, total)
+	if err != nil {
+		return
+	}
+	ids := make([]int64, 0, len(letters))
+	for _, letter := range letters {
+		e := replayLetter(letter)
+		if e != nil {
+			kiwi.TE(letter.Tid, e)
+			fail = append(fail, letter.Id)
+			continue
+		}
+		ids = append(ids, letter.Id)
+	}
+	ok = len(ids)
+	if ok > 0 {
+		err = _DeadLetter.Del(ids...)
+	}
+	return
+}
+
+func replayLetter(letter *kiwi.DeadLetter) *util.Err {
+	err := replayDeadLetter(letter)
+	if err != nil {
+		err.AddParam("id", letter.Id)
+		return err
+	}
+	kiwi.TI(letter.Tid, "replay dead letter", util.M{
+		"id":   letter.Id,
+		"svc":  letter.Svc,
+		"code": letter.Code,
+	})
+	return nil
+}
+
+func replayDeadLetter(letter *kiwi.DeadLetter) *util.Err {
+	r, _ := kiwi.Router().(*router)
+	sc := kiwi.MergeSvcCode(letter.Svc, letter.Code)
+	switch letter.Type {
+	case HdPush:
+		if r != nil && r.hasPus(sc) {
+			pkt := NewRcvPusPkt()
+			err := kiwi.Packer().UnpackPush(letter.Bytes, pkt)
+			if err != nil {
+				return err
+			}
+			kiwi.Router().OnPush(pkt)
+			return nil
+		}
+		return replayToNode(letter)
+	case HdNotify:
+		if r == nil || !r.hasNotice(sc) {
+			return util.NewErr(util.EcNotExist, util.M{
+				"service": letter.Svc,
+				"code":    letter.Code,
+			})
+		}
+		pkt := NewRcvNtfPkt()
+		err := kiwi.Packer().UnpackNotify(letter.Bytes, pkt)
+		if err != nil {
+			return err
+		}
+		kiwi.Router().OnNotice(pkt)
+		return nil
+	default:
+		return util.NewErr(util.EcWrongType, util.M{
+			"type": letter.Type,
+		})
+	}
+}
+
+// replayToNode 直接发送到选中的节点,失败时保留死信,不会再次写入
+func replayToNode(letter *kiwi.DeadLetter) *util.Err {
+	s, ok := kiwi.Node().(nodeSelector)
+	if !ok || kiwi.GetNodeMeta().HasService(letter.Svc) {
+		return util.NewErr(util.EcNotExist, util.M{
+			"service": letter.Svc,
+			"code":    letter.Code,
+		})
+	}
+	//存储中的head经过序列化,类型可能改变,从数据中读取
+	var buffer util.ByteBuffer
+	buffer.InitBytes(letter.Bytes)
+	buffer.SetPos(9)
+	head := util.M{}
+	err := buffer.RMAny(head)
+	if err != nil {
+		return err
+	}
+	nodeId, err := s.selectNode(letter.Svc, head)
+	if err != nil {
+		return err
+	}
+	ch := make(chan *util.Err, 1)
+	kiwi.Node().SendToNode(nodeId, letter.Bytes, func(err *util.Err) {
+		ch <- err
+	})
+	return <-ch
+}
@@ -0,0 +1,130 @@
+package core
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// memDeadLetter 记录Del的调用次数
+type memDeadLetter struct {
+	mtx     sync.Mutex
+	letters []*kiwi.DeadLetter
+	dels    int
+}
+
+func (s *memDeadLetter) Add(letter *kiwi.DeadLetter) *util.Err {
+	s.mtx.Lock()
+	s.letters = append(s.letters, letter)
+	s.mtx.Unlock()
+	return nil
+}
+
+func (s *memDeadLetter) List(start, limit int) ([]*kiwi.DeadLetter, int, *util.Err) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	total := len(s.letters)
+	if start > total {
+		start = total
+	}
+	end := start + limit
+	if end > total {
+		end = total
+	}
+	return append([]*kiwi.DeadLetter(nil), s.letters[start:end]...), total, nil
+}
+
+func (s *memDeadLetter) Get(id int64) (*kiwi.DeadLetter, *util.Err) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for _, letter := range s.letters {
+		if letter.Id == id {
+			return letter, nil
+		}
+	}
+	return nil, util.NewErr(util.EcNotExist, util.M{
+		"id": id,
+	})
+}
+
+func (s *memDeadLetter) Del(ids ...int64) *util.Err {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.dels++
+	idToDel := make(map[int64]struct{}, len(ids))
+	for _, id := range ids {
+		idToDel[id] = struct{}{}
+	}
+	letters := s.letters[:0]
+	for _, letter := range s.letters {
+		if _, ok := idToDel[letter.Id]; !ok {
+			letters = append(letters, letter)
+		}
+	}
+	s.letters = letters
+	return nil
+}
+
+func testPushLetter(t *testing.T, id int64, str string) *kiwi.DeadLetter {
+	payload, e := proto.Marshal(wrapperspb.String(str))
+	if e != nil {
+		t.Fatal(e)
+	}
+	head := util.M{HeadSvc: kiwi.TSvc(51), HeadCode: kiwi.TCode(1)}
+	var buffer util.ByteBuffer
+	buffer.InitCap(128)
+	buffer.WUint8(HdPush)
+	buffer.WInt64(id)
+	err := buffer.WMAny(head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buffer.WBool(false)
+	_, _ = buffer.Write(payload)
+	return &kiwi.DeadLetter{
+		Id:    id,
+		Tid:   id,
+		Type:  HdPush,
+		Svc:   51,
+		Code:  1,
+		Head:  head,
+		Bytes: buffer.All(),
+	}
+}
+
+func TestReplayDeadLetters(t *testing.T) {
+	testDropNode()
+	InitPacker()
+	kiwi.Codec().BindFac(51, 1, func() util.IMsg {
+		return &wrapperspb.StringValue{}
+	})
+	var received []string
+	kiwi.Router().BindPus(51, 1, func(pkt kiwi.IRcvPush) {
+		received = append(received, pkt.Msg().(*wrapperspb.StringValue).GetValue())
+	})
+	store := &memDeadLetter{}
+	BindDeadLetter(store)
+	defer BindDeadLetter(nil)
+	_ = store.Add(testPushLetter(t, 1, "a"))
+	_ = store.Add(&kiwi.DeadLetter{Id: 2, Tid: 2, Type: 0xff})
+	_ = store.Add(testPushLetter(t, 3, "b"))
+
+	ok, fail, err := ReplayDeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok != 2 || len(fail) != 1 || fail[0] != 2 {
+		t.Fatal("replay", ok, fail)
+	}
+	if len(received) != 2 || received[0] != "a" || received[1] != "b" {
+		t.Fatal("received", received)
+	}
+	//成功的一次删除
+	if store.dels != 1 || len(store.letters) != 1 || store.letters[0].Id != 2 {
+		t.Fatal("del", store.dels, len(store.letters))
+	}
+}
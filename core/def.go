@@ -3,6 +3,7 @@ package core
 import (
 	"github.com/15mga/kiwi"
 	"github.com/15mga/kiwi/log"
+	"github.com/15mga/kiwi/metrics"
 	"github.com/15mga/kiwi/util/mgo"
 	"github.com/15mga/kiwi/util/rds"
 	"github.com/15mga/kiwi/worker"
@@ -20,6 +21,8 @@ type option struct {
 	services []kiwi.IService
 	gate     *Gate
 	loggers  []kiwi.ILogger
+	metrics  string
+	admin    *Admin
 }
 
 type Meta struct {
@@ -46,6 +49,28 @@ func SetServices(services []kiwi.IService) Option {
 	}
 }
 
+// SetMetrics 在addr上提供Prometheus的/metrics
+func SetMetrics(addr string) Option {
+	return func(o *option) {
+		o.metrics = addr
+	}
+}
+
+type Admin struct {
+	addr    string
+	options []AdminOption
+}
+
+// SetAdmin 开启管理控制台
+func SetAdmin(addr string, options ...AdminOption) Option {
+	return func(o *option) {
+		o.admin = &Admin{
+			addr:    addr,
+			options: options,
+		}
+	}
+}
+
 type Mongo struct {
 	uri     string
 	db      string
@@ -182,10 +207,25 @@ func StartDefault(opts ...Option) {
 	InitRouter()
 	RegisterSvc(opt.services...)
 
+	if opt.metrics != "" {
+		err := metrics.Serve(opt.metrics)
+		if err != nil {
+			kiwi.Fatal(err)
+		}
+	}
+
+	if opt.admin != nil {
+		err := StartAdmin(opt.admin.addr, opt.admin.options...)
+		if err != nil {
+			kiwi.Fatal(err)
+		}
+	}
+
 	if opt.gate != nil {
 		InitGate(opt.gate.receiver, opt.gate.options...)
 	}
 	StartAllService()
+	kiwi.BeforeExitDrain(Drain)
 	kiwi.WaitExit()
 }
 
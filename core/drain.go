@@ -0,0 +1,70 @@
+package core
+
+import (
+	"time"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+)
+
+var (
+	// DrainNotifyDur 标记排空后等待其他节点停止选择本节点
+	DrainNotifyDur = time.Second
+	// DrainTimeout 等待处理中的包和待发送响应的最长时间
+	DrainTimeout = 30 * time.Second
+)
+
+type nodeFlusher interface {
+	flush(deadline time.Time) bool
+}
+
+// Drain 排空本节点后关闭:
+// 通知发现服务标记排空,等待处理中的包完成,关闭服务,发送完剩余的响应,
+// 最后注销发现服务并关闭监听
+func Drain() {
+	meta := kiwi.GetNodeMeta()
+	if meta.Draining {
+		return
+	}
+	meta.Draining = true
+	kiwi.Info("node draining", util.M{
+		"node id": meta.NodeId,
+	})
+	kiwi.DispatchEvent(kiwi.Evt_Node_Draining, meta)
+	time.Sleep(DrainNotifyDur)
+
+	deadline := time.Now().Add(DrainTimeout)
+	if !waitUntil(deadline, func() bool {
+		return ReceivePktCount() == CompletePktCount()
+	}) {
+		kiwi.Warn2(util.EcTimeout, util.M{
+			"receive":  ReceivePktCount(),
+			"complete": CompletePktCount(),
+		})
+	}
+	ShutdownAllService()
+	if f, ok := kiwi.Node().(nodeFlusher); ok && !f.flush(deadline) {
+		kiwi.Warn2(util.EcTimeout, util.M{
+			"error": "flush response timeout",
+		})
+	}
+
+	kiwi.DispatchEvent(kiwi.Evt_Node_Drained, meta)
+	if kiwi.Gate() != nil {
+		_ = kiwi.Gate().Dispose()
+	}
+	kiwi.Node().Dispose()
+	kiwi.Info("node drained", util.M{
+		"node id": meta.NodeId,
+	})
+}
+
+func waitUntil(deadline time.Time, fn util.ToBool) bool {
+	for !fn() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return true
+}
@@ -1,18 +1,20 @@
 package core
 
 import (
+	"crypto/tls"
 	"fmt"
 	"github.com/15mga/kiwi"
 	"net"
-	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/15mga/kiwi/ds"
 	"github.com/15mga/kiwi/network"
 	"github.com/15mga/kiwi/util"
 	"github.com/15mga/kiwi/worker"
 	"github.com/fasthttp/websocket"
+	"github.com/xtaci/kcp-go/v5"
 )
 
 const (
@@ -33,6 +35,26 @@ type (
 		deadline     int
 		headLen      int
 		roles        map[kiwi.TSvcCode][]int64
+		agentLimit   *GateLimit
+		codeLimits   map[kiwi.TSvcCode]*GateLimit
+		ipBytes      int
+		msgSvcCode   func(bytes []byte) (kiwi.TSvc, kiwi.TCode, bool)
+		floodKick    int
+		resume       time.Duration
+		resumeBuffer int
+		tls          *tls.Config
+		auth         IGateAuth
+		authTimeout  time.Duration
+		sessionDir   kiwi.ISessionDir
+		http         int
+		httpAuth     HttpAuth
+		compressor   kiwi.ICompressor
+		compressMin  int
+		crypto       bool
+		cryptoPsk    []byte
+		udpBlock     kcp.BlockCrypt
+		seqWindow    int
+		seqKick      bool
 	}
 )
 
@@ -96,6 +118,39 @@ func GateHeadLen(headLen int) GateOption {
 	}
 }
 
+// GateTls tcp和websocket监听使用tls,可以用network.NewTlsServerConf创建
+func GateTls(conf *tls.Config) GateOption {
+	return func(option *gateOption) {
+		option.tls = conf
+	}
+}
+
+// GateCompress 客户端发送压缩的包后开始压缩大于等于min的包,也可以用IAgent.SetCompress开启;
+// websocket使用permessage-deflate
+func GateCompress(compressor kiwi.ICompressor, min int) GateOption {
+	return func(option *gateOption) {
+		option.compressor = compressor
+		option.compressMin = min
+	}
+}
+
+// GateCrypto 不使用tls时tcp、udp、websocket连接的应用层加密,客户端连接后先交换X25519公钥,
+// 会话密钥由共享密钥和psk派生,psk不能为空,客户端需要内置相同的psk,见kiwi.AgentCrypto
+func GateCrypto(psk []byte) GateOption {
+	return func(option *gateOption) {
+		option.crypto = true
+		option.cryptoPsk = psk
+	}
+}
+
+// GateUdpCrypt kcp的包加密,可以用network.NewKcpBlockCrypt创建,
+// 所有客户端使用相同的key,需要保密时同时使用GateCrypto
+func GateUdpCrypt(block kcp.BlockCrypt) GateOption {
+	return func(option *gateOption) {
+		option.udpBlock = block
+	}
+}
+
 func GateRoles(roles map[kiwi.TSvcCode][]int64) GateOption {
 	return func(option *gateOption) {
 		option.roles = roles
@@ -108,7 +163,9 @@ func InitGate(receiver kiwi.FnAgentBytes, opts ...GateOption) {
 		checkIp: func(s string) bool {
 			return true
 		},
-		headLen: 4,
+		headLen:      4,
+		floodKick:    DefFloodKick,
+		resumeBuffer: DefResumeBuffer,
 		connected: func(agent kiwi.IAgent) {
 
 		},
@@ -119,20 +176,48 @@ func InitGate(receiver kiwi.FnAgentBytes, opts ...GateOption) {
 	for _, opt := range opts {
 		opt(o)
 	}
+	if o.crypto && len(o.cryptoPsk) == 0 {
+		kiwi.Fatal2(util.EcParamsErr, util.M{
+			"error": "GateCrypto requires psk",
+		})
+	}
+	if o.seqWindow > 0 && !o.crypto {
+		kiwi.Fatal2(util.EcParamsErr, util.M{
+			"error": "GateSeq requires GateCrypto",
+		})
+	}
+	if o.auth != nil && o.msgSvcCode == nil {
+		kiwi.Fatal2(util.EcParamsErr, util.M{
+			"error": "GateAuth requires GateMsgSvcCode",
+		})
+	}
 	g := &gate{
-		option:   o,
-		receiver: receiver,
+		option: o,
+		receiver: func(agent kiwi.IAgent, bytes []byte) {
+			_MetricGateBytesIn.With().Add(float64(len(bytes)))
+			receiver(agent, bytes)
+		},
 		idToAgent: ds.NewKSet[string, kiwi.IAgent](1024, func(agent kiwi.IAgent) string {
 			return agent.Id()
 		}),
 		addrToAgent: ds.NewKSet[string, kiwi.IAgent](1024, func(agent kiwi.IAgent) string {
 			return agent.Addr()
 		}),
-		msgToRoles: sync.Map{},
+		msgToRoles:     sync.Map{},
+		ipToBucket:     make(map[string]*ipBucket),
+		tokenToSession: make(map[string]*gateSession),
+		idToSession:    make(map[string]*gateSession),
+		closing:        make(map[string]struct{}),
+		channelToIds:   make(map[string]map[string]struct{}),
+		idToChannels:   make(map[string]map[string]struct{}),
 	}
 	g.SetRoles(o.roles)
 	g.worker = worker.NewJobWorker(g.process)
 	g.worker.Start()
+	if o.sessionDir != nil {
+		g.dirWorker = worker.NewJobWorker(g.processDir)
+		g.dirWorker.Start()
+	}
 	if o.ip == "" {
 		ip, err := util.GetLocalIp()
 		if err != nil {
@@ -145,7 +230,12 @@ func InitGate(receiver kiwi.FnAgentBytes, opts ...GateOption) {
 	}
 	if g.option.udp > 0 {
 		addr := fmt.Sprintf("%s:%d", g.option.ip, g.option.udp)
-		listener := network.NewUdpListener(addr, g.onAddUdpConn)
+		var listener kiwi.IListener
+		if g.option.udpBlock != nil {
+			listener = network.NewUdpCryptListener(addr, g.option.udpBlock, g.onAddUdpConn)
+		} else {
+			listener = network.NewUdpListener(addr, g.onAddUdpConn)
+		}
 		g.listeners = append(g.listeners, listener)
 		err := listener.Start()
 		if err != nil {
@@ -155,7 +245,12 @@ func InitGate(receiver kiwi.FnAgentBytes, opts ...GateOption) {
 	}
 	if g.option.tcp > 0 {
 		addr := fmt.Sprintf("%s:%d", g.option.ip, g.option.tcp)
-		listener := network.NewTcpListener(addr, g.onAddTcpConn)
+		var listener kiwi.IListener
+		if g.option.tls != nil {
+			listener = network.NewTcpTlsListener(addr, g.option.tls, g.onAddTcpConn)
+		} else {
+			listener = network.NewTcpListener(addr, g.onAddTcpConn)
+		}
 		err := listener.Start()
 		if err != nil {
 			kiwi.Fatal(err)
@@ -165,7 +260,10 @@ func InitGate(receiver kiwi.FnAgentBytes, opts ...GateOption) {
 	}
 	if g.option.web > 0 {
 		addr := fmt.Sprintf("%s:%d", g.option.ip, g.option.web)
-		listener := network.NewWebListener(g.onAddWebConn, network.WebAddr(addr))
+		listener := network.NewWebListener(g.onAddWebConn, network.WebAddr(addr), network.WebTls(g.option.tls),
+			network.WebUpgrader(func(upgrader *websocket.Upgrader) {
+				upgrader.EnableCompression = g.option.compressor != nil
+			}))
 		err := listener.Start()
 		if err != nil {
 			kiwi.Fatal(err)
@@ -173,6 +271,13 @@ func InitGate(receiver kiwi.FnAgentBytes, opts ...GateOption) {
 		g.listeners = append(g.listeners, listener)
 		_ = kiwi.GetNodeMeta().Data.Set2(g.option.web, "gate", "web")
 	}
+	if g.option.http > 0 {
+		err := g.startHttp()
+		if err != nil {
+			kiwi.Fatal(err)
+		}
+		_ = kiwi.GetNodeMeta().Data.Set2(g.option.http, "gate", "http")
+	}
 	kiwi.SetGate(g)
 }
 
@@ -180,11 +285,22 @@ type gate struct {
 	option      *gateOption
 	receiver    kiwi.FnAgentBytes
 	worker      *worker.JobWorker
+	dirWorker   *worker.JobWorker
 	listeners   []kiwi.IListener
 	idToAgent   *ds.KSet[string, kiwi.IAgent]
 	addrToAgent *ds.KSet[string, kiwi.IAgent]
 	agentCount  int32
 	msgToRoles  sync.Map
+	// addrToState 连接的限流和握手状态
+	addrToState sync.Map
+	ipMtx       sync.Mutex
+	ipToBucket  map[string]*ipBucket
+	// 以下只在worker中使用
+	tokenToSession map[string]*gateSession
+	idToSession    map[string]*gateSession
+	closing        map[string]struct{}
+	channelToIds   map[string]map[string]struct{}
+	idToChannels   map[string]map[string]struct{}
 }
 
 func (g *gate) Dispose() *util.Err {
@@ -205,7 +321,7 @@ func (g *gate) onAddTcpConn(conn net.Conn) {
 		return
 	}
 
-	if !g.option.checkIp(strings.Split(addr, ":")[0]) {
+	if !g.option.checkIp(addrHost(addr)) {
 		_ = conn.Close()
 		kiwi.Warn(util.NewErr(util.EcIllegalConn, util.M{
 			"addr": addr,
@@ -213,13 +329,15 @@ func (g *gate) onAddTcpConn(conn net.Conn) {
 		return
 	}
 
-	agent := network.NewTcpAgent(addr, g.receiver,
+	agent := network.NewTcpAgent(addr, g.agentReceiver(addr),
 		kiwi.AgentErr(func(err *util.Err) {
 			err.AddParam("addr", addr)
 			kiwi.Error(err)
 		}),
 		kiwi.AgentDeadline(g.option.deadline),
 		kiwi.AgentHeadLen(g.option.headLen),
+		kiwi.AgentCompress(g.option.compressor, g.option.compressMin, kiwi.CompressNegotiate),
+		g.cryptoOption(),
 	)
 	agent.BindConnected(g.onAgentConnected)
 	agent.BindDisconnected(g.onAgentClosed)
@@ -237,7 +355,7 @@ func (g *gate) onAddUdpConn(conn net.Conn) {
 		return
 	}
 
-	if !g.option.checkIp(strings.Split(addr, ":")[0]) {
+	if !g.option.checkIp(addrHost(addr)) {
 		_ = conn.Close()
 		kiwi.Warn(util.NewErr(util.EcIllegalConn, util.M{
 			"addr": addr,
@@ -245,13 +363,15 @@ func (g *gate) onAddUdpConn(conn net.Conn) {
 		return
 	}
 
-	agent := network.NewUdpAgent(addr, g.receiver,
+	agent := network.NewUdpAgent(addr, g.agentReceiver(addr),
 		kiwi.AgentErr(func(err *util.Err) {
 			err.AddParam("addr", addr)
 			kiwi.Error(err)
 		}),
 		kiwi.AgentDeadline(g.option.deadline),
 		kiwi.AgentHeadLen(g.option.headLen),
+		kiwi.AgentCompress(g.option.compressor, g.option.compressMin, kiwi.CompressNegotiate),
+		g.cryptoOption(),
 	)
 	agent.BindConnected(g.onAgentConnected)
 	agent.BindDisconnected(g.onAgentClosed)
@@ -269,7 +389,7 @@ func (g *gate) onAddWebConn(conn *websocket.Conn) {
 		return
 	}
 
-	if !g.option.checkIp(strings.Split(addr, ":")[0]) {
+	if !g.option.checkIp(addrHost(addr)) {
 		_ = conn.Close()
 		kiwi.Warn(util.NewErr(util.EcIllegalConn, util.M{
 			"addr": addr,
@@ -277,24 +397,102 @@ func (g *gate) onAddWebConn(conn *websocket.Conn) {
 		return
 	}
 
-	agent := network.NewWebAgent(addr, 2, g.receiver,
+	agent := network.NewWebAgent(addr, 2, g.agentReceiver(addr),
 		kiwi.AgentErr(func(err *util.Err) {
 			err.AddParam("addr", addr)
 			kiwi.Error(err)
 		}),
 		kiwi.AgentDeadline(g.option.deadline),
 		kiwi.AgentHeadLen(g.option.headLen),
+		kiwi.AgentCompress(g.option.compressor, g.option.compressMin, kiwi.CompressOn),
+		g.cryptoOption(),
 	)
 	agent.BindConnected(g.onAgentConnected)
 	agent.BindDisconnected(g.onAgentClosed)
 	agent.Start(util.Ctx(), conn)
 }
 
+func (g *gate) cryptoOption() kiwi.AgentOption {
+	return func(o *kiwi.AgentOpt) {
+		o.Crypto = g.option.crypto
+		o.CryptoPsk = g.option.cryptoPsk
+	}
+}
+
+// Info 所有连接的id、地址、head和cache
+func (g *gate) Info(fn util.FnM) {
+	g.worker.Push(gateInfo, fn)
+}
+
+func (g *gate) AgentCount() int32 {
+	return atomic.LoadInt32(&g.agentCount)
+}
+
+func (g *gate) send(agent kiwi.IAgent, bytes []byte) *util.Err {
+	l := len(bytes)
+	err := agent.Send(bytes)
+	if err == nil {
+		_MetricGateBytesOut.With().Add(float64(l))
+	}
+	return err
+}
+
+// agentState 连接的限流和握手状态,断开时替换断开的错误
+type agentState struct {
+	limiter *agentLimiter
+	auth    *agentAuth
+	seq     *seqWindow
+	err     atomic.Pointer[util.Err]
+}
+
+func (s *agentState) kick(agent kiwi.IAgent, err *util.Err) {
+	s.err.Store(err)
+	agent.Dispose()
+}
+
+// agentReceiver 按配置组合序号检查、限流和握手,都没有配置时直接使用g.receiver
+func (g *gate) agentReceiver(addr string) kiwi.FnAgentBytes {
+	limit := g.hasLimit()
+	if !limit && g.option.auth == nil && g.option.seqWindow == 0 {
+		return g.receiver
+	}
+	state := &agentState{}
+	receiver := g.receiver
+	if g.option.auth != nil {
+		receiver = g.authReceiver(state, receiver)
+	}
+	if limit {
+		receiver = g.limitReceiver(addr, state, receiver)
+	}
+	if g.option.seqWindow > 0 {
+		//去掉序号后再解析svc、code
+		receiver = g.seqReceiver(state, receiver)
+	}
+	g.addrToState.Store(addr, state)
+	return receiver
+}
+
+func (g *gate) releaseState(addr string, err *util.Err) *util.Err {
+	v, ok := g.addrToState.LoadAndDelete(addr)
+	if !ok {
+		return err
+	}
+	state := v.(*agentState)
+	if state.limiter != nil {
+		g.releaseLimiter(state.limiter)
+	}
+	if e := state.err.Load(); e != nil {
+		return e
+	}
+	return err
+}
+
 func (g *gate) onAgentConnected(agent kiwi.IAgent) {
 	g.worker.Push(gateConnected, agent)
 }
 
 func (g *gate) onAgentClosed(agent kiwi.IAgent, err *util.Err) {
+	err = g.releaseState(agent.Addr(), err)
 	g.worker.Push(gateDisconnected, agent, err)
 }
 
@@ -378,6 +576,12 @@ func (g *gate) process(job *worker.Job) {
 			return
 		}
 		_ = g.addrToAgent.AddNX(agent)
+		g.sendSeqNonce(agent)
+		if g.option.resume > 0 {
+			g.sendResumeToken(agent)
+		}
+		g.startAuth(agent)
+		g.dirSet(agent.Id())
 		kiwi.Info("agent connected", util.M{
 			"id":   agent.Id(),
 			"addr": agent.Addr(),
@@ -397,20 +601,27 @@ func (g *gate) process(job *worker.Job) {
 			"addr": agentAddr,
 		})
 		atomic.AddInt32(&g.agentCount, -1)
-		g.option.disconnected(agent, err)
+		_, closing := g.closing[agentAddr]
+		delete(g.closing, agentAddr)
 		agent2, ok := g.idToAgent.Get(agentId)
 		if !ok || agent2.Addr() != agentAddr { //id被新agent替换
+			g.option.disconnected(agent, err)
 			return
 		}
 		_, _ = g.idToAgent.Del(agentId)
+		if closing || g.option.resume == 0 || (err != nil && err.Code() == util.EcFlood) {
+			g.disconnect(agent, err)
+			return
+		}
+		g.suspend(agent, err)
 	case gateSend:
 		tid, id, bytes, fn := util.SplitSlc4[int64, string, []byte, util.FnBool](job.Data)
 		agent, ok := g.idToAgent.Get(id)
 		if !ok {
-			fn(false)
+			fn(g.bufferSession(id, bytes))
 			return
 		}
-		err := agent.Send(bytes)
+		err := g.send(agent, bytes)
 		if err != nil {
 			err.AddParam("id", id)
 			kiwi.TE(tid, err)
@@ -425,7 +636,7 @@ func (g *gate) process(job *worker.Job) {
 			fn(false)
 			return
 		}
-		err := agent.Send(bytes)
+		err := g.send(agent, bytes)
 		if err != nil {
 			err.AddParam("addr", addr)
 			kiwi.TE(tid, err)
@@ -439,10 +650,10 @@ func (g *gate) process(job *worker.Job) {
 		for id, payload := range idToPayload {
 			agent, ok := g.idToAgent.Get(id)
 			if !ok {
-				m[id] = false
+				m[id] = g.bufferSession(id, payload)
 				continue
 			}
-			err := agent.Send(payload)
+			err := g.send(agent, payload)
 			if err != nil {
 				kiwi.TE(tid, err)
 				m[id] = false
@@ -460,7 +671,7 @@ func (g *gate) process(job *worker.Job) {
 				m[addr] = false
 				continue
 			}
-			err := agent.Send(payload)
+			err := g.send(agent, payload)
 			if err != nil {
 				kiwi.TE(tid, err)
 				m[addr] = false
@@ -472,7 +683,7 @@ func (g *gate) process(job *worker.Job) {
 	case gateAllSend:
 		tid, bytes := util.SplitSlc2[int64, []byte](job.Data)
 		g.idToAgent.Iter(func(item kiwi.IAgent) {
-			err := item.Send(util.CopyBytes(bytes))
+			err := g.send(item, util.CopyBytes(bytes))
 			if err != nil {
 				kiwi.TE(tid, err)
 			}
@@ -495,6 +706,9 @@ func (g *gate) process(job *worker.Job) {
 				oldId := agent.Id()
 				agent.SetId(newId)
 				g.idToAgent.ReplaceOrNew(oldId, agent)
+				g.renameChannels(oldId, newId)
+				g.dirDel(oldId)
+				g.dirSet(newId)
 			}
 			agent.SetHeads(head)
 		}
@@ -523,6 +737,9 @@ func (g *gate) process(job *worker.Job) {
 				oldId := agent.Id()
 				agent.SetId(newId)
 				g.idToAgent.ReplaceOrNew(oldId, agent)
+				g.renameChannels(oldId, newId)
+				g.dirDel(oldId)
+				g.dirSet(newId)
 			}
 			agent.SetHeads(head)
 		}
@@ -590,11 +807,37 @@ func (g *gate) process(job *worker.Job) {
 		_, id, head, cache := util.SplitSlc4[int64, string, []string, []string](job.Data)
 		agent, ok := g.idToAgent.Get(id)
 		if !ok {
+			if s, ok := g.idToSession[id]; ok {
+				g.endSession(s)
+			}
 			return
 		}
 		agent.DelHead(head...)
 		agent.DelCache(cache...)
+		g.closing[agent.Addr()] = struct{}{}
 		agent.Dispose()
+	case gateInfo:
+		fn := util.SplitSlc1[util.FnM](job.Data)
+		agents := make([]util.M, 0, g.addrToAgent.Count())
+		g.addrToAgent.Iter(func(agent kiwi.IAgent) {
+			head := util.M{}
+			cache := util.M{}
+			agent.CopyHead(head)
+			agent.CopyCache(cache)
+			agents = append(agents, util.M{
+				"id":      agent.Id(),
+				"addr":    agent.Addr(),
+				"head":    head,
+				"cache":   cache,
+				"pending": agent.Pending(),
+			})
+		})
+		fn(util.M{
+			"count":     len(agents),
+			"agents":    agents,
+			"suspended": len(g.tokenToSession),
+			"channels":  len(g.channelToIds),
+		})
 	case gateAddrClose:
 		_, addr, head, cache := util.SplitSlc4[int64, string, []string, []string](job.Data)
 		agent, ok := g.addrToAgent.Get(addr)
@@ -603,24 +846,67 @@ func (g *gate) process(job *worker.Job) {
 		}
 		agent.DelHead(head...)
 		agent.DelCache(cache...)
+		g.closing[addr] = struct{}{}
 		agent.Dispose()
+	case gateResume:
+		tid, addr, token, fn := util.SplitSlc4[int64, string, string, util.FnBool](job.Data)
+		fn(g.resume(tid, addr, token))
+	case gateJoinChannel:
+		tid, channel, ids := util.SplitSlc3[int64, string, []string](job.Data)
+		g.joinChannel(channel, ids)
+		kiwi.TD(tid, "join channel", util.M{
+			"channel": channel,
+			"ids":     ids,
+		})
+	case gateLeaveChannel:
+		tid, channel, ids := util.SplitSlc3[int64, string, []string](job.Data)
+		g.leaveChannel(channel, ids)
+		kiwi.TD(tid, "leave channel", util.M{
+			"channel": channel,
+			"ids":     ids,
+		})
+	case gateChannelSend:
+		tid, channel, bytes := util.SplitSlc3[int64, string, []byte](job.Data)
+		g.channelSend(tid, channel, bytes)
+	case gateChannelMembers:
+		_, channel, fn := util.SplitSlc3[int64, string, util.FnStrSlc](job.Data)
+		members := g.channelToIds[channel]
+		ids := make([]string, 0, len(members))
+		for id := range members {
+			ids = append(ids, id)
+		}
+		fn(ids)
+	case gateSessionExpire:
+		token := util.SplitSlc1[string](job.Data)
+		s, ok := g.tokenToSession[token]
+		if !ok {
+			return
+		}
+		g.endSession(s)
 	}
 }
 
 const (
-	gateConnected     = "connected"
-	gateDisconnected  = "disconnected"
-	gateSend          = "send"
-	gateAddrSend      = "send_addr"
-	gateMultiSend     = "multi_send"
-	gateMultiAddrSend = "multi_send_addr"
-	gateAllSend       = "all_send"
-	gateUpdate        = "update_head_cache"
-	gateUpdateAddr    = "update_addr_head_cache"
-	gateRemove        = "remove_head_cache"
-	gateRemoveAddr    = "remove_addr_head_cache"
-	gateGet           = "get_head_cache"
-	gateGetAddr       = "get_addr_head_cache"
-	gateAddrClose     = "addr_close"
-	gateClose         = "close"
+	gateConnected      = "connected"
+	gateDisconnected   = "disconnected"
+	gateSend           = "send"
+	gateAddrSend       = "send_addr"
+	gateMultiSend      = "multi_send"
+	gateMultiAddrSend  = "multi_send_addr"
+	gateAllSend        = "all_send"
+	gateUpdate         = "update_head_cache"
+	gateUpdateAddr     = "update_addr_head_cache"
+	gateRemove         = "remove_head_cache"
+	gateRemoveAddr     = "remove_addr_head_cache"
+	gateGet            = "get_head_cache"
+	gateGetAddr        = "get_addr_head_cache"
+	gateAddrClose      = "addr_close"
+	gateClose          = "close"
+	gateInfo           = "info"
+	gateResume         = "resume"
+	gateSessionExpire  = "session_expire"
+	gateJoinChannel    = "join_channel"
+	gateLeaveChannel   = "leave_channel"
+	gateChannelSend    = "channel_send"
+	gateChannelMembers = "channel_members"
 )
@@ -0,0 +1,318 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+)
+
+const (
+	// HeadRoleMask 握手完成后写入head的角色掩码,用于检查每个包的svc、code
+	HeadRoleMask = "role_mask"
+	// DefAuthTimeout 默认握手超时
+	DefAuthTimeout = 10 * time.Second
+)
+
+const (
+	authRejectHandshake = "handshake"
+	authRejectTimeout   = "timeout"
+	authRejectRole      = "role"
+)
+
+// IGateAuth 连接建立后、转发数据前的握手
+type IGateAuth interface {
+	// Start 连接建立后调用,可以发送挑战
+	Start(agent kiwi.IAgent) *util.Err
+	// Verify 握手阶段收到的包,head不为空时握手完成并通过UpdateHeadCache写入,
+	// reply不为空时发送给客户端,返回错误时断开连接
+	Verify(agent kiwi.IAgent, bytes []byte) (head util.M, reply []byte, err *util.Err)
+}
+
+// GateAuth 新连接必须先完成握手,超时以EcTimeout断开,握手失败以EcNoAuth断开;
+// 必须同时配置GateMsgSvcCode,握手后每个包用HeadRoleMask调用Authenticate,
+// 无法解析的包和没有配置角色的方法拒绝
+func GateAuth(auth IGateAuth, timeout time.Duration) GateOption {
+	return func(option *gateOption) {
+		option.auth = auth
+		option.authTimeout = timeout
+	}
+}
+
+type agentAuth struct {
+	done atomic.Bool
+}
+
+func (g *gate) authReceiver(state *agentState, next kiwi.FnAgentBytes) kiwi.FnAgentBytes {
+	a := &agentAuth{}
+	state.auth = a
+	return func(agent kiwi.IAgent, bytes []byte) {
+		if a.done.Load() {
+			if g.checkRole(agent, bytes) {
+				next(agent, bytes)
+			}
+			return
+		}
+		head, reply, err := g.option.auth.Verify(agent, bytes)
+		if err != nil {
+			_MetricGateAuthReject.With(authRejectHandshake).Inc()
+			if err.Code() != util.EcNoAuth {
+				err = util.NewErr(util.EcNoAuth, util.M{
+					"error": err.Error(),
+				})
+			}
+			err.AddParam("addr", agent.Addr())
+			kiwi.Warn(err)
+			state.kick(agent, err)
+			return
+		}
+		if reply != nil {
+			err = g.send(agent, reply)
+			if err != nil {
+				kiwi.Error(err)
+			}
+		}
+		if head == nil {
+			return
+		}
+		if mask, ok := head[HeadRoleMask]; ok {
+			agent.SetHead(HeadRoleMask, mask) //之后的包不用等worker更新
+		}
+		a.done.Store(true)
+		g.UpdateAddrHeadCache(0, agent.Addr(), head, nil, func(bool) {})
+	}
+}
+
+// startAuth 在worker中连接建立后调用
+func (g *gate) startAuth(agent kiwi.IAgent) {
+	v, ok := g.addrToState.Load(agent.Addr())
+	if !ok {
+		return
+	}
+	state := v.(*agentState)
+	if state.auth == nil {
+		return
+	}
+	err := g.option.auth.Start(agent)
+	if err != nil {
+		err.AddParam("addr", agent.Addr())
+		kiwi.Error(err)
+		state.kick(agent, err)
+		return
+	}
+	timeout := g.option.authTimeout
+	if timeout == 0 {
+		timeout = DefAuthTimeout
+	}
+	time.AfterFunc(timeout, func() {
+		if state.auth.done.Load() {
+			return
+		}
+		_MetricGateAuthReject.With(authRejectTimeout).Inc()
+		state.kick(agent, util.NewErr(util.EcTimeout, util.M{
+			"addr":  agent.Addr(),
+			"error": "auth timeout",
+		}))
+	})
+}
+
+func (g *gate) checkRole(agent kiwi.IAgent, bytes []byte) bool {
+	svc, code, ok := g.option.msgSvcCode(bytes)
+	if !ok {
+		_MetricGateAuthReject.With(authRejectRole).Inc()
+		kiwi.Warn2(util.EcNoAuth, util.M{
+			"id":    agent.Id(),
+			"addr":  agent.Addr(),
+			"error": "unknown svc code",
+		})
+		return false
+	}
+	var mask int64
+	if val, ok := agent.GetHead(HeadRoleMask); ok {
+		mask = anyToInt64(val)
+	}
+	if g.Authenticate(mask, svc, code) {
+		return true
+	}
+	_MetricGateAuthReject.With(authRejectRole).Inc()
+	kiwi.Warn2(util.EcNoAuth, util.M{
+		"id":   agent.Id(),
+		"addr": agent.Addr(),
+		"svc":  svc,
+		"code": code,
+	})
+	return false
+}
+
+// JwtAuth 客户端连接后发送的第一个包是HS256签名的jwt,
+// sub写入head的id,mask写入HeadRoleMask,有exp时检查过期
+type JwtAuth struct {
+	secret []byte
+}
+
+func NewJwtAuth(secret []byte) *JwtAuth {
+	return &JwtAuth{
+		secret: secret,
+	}
+}
+
+func (a *JwtAuth) Start(kiwi.IAgent) *util.Err {
+	return nil
+}
+
+func (a *JwtAuth) Verify(_ kiwi.IAgent, bytes []byte) (util.M, []byte, *util.Err) {
+	claims, err := ParseJwt(a.secret, string(bytes))
+	if err != nil {
+		return nil, nil, err
+	}
+	head := util.M{}
+	if sub, ok := util.MGet[string](claims, "sub"); ok {
+		head["id"] = sub
+	}
+	if mask, ok := claims["mask"]; ok {
+		head[HeadRoleMask] = anyToInt64(mask)
+	}
+	return head, nil, nil
+}
+
+// SignJwt 生成HS256签名的jwt
+func SignJwt(secret []byte, claims util.M) (string, *util.Err) {
+	payload, err := util.JsonMarshal(claims)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	str := enc.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + enc.EncodeToString(payload)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(str))
+	return str + "." + enc.EncodeToString(mac.Sum(nil)), nil
+}
+
+// ParseJwt 验证HS256签名和exp,返回claims
+func ParseJwt(secret []byte, token string) (util.M, *util.Err) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, util.NewErr(util.EcNoAuth, util.M{
+			"error": "malformed token",
+		})
+	}
+	enc := base64.RawURLEncoding
+	header := util.M{}
+	bytes, e := enc.DecodeString(parts[0])
+	if e != nil || util.JsonUnmarshal(bytes, &header) != nil || header["alg"] != "HS256" {
+		return nil, util.NewErr(util.EcNoAuth, util.M{
+			"error": "unsupported header",
+		})
+	}
+	sig, e := enc.DecodeString(parts[2])
+	if e != nil {
+		return nil, util.NewErr(util.EcNoAuth, util.M{
+			"error": "malformed signature",
+		})
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, util.NewErr(util.EcNoAuth, util.M{
+			"error": "wrong signature",
+		})
+	}
+	claims := util.M{}
+	bytes, e = enc.DecodeString(parts[1])
+	if e != nil || util.JsonUnmarshal(bytes, &claims) != nil {
+		return nil, util.NewErr(util.EcNoAuth, util.M{
+			"error": "malformed claims",
+		})
+	}
+	if exp, ok := claims["exp"]; ok && anyToInt64(exp) < time.Now().Unix() {
+		return nil, util.NewErr(util.EcNoAuth, util.M{
+			"error": "token expired",
+		})
+	}
+	return claims, nil
+}
+
+// ChallengeAuth 连接后发送32字节随机数,客户端回复"id:hex(hmac-sha256(key, nonce))",
+// key和角色掩码通过fnKey按id获取
+type ChallengeAuth struct {
+	fnKey func(id string) (key []byte, mask int64, ok bool)
+}
+
+func NewChallengeAuth(fnKey func(id string) (key []byte, mask int64, ok bool)) *ChallengeAuth {
+	return &ChallengeAuth{
+		fnKey: fnKey,
+	}
+}
+
+const cacheAuthNonce = "auth_nonce"
+
+func (a *ChallengeAuth) Start(agent kiwi.IAgent) *util.Err {
+	nonce := make([]byte, 32)
+	_, _ = rand.Read(nonce)
+	agent.SetCache(cacheAuthNonce, nonce)
+	return agent.Send(nonce)
+}
+
+func (a *ChallengeAuth) Verify(agent kiwi.IAgent, bytes []byte) (util.M, []byte, *util.Err) {
+	val, ok := agent.GetCache(cacheAuthNonce)
+	if !ok {
+		return nil, nil, util.NewErr(util.EcNoAuth, util.M{
+			"error": "no challenge",
+		})
+	}
+	agent.DelCache(cacheAuthNonce)
+	id, sig, ok := strings.Cut(string(bytes), ":")
+	if !ok {
+		return nil, nil, util.NewErr(util.EcNoAuth, util.M{
+			"error": "malformed response",
+		})
+	}
+	key, mask, ok := a.fnKey(id)
+	if !ok {
+		return nil, nil, util.NewErr(util.EcNoAuth, util.M{
+			"id": id,
+		})
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(val.([]byte))
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return nil, nil, util.NewErr(util.EcNoAuth, util.M{
+			"id":    id,
+			"error": "wrong signature",
+		})
+	}
+	return util.M{
+		"id":         id,
+		HeadRoleMask: mask,
+	}, nil, nil
+}
+
+// anyToInt64 head中可能是int64,json解析的是json.Number
+func anyToInt64(val any) int64 {
+	switch v := val.(type) {
+	case int64:
+		return v
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint64:
+		return int64(v)
+	case float64:
+		return int64(v)
+	case json.Number:
+		i, _ := v.Int64()
+		return i
+	default:
+		return 0
+	}
+}
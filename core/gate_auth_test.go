@@ -0,0 +1,124 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+)
+
+func TestJwt(t *testing.T) {
+	secret := []byte("secret")
+	token, err := SignJwt(secret, util.M{
+		"sub":  "u1",
+		"mask": 6,
+		"exp":  time.Now().Add(time.Minute).Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, _, err := NewJwtAuth(secret).Verify(nil, []byte(token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head["id"] != "u1" || head[HeadRoleMask] != int64(6) {
+		t.Fatal(head)
+	}
+	if _, err = ParseJwt([]byte("other"), token); err == nil {
+		t.Fatal("wrong secret")
+	}
+	token, _ = SignJwt(secret, util.M{"exp": time.Now().Add(-time.Minute).Unix()})
+	if _, err = ParseJwt(secret, token); err == nil {
+		t.Fatal("expired")
+	}
+}
+
+// testAuth 收到"ok"时握手完成,角色掩码为2
+type testAuth struct{}
+
+func (a *testAuth) Start(kiwi.IAgent) *util.Err {
+	return nil
+}
+
+func (a *testAuth) Verify(_ kiwi.IAgent, bytes []byte) (util.M, []byte, *util.Err) {
+	if string(bytes) != "ok" {
+		return nil, nil, util.NewErr(util.EcNoAuth, nil)
+	}
+	return util.M{"id": "u1", HeadRoleMask: int64(2)}, []byte("welcome"), nil
+}
+
+func testAuthGate(timeout time.Duration) (*gate, chan []byte, chan *util.Err) {
+	received := make(chan []byte, 8)
+	disconnected := make(chan *util.Err, 4)
+	g := testGate(func(_ kiwi.IAgent, bytes []byte) {
+		received <- bytes
+	},
+		GateAuth(&testAuth{}, timeout),
+		GateMsgSvcCode(func(bytes []byte) (kiwi.TSvc, kiwi.TCode, bool) {
+			if len(bytes) != 2 {
+				return 0, 0, false
+			}
+			return kiwi.TSvc(bytes[0]), kiwi.TCode(bytes[1]), true
+		}),
+		GateRoles(map[kiwi.TSvcCode][]int64{
+			kiwi.MergeSvcCode(1, 1): {2},
+			kiwi.MergeSvcCode(1, 2): {4},
+		}),
+		GateDisconnected(func(_ kiwi.IAgent, err *util.Err) {
+			disconnected <- err
+		}))
+	return g, received, disconnected
+}
+
+func TestGateAuthTimeout(t *testing.T) {
+	g, received, disconnected := testAuthGate(20 * time.Millisecond)
+	_ = connectAgent(g, "tmp", "1.1.1.1:1")
+	select {
+	case err := <-disconnected:
+		if err == nil || err.Code() != util.EcTimeout {
+			t.Fatal("kick", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("not kicked")
+	}
+	if len(received) != 0 {
+		t.Fatal("forwarded before handshake")
+	}
+}
+
+func TestGateAuthRole(t *testing.T) {
+	g, received, disconnected := testAuthGate(time.Minute)
+	a := connectAgent(g, "tmp", "1.1.1.1:1")
+	a.receiver(a, []byte("ok"))
+	flushGate(g)
+	if a.Id() != "u1" {
+		t.Fatal("head not updated", a.Id())
+	}
+	if sent := a.Sent(); len(sent) != 1 || sent[0] != "welcome" {
+		t.Fatal("reply", sent)
+	}
+	//没有权限的方法、没有配置角色的方法和无法解析的包都拒绝
+	a.receiver(a, []byte{1, 2})
+	a.receiver(a, []byte{1, 3})
+	a.receiver(a, []byte{1})
+	a.receiver(a, []byte{1, 1})
+	if bytes := <-received; bytes[0] != 1 || bytes[1] != 1 {
+		t.Fatal("forwarded", bytes)
+	}
+	if len(received) != 0 || len(disconnected) != 0 {
+		t.Fatal("rejected packets", len(received), len(disconnected))
+	}
+}
+
+func TestGateAuthReject(t *testing.T) {
+	g, received, disconnected := testAuthGate(time.Minute)
+	a := connectAgent(g, "tmp", "1.1.1.1:1")
+	a.receiver(a, []byte("bad"))
+	if err := <-disconnected; err == nil || err.Code() != util.EcNoAuth {
+		t.Fatal("kick", err)
+	}
+	if len(received) != 0 {
+		t.Fatal("forwarded")
+	}
+}
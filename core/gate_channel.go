@@ -0,0 +1,134 @@
+package core
+
+import (
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+)
+
+// JoinChannel 按id加入频道,id断开后自动离开,开启GateResume时会话结束后才离开
+func (g *gate) JoinChannel(tid int64, channel string, ids ...string) {
+	g.worker.Push(gateJoinChannel, tid, channel, ids)
+}
+
+func (g *gate) LeaveChannel(tid int64, channel string, ids ...string) {
+	g.worker.Push(gateLeaveChannel, tid, channel, ids)
+}
+
+// ChannelSend 发送给频道所有成员,所有连接共享bytes,断线等待恢复的成员缓存一份副本
+func (g *gate) ChannelSend(tid int64, channel string, bytes []byte) {
+	g.worker.Push(gateChannelSend, tid, channel, bytes)
+}
+
+func (g *gate) ChannelMembers(tid int64, channel string, fn util.FnStrSlc) {
+	g.worker.Push(gateChannelMembers, tid, channel, fn)
+}
+
+// 以下只在worker中调用
+
+func (g *gate) joinChannel(channel string, ids []string) {
+	members, ok := g.channelToIds[channel]
+	if !ok {
+		members = make(map[string]struct{}, len(ids))
+		g.channelToIds[channel] = members
+	}
+	for _, id := range ids {
+		members[id] = struct{}{}
+		channels, ok := g.idToChannels[id]
+		if !ok {
+			channels = make(map[string]struct{})
+			g.idToChannels[id] = channels
+		}
+		channels[channel] = struct{}{}
+	}
+}
+
+func (g *gate) leaveChannel(channel string, ids []string) {
+	members, ok := g.channelToIds[channel]
+	if !ok {
+		return
+	}
+	for _, id := range ids {
+		delete(members, id)
+		channels, ok := g.idToChannels[id]
+		if !ok {
+			continue
+		}
+		delete(channels, channel)
+		if len(channels) == 0 {
+			delete(g.idToChannels, id)
+		}
+	}
+	if len(members) == 0 {
+		delete(g.channelToIds, channel)
+	}
+}
+
+func (g *gate) leaveAllChannels(id string) {
+	channels, ok := g.idToChannels[id]
+	if !ok {
+		return
+	}
+	delete(g.idToChannels, id)
+	for channel := range channels {
+		members := g.channelToIds[channel]
+		delete(members, id)
+		if len(members) == 0 {
+			delete(g.channelToIds, channel)
+		}
+	}
+}
+
+// renameChannels 连接的id修改后转移频道
+func (g *gate) renameChannels(oldId, newId string) {
+	if oldId == newId {
+		return
+	}
+	channels, ok := g.idToChannels[oldId]
+	if !ok {
+		return
+	}
+	g.leaveAllChannels(oldId)
+	for channel := range channels {
+		g.joinChannel(channel, []string{newId})
+	}
+}
+
+func (g *gate) channelSend(tid int64, channel string, bytes []byte) {
+	members := g.channelToIds[channel]
+	agents := make([]kiwi.IAgent, 0, len(members))
+	for id := range members {
+		agent, ok := g.idToAgent.Get(id)
+		if ok {
+			agents = append(agents, agent)
+			continue
+		}
+		if _, ok = g.idToSession[id]; ok {
+			g.bufferSession(id, util.CopyBytes(bytes))
+		}
+	}
+	if len(agents) == 0 {
+		util.RecycleBytes(bytes)
+		return
+	}
+	shared := util.NewSharedBytes(bytes, len(agents))
+	l := float64(len(bytes))
+	for _, agent := range agents {
+		err := agent.SendShared(shared)
+		if err != nil {
+			err.AddParam("id", agent.Id())
+			kiwi.TE(tid, err)
+			continue
+		}
+		_MetricGateBytesOut.With().Add(l)
+	}
+}
+
+// disconnect id没有被新连接使用时离开所有频道并从目录删除
+func (g *gate) disconnect(agent kiwi.IAgent, err *util.Err) {
+	id := agent.Id()
+	if !g.idToAgent.Has(id) {
+		g.leaveAllChannels(id)
+		g.dirDel(id)
+	}
+	g.option.disconnected(agent, err)
+}
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/15mga/kiwi/util"
+)
+
+func TestGateChannelSend(t *testing.T) {
+	g := testGate(nil, GateResume(time.Minute))
+	a := connectAgent(g, "a", "1.1.1.1:1")
+	b := connectAgent(g, "b", "2.2.2.2:2")
+	b.sendErr = util.NewErr(util.EcClosed, nil)
+	c := connectAgent(g, "c", "3.3.3.3:3")
+	token, _ := util.MGet[string](agentHead(c), HeadResumeToken)
+	g.JoinChannel(0, "room", "a", "b", "c")
+	c.Dispose()
+	bytes := util.SpawnBytes()[:4]
+	copy(bytes, "kiwi")
+	g.ChannelSend(0, "room", bytes)
+	flushGate(g)
+
+	//第一个包是恢复用的token
+	if sent := a.Sent(); len(sent) != 2 || sent[1] != "kiwi" {
+		t.Fatal("a", sent)
+	}
+	if len(b.Sent()) != 1 || len(a.shared) != 1 || len(b.shared) != 1 || a.shared[0] != b.shared[0] {
+		t.Fatal("shared", len(b.Sent()), len(a.shared), len(b.shared))
+	}
+	//发送成功和失败都释放
+	if ref := a.shared[0].Ref(); ref != 0 {
+		t.Fatal("ref", ref)
+	}
+
+	//断线的成员缓存副本,恢复后收到
+	c2 := connectAgent(g, "tmp", "4.4.4.4:4")
+	ch := make(chan bool, 1)
+	g.Resume(0, c2.Addr(), token, func(ok bool) {
+		ch <- ok
+	})
+	if !<-ch {
+		t.Fatal("not resumed")
+	}
+	if sent := c2.Sent(); len(sent) != 2 || sent[1] != "kiwi" {
+		t.Fatal("c", sent)
+	}
+	members := make(chan []string, 1)
+	g.ChannelMembers(0, "room", func(ids []string) {
+		members <- ids
+	})
+	if ids := <-members; len(ids) != 3 {
+		t.Fatal("members", ids)
+	}
+}
+
+// 没有开启GateResume时断开即离开频道
+func TestGateChannelLeave(t *testing.T) {
+	g := testGate(nil)
+	a := connectAgent(g, "a", "1.1.1.1:1")
+	g.JoinChannel(0, "room", "a")
+	a.Dispose()
+	flushGate(g)
+	members := make(chan []string, 1)
+	g.ChannelMembers(0, "room", func(ids []string) {
+		members <- ids
+	})
+	if ids := <-members; len(ids) != 0 {
+		t.Fatal("members", ids)
+	}
+}
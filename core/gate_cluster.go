@@ -0,0 +1,295 @@
+package core
+
+import (
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+	"github.com/15mga/kiwi/worker"
+)
+
+const (
+	gateOpSend uint8 = iota
+	gateOpMultiSend
+	gateOpClose
+)
+
+// GateSessionDir 连接建立和id修改时登记到目录,断开后删除,ClusterGate通过目录找到网关节点
+func GateSessionDir(dir kiwi.ISessionDir) GateOption {
+	return func(option *gateOption) {
+		option.sessionDir = dir
+	}
+}
+
+func (g *gate) dirSet(id string) {
+	if g.dirWorker != nil {
+		g.dirWorker.Push(gateDirSet, id)
+	}
+}
+
+func (g *gate) dirDel(id string) {
+	if g.dirWorker != nil {
+		g.dirWorker.Push(gateDirDel, id)
+	}
+}
+
+// processDir 目录可能是远程存储,不在gate的worker中执行
+func (g *gate) processDir(job *worker.Job) {
+	id := util.SplitSlc1[string](job.Data)
+	nodeId := kiwi.GetNodeMeta().NodeId
+	var err *util.Err
+	switch job.Name {
+	case gateDirSet:
+		err = g.option.sessionDir.Set(id, nodeId)
+	case gateDirDel:
+		err = g.option.sessionDir.Del(id, nodeId)
+	}
+	if err != nil {
+		err.AddParam("id", id)
+		kiwi.Error(err)
+	}
+}
+
+const (
+	gateDirSet = "dir_set"
+	gateDirDel = "dir_del"
+)
+
+// ClusterGate 通过目录把消息发送到连接所在的网关节点,本节点的连接直接使用kiwi.Gate();
+// 远程网关的handler在发送到网关节点后回调,不等待网关发送给连接
+type ClusterGate struct {
+	dir kiwi.ISessionDir
+}
+
+func NewClusterGate(dir kiwi.ISessionDir) *ClusterGate {
+	return &ClusterGate{
+		dir: dir,
+	}
+}
+
+func (c *ClusterGate) Send(tid int64, id string, bytes []byte, handler util.FnBool) {
+	worker.Go(func([]any) {
+		m, err := c.dir.Get(id)
+		if err != nil {
+			kiwi.TE(tid, err)
+			handler(false)
+			return
+		}
+		nodeId, ok := m[id]
+		if !ok {
+			handler(false)
+			return
+		}
+		if isLocalGate(nodeId) {
+			kiwi.Gate().Send(tid, id, bytes, handler)
+			return
+		}
+		c.sendToGate(tid, nodeId, packGateSend(tid, id, bytes), handler)
+	})
+}
+
+func (c *ClusterGate) MultiSend(tid int64, idToPayload map[string][]byte, handler util.FnMapBool) {
+	worker.Go(func([]any) {
+		ids := make([]string, 0, len(idToPayload))
+		for id := range idToPayload {
+			ids = append(ids, id)
+		}
+		m, err := c.dir.Get(ids...)
+		if err != nil {
+			kiwi.TE(tid, err)
+			m = nil
+		}
+		res := make(map[string]bool, len(idToPayload))
+		nodeToPayload := make(map[int64]map[string][]byte)
+		for id, payload := range idToPayload {
+			nodeId, ok := m[id]
+			if !ok {
+				res[id] = false
+				continue
+			}
+			payloads, ok := nodeToPayload[nodeId]
+			if !ok {
+				payloads = make(map[string][]byte)
+				nodeToPayload[nodeId] = payloads
+			}
+			payloads[id] = payload
+		}
+		if len(nodeToPayload) == 0 {
+			handler(res)
+			return
+		}
+		ch := make(chan map[string]bool, len(nodeToPayload))
+		for nodeId, payloads := range nodeToPayload {
+			payloads := payloads
+			if isLocalGate(nodeId) {
+				kiwi.Gate().MultiSend(tid, payloads, func(m map[string]bool) {
+					ch <- m
+				})
+				continue
+			}
+			c.sendToGate(tid, nodeId, packGateMultiSend(tid, payloads), func(ok bool) {
+				m := make(map[string]bool, len(payloads))
+				for id := range payloads {
+					m[id] = ok
+				}
+				ch <- m
+			})
+		}
+		for i := 0; i < len(nodeToPayload); i++ {
+			for id, ok := range <-ch {
+				res[id] = ok
+			}
+		}
+		handler(res)
+	})
+}
+
+func (c *ClusterGate) CloseWithId(tid int64, id string, removeHeadKeys, removeCacheKeys []string) {
+	worker.Go(func([]any) {
+		m, err := c.dir.Get(id)
+		if err != nil {
+			kiwi.TE(tid, err)
+			return
+		}
+		nodeId, ok := m[id]
+		if !ok {
+			return
+		}
+		if isLocalGate(nodeId) {
+			kiwi.Gate().CloseWithId(tid, id, removeHeadKeys, removeCacheKeys)
+			return
+		}
+		c.sendToGate(tid, nodeId, packGateClose(tid, id, removeHeadKeys, removeCacheKeys), nil)
+	})
+}
+
+func (c *ClusterGate) sendToGate(tid, nodeId int64, bytes []byte, handler util.FnBool) {
+	kiwi.Node().SendToNode(nodeId, bytes, func(err *util.Err) {
+		if err != nil {
+			err.AddParam("node id", nodeId)
+			kiwi.TE(tid, err)
+		}
+		if handler != nil {
+			handler(err == nil)
+		}
+	})
+}
+
+func isLocalGate(nodeId int64) bool {
+	return nodeId == kiwi.GetNodeMeta().NodeId && kiwi.Gate() != nil
+}
+
+func packGateSend(tid int64, id string, bytes []byte) []byte {
+	var buffer util.ByteBuffer
+	buffer.InitCap(16 + len(id) + len(bytes))
+	buffer.WUint8(HdGate)
+	buffer.WUint8(gateOpSend)
+	buffer.WInt64(tid)
+	buffer.WString(id)
+	buffer.WBytes(bytes)
+	return buffer.All()
+}
+
+func packGateMultiSend(tid int64, idToPayload map[string][]byte) []byte {
+	var buffer util.ByteBuffer
+	buffer.InitCap(256)
+	buffer.WUint8(HdGate)
+	buffer.WUint8(gateOpMultiSend)
+	buffer.WInt64(tid)
+	buffer.WUint32(uint32(len(idToPayload)))
+	for id, payload := range idToPayload {
+		buffer.WString(id)
+		buffer.WBytes(payload)
+	}
+	return buffer.All()
+}
+
+func packGateClose(tid int64, id string, removeHeadKeys, removeCacheKeys []string) []byte {
+	var buffer util.ByteBuffer
+	buffer.InitCap(64)
+	buffer.WUint8(HdGate)
+	buffer.WUint8(gateOpClose)
+	buffer.WInt64(tid)
+	buffer.WString(id)
+	buffer.WStrings(removeHeadKeys)
+	buffer.WStrings(removeCacheKeys)
+	return buffer.All()
+}
+
+// onGate 其他节点通过ClusterGate发送给本节点网关的消息
+func (n *nodeBase) onGate(agent kiwi.IAgent, bytes []byte) {
+	err := unpackGate(bytes)
+	if err != nil {
+		if agent != nil {
+			err.AddParam("addr", agent.Addr())
+		}
+		kiwi.Error(err)
+	}
+}
+
+func unpackGate(bytes []byte) *util.Err {
+	g := kiwi.Gate()
+	if g == nil {
+		return util.NewErr(util.EcNotExist, util.M{
+			"error": "gate not exist",
+		})
+	}
+	var buffer util.ByteBuffer
+	buffer.InitBytes(bytes)
+	buffer.SetPos(1)
+	op, err := buffer.RUint8()
+	if err != nil {
+		return err
+	}
+	tid, err := buffer.RInt64()
+	if err != nil {
+		return err
+	}
+	switch op {
+	case gateOpSend:
+		id, err := buffer.RString()
+		if err != nil {
+			return err
+		}
+		payload, err := buffer.RBytes()
+		if err != nil {
+			return err
+		}
+		g.Send(tid, id, util.CopyBytes(payload), func(bool) {})
+	case gateOpMultiSend:
+		count, err := buffer.RUint32()
+		if err != nil {
+			return err
+		}
+		idToPayload := make(map[string][]byte, count)
+		for i := uint32(0); i < count; i++ {
+			id, err := buffer.RString()
+			if err != nil {
+				return err
+			}
+			payload, err := buffer.RBytes()
+			if err != nil {
+				return err
+			}
+			idToPayload[id] = util.CopyBytes(payload)
+		}
+		g.MultiSend(tid, idToPayload, func(map[string]bool) {})
+	case gateOpClose:
+		id, err := buffer.RString()
+		if err != nil {
+			return err
+		}
+		head, err := buffer.RStrings()
+		if err != nil {
+			return err
+		}
+		cache, err := buffer.RStrings()
+		if err != nil {
+			return err
+		}
+		g.CloseWithId(tid, id, head, cache)
+	default:
+		return util.NewErr(util.EcNotExist, util.M{
+			"gate op": op,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+)
+
+func TestGatePackUnpack(t *testing.T) {
+	disconnected := make(chan string, 1)
+	g := testGate(nil, GateDisconnected(func(agent kiwi.IAgent, _ *util.Err) {
+		disconnected <- agent.Id()
+	}))
+	a := connectAgent(g, "a", "1.1.1.1:1")
+	b := connectAgent(g, "b", "2.2.2.2:2")
+	a.SetHead("token", "t")
+
+	if err := unpackGate(packGateSend(1, "a", []byte("one"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := unpackGate(packGateMultiSend(2, map[string][]byte{
+		"a": []byte("two"),
+		"b": []byte("three"),
+	})); err != nil {
+		t.Fatal(err)
+	}
+	flushGate(g)
+	if sent := a.Sent(); len(sent) != 2 || sent[0] != "one" || sent[1] != "two" {
+		t.Fatal("a", sent)
+	}
+	if sent := b.Sent(); len(sent) != 1 || sent[0] != "three" {
+		t.Fatal("b", sent)
+	}
+
+	if err := unpackGate(packGateClose(3, "a", []string{"token"}, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if id := <-disconnected; id != "a" {
+		t.Fatal("closed", id)
+	}
+	if _, ok := a.GetHead("token"); ok {
+		t.Fatal("head not removed")
+	}
+
+	bytes := packGateSend(4, "b", []byte("four"))
+	if err := unpackGate(bytes[:len(bytes)-2]); err == nil {
+		t.Fatal("truncated")
+	}
+}
@@ -0,0 +1,211 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+)
+
+var (
+	// HttpBodyMax 请求体的最大字节数
+	HttpBodyMax int64 = 1 << 20
+)
+
+// HttpAuth 从http请求中解析身份,返回的head写入请求头,其中HeadRoleMask用于Authenticate
+type HttpAuth func(r *http.Request) (util.M, *util.Err)
+
+// GateHttpPort 开启http桥接,POST /{svc}/{code} 的json请求转发到服务,返回json响应
+func GateHttpPort(port int) GateOption {
+	return func(option *gateOption) {
+		option.http = port
+	}
+}
+
+// GateHttpAuth http桥接的身份验证,没有设置时角色掩码为0
+func GateHttpAuth(auth HttpAuth) GateOption {
+	return func(option *gateOption) {
+		option.httpAuth = auth
+	}
+}
+
+// HttpJwtAuth 使用Authorization: Bearer jwt,与JwtAuth的claims相同
+func HttpJwtAuth(secret []byte) HttpAuth {
+	return func(r *http.Request) (util.M, *util.Err) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			return nil, util.NewErr(util.EcNoAuth, util.M{
+				"error": "missing token",
+			})
+		}
+		head, _, err := NewJwtAuth(secret).Verify(nil, []byte(token))
+		return head, err
+	}
+}
+
+func (g *gate) startHttp() *util.Err {
+	addr := fmt.Sprintf("%s:%d", g.option.ip, g.option.http)
+	ln, e := net.Listen("tcp", addr)
+	if e != nil {
+		return util.WrapErr(util.EcListenErr, e)
+	}
+	svr := &http.Server{
+		Handler:   http.HandlerFunc(g.onHttp),
+		TLSConfig: g.option.tls,
+	}
+	go func() {
+		if g.option.tls != nil {
+			e = svr.ServeTLS(ln, "", "")
+		} else {
+			e = svr.Serve(ln)
+		}
+		if e != nil && !errors.Is(e, http.ErrServerClosed) {
+			kiwi.Error(util.WrapErr(util.EcListenErr, e))
+		}
+	}()
+	go func() {
+		<-util.Ctx().Done()
+		_ = svr.Close()
+	}()
+	kiwi.Info("http bridge serve", util.M{
+		"addr": ln.Addr().String(),
+	})
+	return nil
+}
+
+func (g *gate) onHttp(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeHttpFail(w, util.EcIllegalOp)
+		return
+	}
+	svc, code, ok := parseHttpPath(r.URL.Path)
+	if !ok {
+		writeHttpFail(w, util.EcNotExist)
+		return
+	}
+	head := util.M{}
+	if g.option.httpAuth != nil {
+		m, err := g.option.httpAuth(r)
+		if err != nil {
+			_MetricGateAuthReject.With(authRejectHandshake).Inc()
+			writeHttpFail(w, util.EcNoAuth)
+			return
+		}
+		for k, v := range m {
+			head[k] = v
+		}
+	}
+	if !g.Authenticate(anyToInt64(head[HeadRoleMask]), svc, code) {
+		_MetricGateAuthReject.With(authRejectRole).Inc()
+		writeHttpFail(w, util.EcNoAuth)
+		return
+	}
+	head["addr"] = r.RemoteAddr
+	if tp := r.Header.Get(kiwi.HeadTraceParent); tp != "" {
+		head[kiwi.HeadTraceParent] = tp
+		if ts := r.Header.Get(kiwi.HeadTraceState); ts != "" {
+			head[kiwi.HeadTraceState] = ts
+		}
+	}
+
+	body, e := io.ReadAll(http.MaxBytesReader(w, r.Body, HttpBodyMax))
+	if e != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(e, &maxErr) {
+			writeHttpFail(w, util.EcTooLong)
+		} else {
+			writeHttpFail(w, util.EcIo)
+		}
+		return
+	}
+	_MetricGateBytesIn.With().Add(float64(len(body)))
+	_, err := kiwi.Codec().JsonUnmarshal2(svc, code, body)
+	if err != nil {
+		writeHttpFail(w, err.Code())
+		return
+	}
+
+	type result struct {
+		code    uint16
+		payload []byte
+	}
+	ch := make(chan result, 1)
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	AsyncReqBytesCtx(ctx, 0, svc, code, head, true, body, func(_ int64, _ util.M, code uint16) {
+		ch <- result{code: code}
+	}, func(_ int64, _ util.M, payload []byte) {
+		ch <- result{payload: util.CopyBytes(payload)}
+	})
+	res := <-ch
+	if res.payload == nil && res.code != 0 {
+		writeHttpFail(w, res.code)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if len(res.payload) == 0 {
+		res.payload = []byte("{}")
+	}
+	_MetricGateBytesOut.With().Add(float64(len(res.payload)))
+	_, _ = w.Write(res.payload)
+}
+
+func parseHttpPath(path string) (kiwi.TSvc, kiwi.TCode, bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	svc, e := strconv.ParseUint(parts[0], 10, 16)
+	if e != nil {
+		return 0, 0, false
+	}
+	code, e := strconv.ParseUint(parts[1], 10, 8)
+	if e != nil {
+		return 0, 0, false
+	}
+	return kiwi.TSvc(svc), kiwi.TCode(code), true
+}
+
+// httpStatus 服务返回的业务错误码小于util.EcMin
+func httpStatus(code uint16) int {
+	if code < util.EcMin {
+		return http.StatusUnprocessableEntity
+	}
+	switch code {
+	case util.EcTimeout:
+		return http.StatusGatewayTimeout
+	case util.EcCanceled:
+		return http.StatusRequestTimeout
+	case util.EcNoAuth:
+		return http.StatusForbidden
+	case util.EcNotExist:
+		return http.StatusNotFound
+	case util.EcIllegalOp:
+		return http.StatusMethodNotAllowed
+	case util.EcTooLong:
+		return http.StatusRequestEntityTooLarge
+	case util.EcParamsErr, util.EcUnmarshallErr, util.EcParseErr, util.EcIo:
+		return http.StatusBadRequest
+	case util.EcUnavailable, util.EcBusy, util.EcNotExistAgent:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeHttpFail(w http.ResponseWriter, code uint16) {
+	bytes, _ := util.JsonMarshal(util.M{
+		"code":  code,
+		"error": util.ErrCodeToStr(code),
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus(code))
+	_, _ = w.Write(bytes)
+}
@@ -0,0 +1,53 @@
+package core
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHttpPath(t *testing.T) {
+	svc, code, ok := parseHttpPath("/10/3")
+	assert.True(t, ok)
+	assert.Equal(t, uint16(10), svc)
+	assert.Equal(t, uint8(3), code)
+
+	_, _, ok = parseHttpPath("/10")
+	assert.False(t, ok)
+	_, _, ok = parseHttpPath("/10/256")
+	assert.False(t, ok)
+	_, _, ok = parseHttpPath("/a/1")
+	assert.False(t, ok)
+}
+
+func TestHttpStatus(t *testing.T) {
+	assert.Equal(t, http.StatusUnprocessableEntity, httpStatus(1))
+	assert.Equal(t, http.StatusGatewayTimeout, httpStatus(util.EcTimeout))
+	assert.Equal(t, http.StatusForbidden, httpStatus(util.EcNoAuth))
+	assert.Equal(t, http.StatusBadRequest, httpStatus(util.EcUnmarshallErr))
+	assert.Equal(t, http.StatusInternalServerError, httpStatus(util.EcServiceErr))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, httpStatus(util.EcTooLong))
+}
+
+func TestHttpBodyMax(t *testing.T) {
+	max := HttpBodyMax
+	HttpBodyMax = 8
+	defer func() {
+		HttpBodyMax = max
+	}()
+	g := &gate{option: &gateOption{
+		httpAuth: func(*http.Request) (util.M, *util.Err) {
+			return util.M{HeadRoleMask: int64(1)}, nil
+		},
+	}}
+	g.SetRoles(map[kiwi.TSvcCode][]int64{kiwi.MergeSvcCode(1, 1): {1}})
+	r := httptest.NewRequest(http.MethodPost, "/1/1", bytes.NewReader(bytes.Repeat([]byte("a"), 16)))
+	w := httptest.NewRecorder()
+	g.onHttp(w, r)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
@@ -0,0 +1,244 @@
+package core
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+)
+
+const (
+	// DefFloodKick 一秒内被限流的包数达到该值时断开连接
+	DefFloodKick = 32
+)
+
+const (
+	throttleAgent = "agent"
+	throttleCode  = "code"
+	throttleIp    = "ip"
+)
+
+// GateLimit 令牌桶,每秒rate个,最多burst个
+type GateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// GateAgentLimit 每个连接每秒的包数
+func GateAgentLimit(rate float64, burst int) GateOption {
+	return func(option *gateOption) {
+		option.agentLimit = &GateLimit{Rate: rate, Burst: burst}
+	}
+}
+
+// GateCodeLimit 每个连接每个方法每秒的包数,需要GateMsgSvcCode解析包
+func GateCodeLimit(svc kiwi.TSvc, code kiwi.TCode, rate float64, burst int) GateOption {
+	return func(option *gateOption) {
+		if option.codeLimits == nil {
+			option.codeLimits = make(map[kiwi.TSvcCode]*GateLimit)
+		}
+		option.codeLimits[kiwi.MergeSvcCode(svc, code)] = &GateLimit{Rate: rate, Burst: burst}
+	}
+}
+
+// GateIpBytesLimit 同一ip所有连接每秒接收的字节数
+func GateIpBytesLimit(bytesPerSec int) GateOption {
+	return func(option *gateOption) {
+		option.ipBytes = bytesPerSec
+	}
+}
+
+// GateMsgSvcCode 从客户端的包中解析服务和方法
+func GateMsgSvcCode(fn func(bytes []byte) (kiwi.TSvc, kiwi.TCode, bool)) GateOption {
+	return func(option *gateOption) {
+		option.msgSvcCode = fn
+	}
+}
+
+// GateFloodKick 一秒内被限流的包数达到kick时以EcFlood断开连接,0不断开
+func GateFloodKick(kick int) GateOption {
+	return func(option *gateOption) {
+		option.floodKick = kick
+	}
+}
+
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   int64
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now().UnixNano(),
+	}
+}
+
+func (b *tokenBucket) take(n float64, now int64) bool {
+	b.tokens += float64(now-b.last) / float64(time.Second) * b.rate
+	b.last = now
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	//超过容量的包在桶满时放行,否则永远无法通过,欠下的令牌按速率补回,总流量不超过速率
+	if b.tokens < n && (n <= b.burst || b.tokens < b.burst) {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+type ipBucket struct {
+	*tokenBucket
+	mtx   sync.Mutex
+	count int
+}
+
+func (b *ipBucket) take(n float64, now int64) bool {
+	b.mtx.Lock()
+	ok := b.tokenBucket.take(n, now)
+	b.mtx.Unlock()
+	return ok
+}
+
+// agentLimiter 只在连接的读协程中使用
+type agentLimiter struct {
+	ip        string
+	agent     *tokenBucket
+	codes     map[kiwi.TSvcCode]*tokenBucket
+	ipBytes   *ipBucket
+	throttled int
+	window    int64
+}
+
+func (l *agentLimiter) check(o *gateOption, bytes []byte, now int64) string {
+	if l.ipBytes != nil && !l.ipBytes.take(float64(len(bytes)), now) {
+		return throttleIp
+	}
+	if l.agent != nil && !l.agent.take(1, now) {
+		return throttleAgent
+	}
+	if l.codes == nil || o.msgSvcCode == nil {
+		return ""
+	}
+	svc, code, ok := o.msgSvcCode(bytes)
+	if !ok {
+		return ""
+	}
+	sc := kiwi.MergeSvcCode(svc, code)
+	b, ok := l.codes[sc]
+	if !ok {
+		limit, ok := o.codeLimits[sc]
+		if !ok {
+			return ""
+		}
+		b = newTokenBucket(limit.Rate, limit.Burst)
+		l.codes[sc] = b
+	}
+	if !b.take(1, now) {
+		return throttleCode
+	}
+	return ""
+}
+
+func (g *gate) hasLimit() bool {
+	o := g.option
+	return o.agentLimit != nil || len(o.codeLimits) > 0 || o.ipBytes > 0
+}
+
+func (g *gate) limitReceiver(addr string, state *agentState, next kiwi.FnAgentBytes) kiwi.FnAgentBytes {
+	o := g.option
+	l := &agentLimiter{}
+	if o.agentLimit != nil {
+		l.agent = newTokenBucket(o.agentLimit.Rate, o.agentLimit.Burst)
+	}
+	if len(o.codeLimits) > 0 {
+		l.codes = make(map[kiwi.TSvcCode]*tokenBucket, len(o.codeLimits))
+	}
+	if o.ipBytes > 0 {
+		l.ip = addrHost(addr)
+		l.ipBytes = g.acquireIpBucket(l.ip)
+	}
+	state.limiter = l
+	return func(agent kiwi.IAgent, bytes []byte) {
+		now := time.Now().UnixNano()
+		reason := l.check(o, bytes, now)
+		if reason == "" {
+			next(agent, bytes)
+			return
+		}
+		_MetricGateThrottle.With(reason).Inc()
+		if o.floodKick == 0 {
+			return
+		}
+		if now-l.window > int64(time.Second) {
+			l.window = now
+			l.throttled = 0
+		}
+		l.throttled++
+		if l.throttled != o.floodKick {
+			return
+		}
+		_MetricGateFloodKick.With().Inc()
+		kiwi.Warn2(util.EcFlood, util.M{
+			"id":     agent.Id(),
+			"addr":   addr,
+			"reason": reason,
+		})
+		state.kick(agent, util.NewErr(util.EcFlood, util.M{
+			"addr":   addr,
+			"reason": reason,
+		}))
+	}
+}
+
+// addrHost 去掉端口,支持ipv6
+func addrHost(addr string) string {
+	host, _, e := net.SplitHostPort(addr)
+	if e != nil {
+		return addr
+	}
+	return host
+}
+
+func (g *gate) releaseLimiter(l *agentLimiter) {
+	if l.ipBytes != nil {
+		g.releaseIpBucket(l.ip)
+	}
+}
+
+func (g *gate) acquireIpBucket(ip string) *ipBucket {
+	g.ipMtx.Lock()
+	defer g.ipMtx.Unlock()
+	b, ok := g.ipToBucket[ip]
+	if !ok {
+		b = &ipBucket{
+			tokenBucket: newTokenBucket(float64(g.option.ipBytes), g.option.ipBytes),
+		}
+		g.ipToBucket[ip] = b
+	}
+	b.count++
+	return b
+}
+
+func (g *gate) releaseIpBucket(ip string) {
+	g.ipMtx.Lock()
+	defer g.ipMtx.Unlock()
+	b, ok := g.ipToBucket[ip]
+	if !ok {
+		return
+	}
+	b.count--
+	if b.count == 0 {
+		delete(g.ipToBucket, ip)
+	}
+}
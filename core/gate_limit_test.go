@@ -0,0 +1,57 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(10, 5)
+	now := b.last
+	for i := 0; i < 5; i++ {
+		if !b.take(1, now) {
+			t.Fatalf("burst %d", i)
+		}
+	}
+	if b.take(1, now) {
+		t.Fatal("over burst")
+	}
+	now += int64(100 * time.Millisecond)
+	if !b.take(1, now) || b.take(1, now) {
+		t.Fatal("refill")
+	}
+	now += int64(time.Hour)
+	if !b.take(5, now) || b.take(1, now) {
+		t.Fatal("cap")
+	}
+}
+
+func TestTokenBucketOversize(t *testing.T) {
+	b := newTokenBucket(100, 100)
+	now := b.last
+	if !b.take(1000, now) || b.take(1, now) {
+		t.Fatal("oversize")
+	}
+	//欠下的900个令牌补回前不放行
+	now += int64(time.Second)
+	if b.take(1, now) || b.take(1000, now) {
+		t.Fatal("oversize debt")
+	}
+	now += 9 * int64(time.Second)
+	if !b.take(1000, now) {
+		t.Fatal("oversize refill")
+	}
+}
+
+func TestAddrHost(t *testing.T) {
+	for addr, host := range map[string]string{
+		"1.2.3.4:80":        "1.2.3.4",
+		"[2001:db8::1]:443": "2001:db8::1",
+		"[::1]:80":          "::1",
+		"1.2.3.4":           "1.2.3.4",
+	} {
+		if h := addrHost(addr); h != host {
+			t.Fatal(addr, h)
+		}
+	}
+}
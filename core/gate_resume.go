@@ -0,0 +1,164 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+)
+
+const (
+	// HeadResumeToken 连接建立时写入head,同时以HdResume开头的包发给客户端,
+	// 客户端重连后带上token由后端调用Resume恢复
+	HeadResumeToken = "resume_token"
+	// DefResumeBuffer 断线期间最多缓存的消息数
+	DefResumeBuffer = 256
+)
+
+// GateResume 断线后保留连接的id、head和cache的时间,期间Send的消息缓存,
+// 超时后才调用GateDisconnected;连接建立时gate把恢复用的token发给客户端
+func GateResume(grace time.Duration) GateOption {
+	return func(option *gateOption) {
+		option.resume = grace
+	}
+}
+
+// GateResumeBuffer 断线期间最多缓存的消息数,超出时立即结束会话
+func GateResumeBuffer(count int) GateOption {
+	return func(option *gateOption) {
+		option.resumeBuffer = count
+	}
+}
+
+type gateSession struct {
+	token  string
+	agent  kiwi.IAgent
+	err    *util.Err
+	buffer [][]byte
+	timer  *time.Timer
+}
+
+func newResumeToken() string {
+	bytes := make([]byte, 16)
+	_, _ = rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
+
+// sendResumeToken 在worker中连接建立后调用,在nonce之后、握手之前发送HdResume和token
+func (g *gate) sendResumeToken(agent kiwi.IAgent) {
+	token := newResumeToken()
+	agent.SetHead(HeadResumeToken, token)
+	err := g.send(agent, append([]byte{HdResume}, token...))
+	if err != nil {
+		err.AddParam("addr", agent.Addr())
+		kiwi.Error(err)
+	}
+}
+
+// Resume 用断线前的token恢复会话到addr的新连接,
+// 新连接继承id、head和cache并按顺序收到断线期间的消息
+func (g *gate) Resume(tid int64, addr, token string, handler util.FnBool) {
+	g.worker.Push(gateResume, tid, addr, token, handler)
+}
+
+// suspend 只在worker中调用
+func (g *gate) suspend(agent kiwi.IAgent, err *util.Err) {
+	token, ok := util.MGet[string](agentHead(agent), HeadResumeToken)
+	if !ok {
+		g.disconnect(agent, err)
+		return
+	}
+	s := &gateSession{
+		token: token,
+		agent: agent,
+		err:   err,
+	}
+	s.timer = time.AfterFunc(g.option.resume, func() {
+		g.worker.Push(gateSessionExpire, token)
+	})
+	g.tokenToSession[token] = s
+	g.idToSession[agent.Id()] = s
+	kiwi.Info("agent suspended", util.M{
+		"id":   agent.Id(),
+		"addr": agent.Addr(),
+	})
+}
+
+func (g *gate) bufferSession(id string, bytes []byte) bool {
+	s, ok := g.idToSession[id]
+	if !ok {
+		return false
+	}
+	if len(s.buffer) >= g.option.resumeBuffer {
+		kiwi.Warn2(util.EcTooMuch, util.M{
+			"id":     id,
+			"buffer": len(s.buffer),
+		})
+		g.endSession(s)
+		return false
+	}
+	s.buffer = append(s.buffer, bytes)
+	return true
+}
+
+func (g *gate) endSession(s *gateSession) {
+	s.timer.Stop()
+	delete(g.tokenToSession, s.token)
+	id := s.agent.Id()
+	if s2, ok := g.idToSession[id]; ok && s2 == s {
+		delete(g.idToSession, id)
+	}
+	g.disconnect(s.agent, s.err)
+}
+
+func (g *gate) resume(tid int64, addr, token string) bool {
+	s, ok := g.tokenToSession[token]
+	if !ok {
+		return false
+	}
+	agent, ok := g.addrToAgent.Get(addr)
+	if !ok {
+		return false
+	}
+	s.timer.Stop()
+	delete(g.tokenToSession, token)
+	id := s.agent.Id()
+	if s2, ok := g.idToSession[id]; ok && s2 == s {
+		delete(g.idToSession, id)
+	}
+
+	head := agentHead(s.agent)
+	delete(head, "addr")
+	delete(head, HeadResumeToken) //新连接已经有新的token
+	cache := util.M{}
+	s.agent.CopyCache(cache)
+	agent.SetHeads(head)
+	agent.SetCaches(cache)
+	oldId := agent.Id()
+	agent.SetId(id)
+	g.idToAgent.ReplaceOrNew(oldId, agent)
+	g.renameChannels(oldId, id)
+	g.dirDel(oldId)
+	g.dirSet(id)
+	for _, bytes := range s.buffer {
+		err := g.send(agent, bytes)
+		if err != nil {
+			err.AddParam("id", id)
+			kiwi.TE(tid, err)
+		}
+	}
+	kiwi.TI(tid, "agent resumed", util.M{
+		"id":     id,
+		"addr":   addr,
+		"buffer": len(s.buffer),
+	})
+	return true
+}
+
+func agentHead(agent kiwi.IAgent) util.M {
+	head := util.M{}
+	agent.CopyHead(head)
+	return head
+}
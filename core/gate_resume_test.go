@@ -0,0 +1,309 @@
+package core
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+)
+
+// testAgent 记录发送的包,Dispose时触发断开
+type testAgent struct {
+	mtx      sync.Mutex
+	id       string
+	addr     string
+	head     util.M
+	cache    util.M
+	sent     [][]byte
+	shared   []*util.SharedBytes
+	sendErr  *util.Err
+	enable   *util.Enable
+	receiver kiwi.FnAgentBytes
+	onClosed kiwi.FnAgentErr
+}
+
+func (a *testAgent) SetHead(key string, val any) {
+	a.mtx.Lock()
+	a.head[key] = val
+	a.mtx.Unlock()
+}
+
+func (a *testAgent) SetHeads(m util.M) {
+	a.mtx.Lock()
+	m.CopyTo(a.head)
+	a.mtx.Unlock()
+}
+
+func (a *testAgent) GetHead(key string) (any, bool) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	val, ok := a.head[key]
+	return val, ok
+}
+
+func (a *testAgent) DelHead(keys ...string) {
+	a.mtx.Lock()
+	for _, key := range keys {
+		delete(a.head, key)
+	}
+	a.mtx.Unlock()
+}
+
+func (a *testAgent) CopyHead(m util.M) {
+	a.mtx.Lock()
+	a.head.CopyTo(m)
+	a.mtx.Unlock()
+	m["addr"] = a.addr
+}
+
+func (a *testAgent) SetCache(key string, val any) {
+	a.mtx.Lock()
+	a.cache[key] = val
+	a.mtx.Unlock()
+}
+
+func (a *testAgent) SetCaches(m util.M) {
+	a.mtx.Lock()
+	m.CopyTo(a.cache)
+	a.mtx.Unlock()
+}
+
+func (a *testAgent) GetCache(key string) (any, bool) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	val, ok := a.cache[key]
+	return val, ok
+}
+
+func (a *testAgent) DelCache(keys ...string) {
+	a.mtx.Lock()
+	for _, key := range keys {
+		delete(a.cache, key)
+	}
+	a.mtx.Unlock()
+}
+
+func (a *testAgent) CopyCache(m util.M) {
+	a.mtx.Lock()
+	a.cache.CopyTo(m)
+	a.mtx.Unlock()
+}
+
+func (a *testAgent) Id() string {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	return a.id
+}
+
+func (a *testAgent) SetId(id string) {
+	a.mtx.Lock()
+	a.id = id
+	a.mtx.Unlock()
+}
+
+func (a *testAgent) Addr() string {
+	return a.addr
+}
+
+func (a *testAgent) Host() string {
+	return addrHost(a.addr)
+}
+
+func (a *testAgent) Enable() *util.Enable {
+	return a.enable
+}
+
+func (a *testAgent) Send(bytes []byte) *util.Err {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if a.sendErr != nil {
+		return a.sendErr
+	}
+	a.sent = append(a.sent, bytes)
+	return nil
+}
+
+// SendShared 与agent相同,写出或失败后Release
+func (a *testAgent) SendShared(shared *util.SharedBytes) *util.Err {
+	defer shared.Release()
+	a.mtx.Lock()
+	a.shared = append(a.shared, shared)
+	a.mtx.Unlock()
+	return a.Send(util.CopyBytes(shared.Bytes()))
+}
+
+func (a *testAgent) Pending() int32 {
+	return 0
+}
+
+func (a *testAgent) SetCompress(bool) {
+}
+
+func (a *testAgent) Dispose() {
+	if a.onClosed != nil {
+		a.onClosed(a, nil)
+	}
+}
+
+func (a *testAgent) BindConnected(kiwi.FnAgent) {
+}
+
+func (a *testAgent) BindDisconnected(fn kiwi.FnAgentErr) {
+	a.onClosed = fn
+}
+
+func (a *testAgent) Sent() []string {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	slc := make([]string, 0, len(a.sent))
+	for _, bytes := range a.sent {
+		slc = append(slc, string(bytes))
+	}
+	return slc
+}
+
+// testGate 不监听端口,receiver为空时丢弃收到的包
+func testGate(receiver kiwi.FnAgentBytes, opts ...GateOption) *gate {
+	if receiver == nil {
+		receiver = func(kiwi.IAgent, []byte) {}
+	}
+	InitGate(receiver, append([]GateOption{GateIp("127.0.0.1")}, opts...)...)
+	return kiwi.Gate().(*gate)
+}
+
+// connectAgent 与onAddTcpConn相同的顺序建立连接
+func connectAgent(g *gate, id, addr string) *testAgent {
+	a := &testAgent{
+		id:     id,
+		addr:   addr,
+		head:   util.M{},
+		cache:  util.M{},
+		enable: util.NewEnable(),
+	}
+	a.receiver = g.agentReceiver(addr)
+	a.BindDisconnected(g.onAgentClosed)
+	g.onAgentConnected(a)
+	flushGate(g)
+	return a
+}
+
+// flushGate 等待worker处理完之前的任务
+func flushGate(g *gate) {
+	ch := make(chan struct{})
+	g.Info(func(util.M) {
+		close(ch)
+	})
+	<-ch
+}
+
+func sendAgent(g *gate, id, str string) bool {
+	ch := make(chan bool, 1)
+	g.Send(0, id, []byte(str), func(ok bool) {
+		ch <- ok
+	})
+	return <-ch
+}
+
+// resumeToken 连接收到的第一个包是HdResume和token
+func resumeToken(t *testing.T, a *testAgent) string {
+	sent := a.Sent()
+	if len(sent) == 0 || sent[0][0] != HdResume {
+		t.Fatal("no token", sent)
+	}
+	return sent[0][1:]
+}
+
+func TestGateResume(t *testing.T) {
+	disconnected := make(chan string, 4)
+	g := testGate(nil, GateResume(time.Minute), GateDisconnected(func(agent kiwi.IAgent, _ *util.Err) {
+		disconnected <- agent.Id()
+	}))
+	a1 := connectAgent(g, "u1", "1.1.1.1:1")
+	token := resumeToken(t, a1)
+	if head, _ := util.MGet[string](agentHead(a1), HeadResumeToken); head != token {
+		t.Fatal("head token", head)
+	}
+	a1.Dispose()
+	if !sendAgent(g, "u1", "a") || !sendAgent(g, "u1", "b") {
+		t.Fatal("not buffered")
+	}
+	if sendAgent(g, "u2", "a") {
+		t.Fatal("unknown id")
+	}
+	a2 := connectAgent(g, "tmp", "2.2.2.2:2")
+	ch := make(chan bool, 1)
+	g.Resume(0, a2.Addr(), token, func(ok bool) {
+		ch <- ok
+	})
+	if !<-ch {
+		t.Fatal("not resumed")
+	}
+	if a2.Id() != "u1" {
+		t.Fatal("id", a2.Id())
+	}
+	if sent := a2.Sent(); len(sent) != 3 || sent[1] != "a" || sent[2] != "b" {
+		t.Fatal("replay", sent)
+	}
+	if token2, _ := util.MGet[string](agentHead(a2), HeadResumeToken); token2 != resumeToken(t, a2) || token2 == token {
+		t.Fatal("token", token2)
+	}
+	g.Resume(0, a2.Addr(), token, func(ok bool) {
+		ch <- ok
+	})
+	if <-ch {
+		t.Fatal("token reused")
+	}
+	if !sendAgent(g, "u1", "c") || len(a2.Sent()) != 4 {
+		t.Fatal("send after resume")
+	}
+	select {
+	case id := <-disconnected:
+		t.Fatal("disconnected", id)
+	default:
+	}
+}
+
+func TestGateResumeOverflow(t *testing.T) {
+	disconnected := make(chan string, 4)
+	g := testGate(nil, GateResume(time.Minute), GateResumeBuffer(1), GateDisconnected(func(agent kiwi.IAgent, _ *util.Err) {
+		disconnected <- agent.Id()
+	}))
+	a := connectAgent(g, "u1", "1.1.1.1:1")
+	a.Dispose()
+	if !sendAgent(g, "u1", "a") {
+		t.Fatal("not buffered")
+	}
+	if sendAgent(g, "u1", "b") {
+		t.Fatal("overflow buffered")
+	}
+	if id := <-disconnected; id != "u1" {
+		t.Fatal("disconnected", id)
+	}
+	if sendAgent(g, "u1", "c") {
+		t.Fatal("session not ended")
+	}
+}
+
+func TestGateResumeExpire(t *testing.T) {
+	disconnected := make(chan string, 4)
+	g := testGate(nil, GateResume(20*time.Millisecond), GateDisconnected(func(agent kiwi.IAgent, _ *util.Err) {
+		disconnected <- agent.Id()
+	}))
+	a := connectAgent(g, "u1", "1.1.1.1:1")
+	start := time.Now()
+	a.Dispose()
+	select {
+	case id := <-disconnected:
+		if id != "u1" || time.Since(start) < 20*time.Millisecond {
+			t.Fatal("expired", id, time.Since(start))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("not expired")
+	}
+	flushGate(g)
+	if len(g.tokenToSession) != 0 || len(g.idToSession) != 0 {
+		t.Fatal("session left")
+	}
+}
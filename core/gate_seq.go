@@ -0,0 +1,132 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+)
+
+const (
+	// SeqHeadLen 客户端包前的nonce和序号
+	SeqHeadLen = 8
+	// MaxSeqWindow 序号窗口的最大值
+	MaxSeqWindow = 64
+)
+
+const (
+	replayShort     = "short"
+	replayNonce     = "nonce"
+	replayDuplicate = "duplicate"
+	replayWindow    = "window"
+)
+
+// GateSeq 连接后网关先发送4字节的nonce,之后客户端的每个包前加4字节nonce和4字节从1开始递增的序号,
+// 序号比收到的最大序号小window以上或者重复的包丢弃,kick为true时断开连接;window为1时只接受递增的序号。
+// nonce和序号在GateCrypto的AEAD之内,中间人无法修改序号重放截获的包,所以必须同时使用GateCrypto;
+// 客户端自己重新编号发送的包无法识别,购买等操作还需要业务上的幂等
+func GateSeq(window int, kick bool) GateOption {
+	return func(option *gateOption) {
+		if window < 1 {
+			window = 1
+		} else if window > MaxSeqWindow {
+			window = MaxSeqWindow
+		}
+		option.seqWindow = window
+		option.seqKick = kick
+	}
+}
+
+// seqWindow 只在连接的读协程中使用
+type seqWindow struct {
+	nonce  uint32
+	window uint32
+	top    uint32
+	bitmap uint64
+}
+
+func newSeqWindow(window int) *seqWindow {
+	var bytes [4]byte
+	_, _ = rand.Read(bytes[:])
+	return &seqWindow{
+		nonce:  binary.BigEndian.Uint32(bytes[:]),
+		window: uint32(window),
+	}
+}
+
+// check bitmap的第i位表示top-i已经收到
+func (w *seqWindow) check(seq uint32) (string, bool) {
+	if seq == 0 {
+		return replayWindow, false
+	}
+	if seq > w.top {
+		shift := seq - w.top
+		if shift >= 64 {
+			w.bitmap = 0
+		} else {
+			w.bitmap <<= shift
+		}
+		w.bitmap |= 1
+		w.top = seq
+		return "", true
+	}
+	diff := w.top - seq
+	if diff >= w.window {
+		return replayWindow, false
+	}
+	if w.bitmap&(1<<diff) != 0 {
+		return replayDuplicate, false
+	}
+	w.bitmap |= 1 << diff
+	return "", true
+}
+
+func (g *gate) seqReceiver(state *agentState, next kiwi.FnAgentBytes) kiwi.FnAgentBytes {
+	w := newSeqWindow(g.option.seqWindow)
+	state.seq = w
+	return func(agent kiwi.IAgent, bytes []byte) {
+		reason := replayShort
+		ok := false
+		if len(bytes) >= SeqHeadLen {
+			if binary.BigEndian.Uint32(bytes) != w.nonce {
+				reason = replayNonce
+			} else {
+				reason, ok = w.check(binary.BigEndian.Uint32(bytes[4:]))
+			}
+		}
+		if ok {
+			next(agent, bytes[SeqHeadLen:])
+			return
+		}
+		_MetricGateReplay.With(reason).Inc()
+		err := util.NewErr(util.EcBadPacket, util.M{
+			"id":     agent.Id(),
+			"addr":   agent.Addr(),
+			"replay": reason,
+		})
+		kiwi.Warn(err)
+		if g.option.seqKick {
+			state.kick(agent, err)
+		}
+	}
+}
+
+// sendSeqNonce 在worker中连接建立后调用,在握手之前发送
+func (g *gate) sendSeqNonce(agent kiwi.IAgent) {
+	v, ok := g.addrToState.Load(agent.Addr())
+	if !ok {
+		return
+	}
+	state := v.(*agentState)
+	if state.seq == nil {
+		return
+	}
+	bytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(bytes, state.seq.nonce)
+	err := g.send(agent, bytes)
+	if err != nil {
+		err.AddParam("addr", agent.Addr())
+		kiwi.Error(err)
+	}
+}
@@ -0,0 +1,48 @@
+package core
+
+import "testing"
+
+func TestSeqWindow(t *testing.T) {
+	w := newSeqWindow(4)
+	for _, seq := range []uint32{1, 2, 5, 3} {
+		if _, ok := w.check(seq); !ok {
+			t.Fatal("reject", seq)
+		}
+	}
+	cases := []struct {
+		seq    uint32
+		reason string
+	}{
+		{0, replayWindow},
+		{2, replayDuplicate},
+		{5, replayDuplicate},
+		{1, replayWindow},
+	}
+	for _, c := range cases {
+		reason, ok := w.check(c.seq)
+		if ok || reason != c.reason {
+			t.Fatal(c.seq, reason)
+		}
+	}
+	if _, ok := w.check(4); !ok {
+		t.Fatal("reject 4")
+	}
+	if _, ok := w.check(100); !ok {
+		t.Fatal("reject 100")
+	}
+	if reason, _ := w.check(96); reason != replayWindow {
+		t.Fatal(reason)
+	}
+
+	strict := newSeqWindow(1)
+	strict.check(1)
+	if _, ok := strict.check(1); ok {
+		t.Fatal("strict duplicate")
+	}
+	if _, ok := strict.check(3); !ok {
+		t.Fatal("strict increase")
+	}
+	if _, ok := strict.check(2); ok {
+		t.Fatal("strict decrease")
+	}
+}
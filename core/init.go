@@ -19,7 +19,7 @@ const (
 )
 
 func init() {
-	fmt.Println(_Logo)
+	fmt.Print(_Logo)
 	fmt.Println("ver:", runtime.Version())
 	fmt.Println("auth:", "95eh")
 	fmt.Println("email:", "eh95@qq.com")
@@ -1,18 +1,254 @@
 package core
 
-import "github.com/15mga/kiwi"
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+)
+
+var (
+	// LogTidTtl 链路级别掩码的保留时间
+	LogTidTtl = 30 * time.Second
+)
+
+// LogRule 服务或方法的日志规则
+type LogRule struct {
+	// Ratio 采样比例,0不记录,1全部记录
+	Ratio float64 `json:"ratio"`
+	// TraceLvl 链路日志级别掩码,0使用默认
+	TraceLvl kiwi.TLevel `json:"trace_lvl,omitempty"`
+}
+
+// LogCtrl 日志控制指令,Logger不为空时修改日志级别,否则修改规则
+type LogCtrl struct {
+	Svc  kiwi.TSvc  `json:"svc,omitempty"`
+	Code kiwi.TCode `json:"code,omitempty"`
+	// AllCode 规则作用于整个服务
+	AllCode bool `json:"all_code,omitempty"`
+	// Rule 为空时删除规则
+	Rule     *LogRule    `json:"rule,omitempty"`
+	Logger   string      `json:"logger,omitempty"`
+	LogLvl   kiwi.TLevel `json:"log_lvl,omitempty"`
+	TraceLvl kiwi.TLevel `json:"trace_lvl,omitempty"`
+}
+
+type logRules struct {
+	svc  map[kiwi.TSvc]*LogRule
+	code map[kiwi.TSvcCode]*LogRule
+}
 
 var (
-	_LogExcludeMap = make(map[kiwi.TSvcCode]struct{})
+	_LogMtx      sync.Mutex
+	_LogRules    atomic.Pointer[logRules]
+	_LogTids     sync.Map
+	_LogTidSweep sync.Once
 )
 
+func init() {
+	_LogRules.Store(&logRules{
+		svc:  map[kiwi.TSvc]*LogRule{},
+		code: map[kiwi.TSvcCode]*LogRule{},
+	})
+	kiwi.SetTraceFilter(filterTrace)
+}
+
+func updateLogRules(fn func(rules *logRules)) {
+	_LogMtx.Lock()
+	old := _LogRules.Load()
+	rules := &logRules{
+		svc:  make(map[kiwi.TSvc]*LogRule, len(old.svc)),
+		code: make(map[kiwi.TSvcCode]*LogRule, len(old.code)),
+	}
+	for k, v := range old.svc {
+		rules.svc[k] = v
+	}
+	for k, v := range old.code {
+		rules.code[k] = v
+	}
+	fn(rules)
+	_LogRules.Store(rules)
+	_LogMtx.Unlock()
+}
+
 func ExcludeLog(svc kiwi.TSvc, codes ...kiwi.TCode) {
 	for _, code := range codes {
-		_LogExcludeMap[kiwi.MergeSvcCode(svc, code)] = struct{}{}
+		SetLogRule(svc, code, &LogRule{})
 	}
 }
 
 func IsExcludeLog(svc kiwi.TSvc, code kiwi.TCode) bool {
-	_, ok := _LogExcludeMap[kiwi.MergeSvcCode(svc, code)]
-	return ok
+	rule := getLogRule(svc, code)
+	return rule != nil && rule.Ratio <= 0
+}
+
+// SetLogRule 设置方法的日志规则,rule为空时删除
+func SetLogRule(svc kiwi.TSvc, code kiwi.TCode, rule *LogRule) {
+	updateLogRules(func(rules *logRules) {
+		key := kiwi.MergeSvcCode(svc, code)
+		if rule == nil {
+			delete(rules.code, key)
+		} else {
+			rules.code[key] = rule
+		}
+	})
+}
+
+// SetSvcLogRule 设置服务的日志规则,方法规则优先,rule为空时删除
+func SetSvcLogRule(svc kiwi.TSvc, rule *LogRule) {
+	updateLogRules(func(rules *logRules) {
+		if rule == nil {
+			delete(rules.svc, svc)
+		} else {
+			rules.svc[svc] = rule
+		}
+	})
+}
+
+// LogRules 当前的规则,key为svc或svc.code
+func LogRules() util.M {
+	rules := _LogRules.Load()
+	m := make(util.M, len(rules.svc)+len(rules.code))
+	for svc, rule := range rules.svc {
+		m[strconv.Itoa(int(svc))] = rule
+	}
+	for key, rule := range rules.code {
+		svc, code := kiwi.SplitSvcCode(key)
+		m[strconv.Itoa(int(svc))+"."+strconv.Itoa(int(code))] = rule
+	}
+	return m
+}
+
+func getLogRule(svc kiwi.TSvc, code kiwi.TCode) *LogRule {
+	rules := _LogRules.Load()
+	if rule, ok := rules.code[kiwi.MergeSvcCode(svc, code)]; ok {
+		return rule
+	}
+	return rules.svc[svc]
+}
+
+// ApplyLogCtrl 在本节点执行日志控制指令
+func ApplyLogCtrl(ctrl *LogCtrl) *util.Err {
+	if ctrl.Logger != "" {
+		name := ctrl.Logger
+		if name == "*" {
+			name = ""
+		}
+		if !kiwi.SetLoggerLevels(name, ctrl.LogLvl, ctrl.TraceLvl) {
+			return util.NewErr(util.EcNotExist, util.M{
+				"logger": ctrl.Logger,
+			})
+		}
+		return nil
+	}
+	if ctrl.AllCode {
+		SetSvcLogRule(ctrl.Svc, ctrl.Rule)
+	} else {
+		SetLogRule(ctrl.Svc, ctrl.Code, ctrl.Rule)
+	}
+	return nil
+}
+
+type nodeBroadcaster interface {
+	broadcast(bytes []byte)
+}
+
+// BroadcastLogCtrl 在本节点执行并通知所有已连接的节点
+func BroadcastLogCtrl(ctrl *LogCtrl) *util.Err {
+	err := ApplyLogCtrl(ctrl)
+	if err != nil {
+		return err
+	}
+	bytes, err := packLogCtrl(ctrl)
+	if err != nil {
+		return err
+	}
+	if b, ok := kiwi.Node().(nodeBroadcaster); ok {
+		b.broadcast(bytes)
+	}
+	return nil
+}
+
+func packLogCtrl(ctrl *LogCtrl) ([]byte, *util.Err) {
+	bytes, err := util.JsonMarshal(ctrl)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{HdLog}, bytes...), nil
+}
+
+func unpackLogCtrl(bytes []byte) (*LogCtrl, *util.Err) {
+	ctrl := &LogCtrl{}
+	err := util.JsonUnmarshal(bytes[1:], ctrl)
+	if err != nil {
+		return nil, err
+	}
+	return ctrl, nil
+}
+
+// logTid 判断链路是否记录,同一个tid在所有节点的结果相同,
+// 规则指定了链路级别时记录到tid上
+func logTid(svc kiwi.TSvc, code kiwi.TCode, tid int64) bool {
+	rule := getLogRule(svc, code)
+	if rule == nil {
+		return true
+	}
+	if !sampleTid(tid, rule.Ratio) {
+		return false
+	}
+	if rule.TraceLvl > 0 {
+		_LogTidSweep.Do(func() {
+			go sweepLogTids()
+		})
+		_LogTids.LoadOrStore(tid, &logTidLvl{
+			lvl:    rule.TraceLvl,
+			expire: time.Now().Add(LogTidTtl).UnixNano(),
+		})
+	}
+	return true
+}
+
+func sampleTid(tid int64, ratio float64) bool {
+	if ratio >= 1 {
+		return true
+	}
+	if ratio <= 0 {
+		return false
+	}
+	//splitmix64,雪花id低位是序号,直接取模分布不均
+	h := uint64(tid) + 0x9e3779b97f4a7c15
+	h = (h ^ (h >> 30)) * 0xbf58476d1ce4e5b9
+	h = (h ^ (h >> 27)) * 0x94d049bb133111eb
+	h ^= h >> 31
+	return h%10000 < uint64(ratio*10000)
+}
+
+type logTidLvl struct {
+	lvl    kiwi.TLevel
+	expire int64
+}
+
+// sweepLogTids 定时清理过期的链路级别,不为每个tid创建计时器
+func sweepLogTids() {
+	for range time.Tick(LogTidTtl / 2) {
+		now := time.Now().UnixNano()
+		_LogTids.Range(func(key, value any) bool {
+			if value.(*logTidLvl).expire < now {
+				_LogTids.Delete(key)
+			}
+			return true
+		})
+	}
+}
+
+// filterTrace 规则指定的链路级别替换日志自身的掩码,可以放宽也可以收紧
+func filterTrace(tid int64) (kiwi.TLevel, bool) {
+	v, ok := _LogTids.Load(tid)
+	if !ok {
+		return 0, false
+	}
+	return v.(*logTidLvl).lvl, true
 }
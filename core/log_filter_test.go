@@ -0,0 +1,49 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/15mga/kiwi"
+)
+
+func TestSampleTid(t *testing.T) {
+	n := 0
+	for tid := int64(1); tid <= 10000; tid++ {
+		if sampleTid(tid, 0.2) {
+			n++
+		}
+		if sampleTid(tid, 0.2) != sampleTid(tid, 0.2) {
+			t.Fatal("not deterministic")
+		}
+	}
+	if n < 1800 || n > 2200 {
+		t.Fatalf("sampled %d", n)
+	}
+}
+
+func TestLogRule(t *testing.T) {
+	ExcludeLog(1, 2)
+	if !IsExcludeLog(1, 2) || IsExcludeLog(1, 3) {
+		t.Fatal("exclude")
+	}
+	_ = ApplyLogCtrl(&LogCtrl{Svc: 1, AllCode: true, Rule: &LogRule{Ratio: 1, TraceLvl: kiwi.TError}})
+	if !logTid(1, 3, 100) || logTid(1, 2, 100) {
+		t.Fatal("rule")
+	}
+	if kiwi.TestTraceLvl(100, kiwi.TInfo, kiwi.TInfo) || !kiwi.TestTraceLvl(100, kiwi.TError, kiwi.TInfo) {
+		t.Fatal("trace level")
+	}
+	//规则可以放宽日志自身的掩码
+	_ = ApplyLogCtrl(&LogCtrl{Svc: 1, Code: 4, Rule: &LogRule{Ratio: 1, TraceLvl: kiwi.TDebug | kiwi.TError}})
+	if !logTid(1, 4, 101) || !kiwi.TestTraceLvl(101, kiwi.TDebug, kiwi.TError) {
+		t.Fatal("raise trace level")
+	}
+	if kiwi.TestTraceLvl(102, kiwi.TDebug, kiwi.TError) {
+		t.Fatal("logger mask")
+	}
+	_ = ApplyLogCtrl(&LogCtrl{Svc: 1, AllCode: true})
+	_ = ApplyLogCtrl(&LogCtrl{Svc: 1, Code: 4})
+	if len(LogRules()) != 1 {
+		t.Fatal("delete")
+	}
+}
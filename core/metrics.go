@@ -0,0 +1,110 @@
+package core
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/metrics"
+	"github.com/15mga/kiwi/util"
+	"github.com/15mga/kiwi/worker"
+)
+
+var (
+	_MetricPkt = metrics.NewCounterVec("kiwi_packet_total",
+		"received packets by svc, code and type", "svc", "code", "type")
+	_MetricPktErr = metrics.NewCounterVec("kiwi_packet_error_total",
+		"failed packets by svc and code", "svc", "code")
+	_MetricPktLatency = metrics.NewHistogramVec("kiwi_packet_latency_seconds",
+		"latency from sender HeadSndTs to completion", nil, "svc", "code")
+	_MetricGateBytesIn = metrics.NewCounterVec("kiwi_gate_bytes_in_total",
+		"bytes received by gate agents")
+	_MetricGateBytesOut = metrics.NewCounterVec("kiwi_gate_bytes_out_total",
+		"bytes sent to gate agents")
+	_MetricGateThrottle = metrics.NewCounterVec("kiwi_gate_throttle_total",
+		"packets dropped by gate rate limits", "reason")
+	_MetricGateFloodKick = metrics.NewCounterVec("kiwi_gate_flood_kick_total",
+		"agents disconnected for flooding")
+	_MetricGateAuthReject = metrics.NewCounterVec("kiwi_gate_auth_reject_total",
+		"agents or packets rejected by gate auth", "reason")
+	_MetricGateReplay = metrics.NewCounterVec("kiwi_gate_replay_total",
+		"packets dropped by gate sequence checks", "reason")
+)
+
+func init() {
+	metrics.NewCounterFunc("kiwi_packet_received_total", "received packets", func() float64 {
+		return float64(ReceivePktCount())
+	})
+	metrics.NewCounterFunc("kiwi_packet_completed_total", "completed packets", func() float64 {
+		return float64(CompletePktCount())
+	})
+	metrics.NewCounterFunc("kiwi_response_send_fail_total", "responses failed to send", func() float64 {
+		return float64(ResponseSendFailCount())
+	})
+	metrics.NewGaugeFunc("kiwi_request_pending", "requests waiting for response", func() float64 {
+		r, ok := kiwi.Router().(*router)
+		if !ok {
+			return 0
+		}
+		return float64(r.idToRequest.Count())
+	})
+	metrics.NewGaugeFunc("kiwi_gate_agents", "connected gate agents", func() float64 {
+		g, ok := kiwi.Gate().(*gate)
+		if !ok {
+			return 0
+		}
+		return float64(g.AgentCount())
+	})
+	workers := metrics.NewGaugeVec("kiwi_worker_queue", "pending jobs of workers", "worker")
+	metrics.Register(&workerCollector{GaugeVec: workers})
+}
+
+// workerCollector 采集时更新worker队列长度
+type workerCollector struct {
+	*metrics.GaugeVec
+}
+
+func (c *workerCollector) Write(b *strings.Builder) {
+	if a := worker.Active(); a != nil {
+		c.With("active").Set(float64(a.Len()))
+	}
+	if s := worker.Share(); s != nil {
+		c.With("share").Set(float64(s.Len()))
+	}
+	if g := worker.Global(); g != nil {
+		c.With("global").Set(float64(g.Len()))
+	}
+	c.GaugeVec.Write(b)
+}
+
+func metricPkt(msgType uint8, svc kiwi.TSvc, code kiwi.TCode) {
+	var typ string
+	switch msgType {
+	case HdPush:
+		typ = "push"
+	case HdRequest:
+		typ = "request"
+	case HdNotify:
+		typ = "notify"
+	default:
+		typ = strconv.Itoa(int(msgType))
+	}
+	_MetricPkt.With(strconv.Itoa(int(svc)), strconv.Itoa(int(code)), typ).Inc()
+}
+
+func metricPktComplete(svc kiwi.TSvc, code kiwi.TCode, head util.M) {
+	sndTs, ok := util.MGet[int64](head, HeadSndTs)
+	if !ok {
+		return
+	}
+	dur := time.Now().UnixMilli() - sndTs
+	if dur < 0 {
+		dur = 0
+	}
+	_MetricPktLatency.With(strconv.Itoa(int(svc)), strconv.Itoa(int(code))).Observe(float64(dur) / 1000)
+}
+
+func metricPktErr(svc kiwi.TSvc, code kiwi.TCode) {
+	_MetricPktErr.With(strconv.Itoa(int(svc)), strconv.Itoa(int(code))).Inc()
+}
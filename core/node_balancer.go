@@ -0,0 +1,257 @@
+package core
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/ds"
+	"github.com/15mga/kiwi/util"
+	"github.com/15mga/kiwi/worker"
+)
+
+const (
+	DefWeightKey    = "weight"
+	DefHashReplicas = 128
+)
+
+type NodeDialerSet = ds.Set2Item[kiwi.TSvc, int64, kiwi.INodeDialer]
+
+// INodeBalancer 服务节点选择策略,所有方法都在nodeNet的worker中调用
+type INodeBalancer interface {
+	// Add 节点连接,head为节点注册的NodeMeta.Data
+	Add(nodeId int64, head util.M)
+	// Del 节点断开
+	Del(nodeId int64)
+	// Select 从可用节点中选择一个,head为发送包的头
+	Select(head util.M, set *NodeDialerSet) (int64, *util.Err)
+}
+
+// NewSelectorBalancer 兼容NodeDialerSelector
+func NewSelectorBalancer(selector NodeDialerSelector) INodeBalancer {
+	return &selectorBalancer{
+		selector: selector,
+	}
+}
+
+type selectorBalancer struct {
+	selector NodeDialerSelector
+}
+
+func (b *selectorBalancer) Add(int64, util.M) {
+}
+
+func (b *selectorBalancer) Del(int64) {
+}
+
+func (b *selectorBalancer) Select(_ util.M, set *NodeDialerSet) (int64, *util.Err) {
+	return b.selector(set)
+}
+
+func RandomSelector(set *NodeDialerSet) (int64, *util.Err) {
+	i := rand.Intn(set.Count())
+	dialer, _ := set.GetWithIdx(i)
+	return dialer.NodeId(), nil
+}
+
+func NewRandomBalancer() INodeBalancer {
+	return NewSelectorBalancer(RandomSelector)
+}
+
+func NewRoundRobinBalancer() INodeBalancer {
+	return &roundRobinBalancer{}
+}
+
+type roundRobinBalancer struct {
+	idx int
+}
+
+func (b *roundRobinBalancer) Add(int64, util.M) {
+}
+
+func (b *roundRobinBalancer) Del(int64) {
+}
+
+func (b *roundRobinBalancer) Select(_ util.M, set *NodeDialerSet) (int64, *util.Err) {
+	b.idx = (b.idx + 1) % set.Count()
+	dialer, _ := set.GetWithIdx(b.idx)
+	return dialer.NodeId(), nil
+}
+
+// NewWeightedBalancer 平滑加权轮询,权重取自节点head的key字段,缺省为1
+func NewWeightedBalancer(key string) INodeBalancer {
+	if key == "" {
+		key = DefWeightKey
+	}
+	return &weightedBalancer{
+		key:        key,
+		idToWeight: make(map[int64]*nodeWeight, 4),
+	}
+}
+
+type nodeWeight struct {
+	weight  int
+	current int
+}
+
+type weightedBalancer struct {
+	key        string
+	idToWeight map[int64]*nodeWeight
+}
+
+func (b *weightedBalancer) Add(nodeId int64, head util.M) {
+	weight := headInt(head, b.key)
+	if weight <= 0 {
+		weight = 1
+	}
+	b.idToWeight[nodeId] = &nodeWeight{
+		weight: weight,
+	}
+}
+
+func (b *weightedBalancer) Del(nodeId int64) {
+	delete(b.idToWeight, nodeId)
+}
+
+func (b *weightedBalancer) Select(_ util.M, set *NodeDialerSet) (int64, *util.Err) {
+	var (
+		total int
+		best  *nodeWeight
+		id    int64
+	)
+	set.Iter(func(dialer kiwi.INodeDialer) {
+		nw, ok := b.idToWeight[dialer.NodeId()]
+		if !ok {
+			nw = &nodeWeight{weight: 1}
+			b.idToWeight[dialer.NodeId()] = nw
+		}
+		nw.current += nw.weight
+		total += nw.weight
+		if best == nil || nw.current > best.current {
+			best = nw
+			id = dialer.NodeId()
+		}
+	})
+	best.current -= total
+	return id, nil
+}
+
+// NewLeastPendingBalancer 选择未完成请求最少的节点
+func NewLeastPendingBalancer() INodeBalancer {
+	return &leastPendingBalancer{}
+}
+
+type leastPendingBalancer struct {
+}
+
+func (b *leastPendingBalancer) Add(int64, util.M) {
+}
+
+func (b *leastPendingBalancer) Del(int64) {
+}
+
+func (b *leastPendingBalancer) Select(_ util.M, set *NodeDialerSet) (int64, *util.Err) {
+	var (
+		id  int64
+		min int64 = -1
+	)
+	set.Iter(func(dialer kiwi.INodeDialer) {
+		pending := NodePending(dialer.NodeId())
+		if min < 0 || pending < min {
+			min = pending
+			id = dialer.NodeId()
+		}
+	})
+	return id, nil
+}
+
+// NewHashBalancer 一致性哈希,按包头key的值选择节点,同一个值总是落在同一个节点
+func NewHashBalancer(key string, replicas int) INodeBalancer {
+	if replicas <= 0 {
+		replicas = DefHashReplicas
+	}
+	return &hashBalancer{
+		key:         key,
+		replicas:    replicas,
+		pointToNode: make(map[int64]int64, replicas*4),
+	}
+}
+
+type hashBalancer struct {
+	key         string
+	replicas    int
+	points      []int64
+	pointToNode map[int64]int64
+}
+
+func (b *hashBalancer) Add(nodeId int64, _ util.M) {
+	for i := 0; i < b.replicas; i++ {
+		p := worker.FnvStr(strconv.FormatInt(nodeId, 10) + "#" + strconv.Itoa(i))
+		if _, ok := b.pointToNode[p]; ok {
+			continue
+		}
+		b.pointToNode[p] = nodeId
+		b.points = append(b.points, p)
+	}
+	sort.Slice(b.points, func(i, j int) bool {
+		return b.points[i] < b.points[j]
+	})
+}
+
+func (b *hashBalancer) Del(nodeId int64) {
+	points := b.points[:0]
+	for _, p := range b.points {
+		if b.pointToNode[p] == nodeId {
+			delete(b.pointToNode, p)
+			continue
+		}
+		points = append(points, p)
+	}
+	b.points = points
+}
+
+func (b *hashBalancer) Select(head util.M, set *NodeDialerSet) (int64, *util.Err) {
+	val, ok := head[b.key]
+	if !ok || len(b.points) == 0 {
+		return RandomSelector(set)
+	}
+	h := worker.FnvStr(fmt.Sprint(val))
+	l := len(b.points)
+	i := sort.Search(l, func(i int) bool {
+		return b.points[i] >= h
+	})
+	//顺时针找到第一个可用节点
+	for j := 0; j < l; j++ {
+		nodeId := b.pointToNode[b.points[(i+j)%l]]
+		if set.Has(nodeId) {
+			return nodeId, nil
+		}
+	}
+	return RandomSelector(set)
+}
+
+func headInt(head util.M, key string) int {
+	v, ok := head[key]
+	if !ok {
+		return 0
+	}
+	switch w := v.(type) {
+	case int:
+		return w
+	case int32:
+		return int(w)
+	case int64:
+		return int(w)
+	case uint32:
+		return int(w)
+	case float64:
+		return int(w)
+	case string:
+		i, _ := strconv.Atoi(w)
+		return i
+	default:
+		return 0
+	}
+}
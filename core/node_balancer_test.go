@@ -0,0 +1,65 @@
+package core
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/ds"
+	"github.com/15mga/kiwi/util"
+)
+
+func newTestDialerSet(ids ...int64) *NodeDialerSet {
+	set := ds.NewSet2Item[kiwi.TSvc, int64, kiwi.INodeDialer](1, len(ids), func(dialer kiwi.INodeDialer) int64 {
+		return dialer.NodeId()
+	})
+	for _, id := range ids {
+		set.Set(&nodeDialer{svc: 1, nodeId: id})
+	}
+	return set
+}
+
+func TestHashBalancer(t *testing.T) {
+	b := NewHashBalancer("uid", 0)
+	set := newTestDialerSet(1, 2, 3)
+	for i := int64(1); i <= 3; i++ {
+		b.Add(i, nil)
+	}
+	before := make(map[string]int64, 1000)
+	for i := 0; i < 1000; i++ {
+		uid := strconv.Itoa(i)
+		id, _ := b.Select(util.M{"uid": uid}, set)
+		again, _ := b.Select(util.M{"uid": uid}, set)
+		if id != again {
+			t.Fatalf("uid %s not sticky: %d %d", uid, id, again)
+		}
+		before[uid] = id
+	}
+
+	b.Del(3)
+	_, _ = set.Del(3)
+	for uid, id := range before {
+		after, _ := b.Select(util.M{"uid": uid}, set)
+		if id != 3 && after != id {
+			t.Fatalf("uid %s moved from %d to %d", uid, id, after)
+		}
+		if after == 3 {
+			t.Fatalf("uid %s selected removed node", uid)
+		}
+	}
+}
+
+func TestWeightedBalancer(t *testing.T) {
+	b := NewWeightedBalancer("")
+	set := newTestDialerSet(1, 2)
+	b.Add(1, util.M{DefWeightKey: float64(3)})
+	b.Add(2, util.M{DefWeightKey: 1})
+	count := map[int64]int{}
+	for i := 0; i < 400; i++ {
+		id, _ := b.Select(nil, set)
+		count[id]++
+	}
+	if count[1] != 300 || count[2] != 100 {
+		t.Fatalf("unexpected distribution %v", count)
+	}
+}
@@ -51,12 +51,20 @@ func (n *nodeBase) SendToNode(nodeId int64, bytes []byte, fnErr util.FnErr) {
 	panic("implement me")
 }
 
+func (n *nodeBase) Drain(nodeId int64) {
+}
+
+func (n *nodeBase) Dispose() {
+}
+
 func (n *nodeBase) receive(agent kiwi.IAgent, bytes []byte) {
 	switch bytes[0] {
 	case HdPush:
 		n.onPush(agent, bytes)
 	case HdRequest:
 		n.onRequest(agent, bytes)
+	case HdCancel:
+		n.onCancel(agent, bytes)
 	case HdOk:
 		n.onResponseOk(agent, bytes)
 	case HdFail:
@@ -67,6 +75,12 @@ func (n *nodeBase) receive(agent kiwi.IAgent, bytes []byte) {
 		n.onNotify(agent, bytes)
 	case HdWatch:
 		n.onWatchNotify(agent, bytes)
+	case HdLog:
+		n.onLog(agent, bytes)
+	case HdGate:
+		n.onGate(agent, bytes)
+	case HdStreamOpen, HdStreamData, HdStreamEnd, HdStreamCancel, HdStreamCredit:
+		n.onStream(agent, bytes)
 	default:
 		kiwi.Error2(util.EcNotExist, util.M{
 			"head": bytes[0],
@@ -78,6 +92,19 @@ func (n *nodeBase) onHeartbeat(agent kiwi.IAgent, bytes []byte) {
 
 }
 
+func (n *nodeBase) onLog(agent kiwi.IAgent, bytes []byte) {
+	ctrl, err := unpackLogCtrl(bytes)
+	if err == nil {
+		err = ApplyLogCtrl(ctrl)
+	}
+	if err != nil {
+		if agent != nil {
+			err.AddParam("addr", agent.Addr())
+		}
+		kiwi.Error(err)
+	}
+}
+
 func (n *nodeBase) onPush(agent kiwi.IAgent, bytes []byte) {
 	pkt := NewRcvPusPkt()
 	err := kiwi.Packer().UnpackPush(bytes, pkt)
@@ -101,9 +128,20 @@ func (n *nodeBase) onRequest(agent kiwi.IAgent, bytes []byte) {
 		kiwi.Error(err)
 		return
 	}
+	watchCancel(pkt)
 	kiwi.Router().OnRequest(pkt)
 }
 
+func (n *nodeBase) onCancel(agent kiwi.IAgent, bytes []byte) {
+	err := cancelRequest(bytes)
+	if err != nil {
+		if agent != nil {
+			err.AddParam("addr", agent.Addr())
+		}
+		kiwi.Error(err)
+	}
+}
+
 func (n *nodeBase) onResponseOk(agent kiwi.IAgent, bytes []byte) {
 	head := make(util.M)
 	tid, payload, err := kiwi.Packer().UnpackResponseOk(bytes, head)
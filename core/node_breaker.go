@@ -0,0 +1,196 @@
+package core
+
+import (
+	"sync"
+	"time"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+)
+
+var (
+	// BreakerFailCount 连续失败多少次后熔断,0不熔断
+	BreakerFailCount = 5
+	// BreakerOpenDur 熔断后多久进入半开状态
+	BreakerOpenDur = 10 * time.Second
+	_NodeBreakers  sync.Map
+)
+
+type nodeDispatcher interface {
+	dispatch(name string, data any)
+}
+
+func newNodeBreaker(svc kiwi.TSvc, nodeId int64) *nodeBreaker {
+	return &nodeBreaker{
+		svc:    svc,
+		nodeId: nodeId,
+	}
+}
+
+// nodeBreaker 远程节点熔断器,半开状态同时只放行一个探测请求
+type nodeBreaker struct {
+	mtx      sync.Mutex
+	svc      kiwi.TSvc
+	nodeId   int64
+	state    kiwi.BreakerState
+	fails    int
+	openTs   time.Time
+	probing  bool
+	disposed bool
+}
+
+// ready 节点是否可以被选择,不占用探测名额
+func (b *nodeBreaker) ready() bool {
+	b.mtx.Lock()
+	switch b.state {
+	case kiwi.BreakerOpen:
+		if time.Since(b.openTs) < BreakerOpenDur {
+			b.mtx.Unlock()
+			return false
+		}
+		b.state = kiwi.BreakerHalfOpen
+		b.mtx.Unlock()
+		b.onState(kiwi.BreakerHalfOpen)
+		return true
+	case kiwi.BreakerHalfOpen:
+		ok := !b.probing
+		b.mtx.Unlock()
+		return ok
+	default:
+		b.mtx.Unlock()
+		return true
+	}
+}
+
+// acquire 请求发往节点,半开状态下作为探测请求
+func (b *nodeBreaker) acquire() {
+	b.mtx.Lock()
+	if b.state == kiwi.BreakerHalfOpen {
+		b.probing = true
+	}
+	b.mtx.Unlock()
+}
+
+func (b *nodeBreaker) release() {
+	b.mtx.Lock()
+	b.probing = false
+	b.mtx.Unlock()
+}
+
+// report 熔断前发出的请求可能在熔断后才成功,熔断中忽略成功,只能通过半开探测恢复
+func (b *nodeBreaker) report(ok bool) {
+	b.mtx.Lock()
+	b.probing = false
+	old := b.state
+	if ok && old == kiwi.BreakerOpen {
+		b.mtx.Unlock()
+		return
+	}
+	if ok {
+		b.fails = 0
+		b.state = kiwi.BreakerClosed
+	} else {
+		b.fails++
+		if old == kiwi.BreakerHalfOpen || (old == kiwi.BreakerClosed && b.fails >= BreakerFailCount) {
+			b.state = kiwi.BreakerOpen
+			b.openTs = time.Now()
+		}
+	}
+	state := b.state
+	b.mtx.Unlock()
+	if state != old {
+		b.onState(state)
+	}
+}
+
+func (b *nodeBreaker) onState(state kiwi.BreakerState) {
+	b.mtx.Lock()
+	disposed := b.disposed
+	b.mtx.Unlock()
+	if disposed {
+		return
+	}
+	kiwi.Info("node breaker", util.M{
+		"svc":     b.svc,
+		"node id": b.nodeId,
+		"state":   state,
+	})
+	evt := &kiwi.EvtNodeBreaker{
+		Svc:   b.svc,
+		Id:    b.nodeId,
+		State: state,
+	}
+	//报告来自计时器和网络协程,事件在节点的worker中分发
+	if d, ok := kiwi.Node().(nodeDispatcher); ok {
+		d.dispatch(kiwi.Evt_Node_Breaker, evt)
+		return
+	}
+	kiwi.DispatchEvent(kiwi.Evt_Node_Breaker, evt)
+}
+
+func (b *nodeBreaker) dispose() {
+	b.mtx.Lock()
+	b.disposed = true
+	b.mtx.Unlock()
+}
+
+func addNodeBreaker(svc kiwi.TSvc, nodeId int64) {
+	if BreakerFailCount <= 0 {
+		return
+	}
+	_NodeBreakers.Store(nodeId, newNodeBreaker(svc, nodeId))
+}
+
+func delNodeBreaker(nodeId int64) {
+	b, ok := _NodeBreakers.LoadAndDelete(nodeId)
+	if ok {
+		b.(*nodeBreaker).dispose()
+	}
+}
+
+func getNodeBreaker(nodeId int64) (*nodeBreaker, bool) {
+	b, ok := _NodeBreakers.Load(nodeId)
+	if !ok {
+		return nil, false
+	}
+	return b.(*nodeBreaker), true
+}
+
+// NodeBreakerState 节点熔断状态
+func NodeBreakerState(nodeId int64) kiwi.BreakerState {
+	b, ok := getNodeBreaker(nodeId)
+	if !ok {
+		return kiwi.BreakerClosed
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.state
+}
+
+func nodeReady(nodeId int64) bool {
+	b, ok := getNodeBreaker(nodeId)
+	return !ok || b.ready()
+}
+
+func acquireNode(nodeId int64) {
+	b, ok := getNodeBreaker(nodeId)
+	if ok {
+		b.acquire()
+	}
+}
+
+// reportNode 业务失败码不计入,调用方取消也不计入
+func reportNode(nodeId int64, code uint16) {
+	if nodeId == 0 {
+		return
+	}
+	b, ok := getNodeBreaker(nodeId)
+	if !ok {
+		return
+	}
+	if code == util.EcCanceled {
+		b.release()
+		return
+	}
+	b.report(code < util.EcMin)
+}
@@ -0,0 +1,69 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+)
+
+func TestNodeBreaker(t *testing.T) {
+	failCount, openDur := BreakerFailCount, BreakerOpenDur
+	BreakerFailCount, BreakerOpenDur = 3, 20*time.Millisecond
+	defer func() {
+		BreakerFailCount, BreakerOpenDur = failCount, openDur
+	}()
+	const nodeId = 101
+	addNodeBreaker(1, nodeId)
+	defer delNodeBreaker(nodeId)
+
+	//业务失败码不计入
+	for i := 0; i < BreakerFailCount; i++ {
+		reportNode(nodeId, 1)
+	}
+	for i := 0; i < BreakerFailCount-1; i++ {
+		reportNode(nodeId, util.EcTimeout)
+	}
+	if NodeBreakerState(nodeId) != kiwi.BreakerClosed || !nodeReady(nodeId) {
+		t.Fatal("closed", NodeBreakerState(nodeId))
+	}
+	reportNode(nodeId, util.EcTimeout)
+	if NodeBreakerState(nodeId) != kiwi.BreakerOpen || nodeReady(nodeId) {
+		t.Fatal("open", NodeBreakerState(nodeId))
+	}
+	//熔断前发出的请求迟到的成功不能跳过半开
+	reportNode(nodeId, 0)
+	if NodeBreakerState(nodeId) != kiwi.BreakerOpen {
+		t.Fatal("late ok", NodeBreakerState(nodeId))
+	}
+
+	time.Sleep(BreakerOpenDur)
+	if !nodeReady(nodeId) || NodeBreakerState(nodeId) != kiwi.BreakerHalfOpen {
+		t.Fatal("half open", NodeBreakerState(nodeId))
+	}
+	//半开只放行一个探测,取消时归还名额
+	acquireNode(nodeId)
+	if nodeReady(nodeId) {
+		t.Fatal("single probe")
+	}
+	reportNode(nodeId, util.EcCanceled)
+	if !nodeReady(nodeId) {
+		t.Fatal("probe released")
+	}
+	acquireNode(nodeId)
+	reportNode(nodeId, util.EcTimeout)
+	if NodeBreakerState(nodeId) != kiwi.BreakerOpen {
+		t.Fatal("probe fail", NodeBreakerState(nodeId))
+	}
+
+	time.Sleep(BreakerOpenDur)
+	if !nodeReady(nodeId) {
+		t.Fatal("half open again")
+	}
+	acquireNode(nodeId)
+	reportNode(nodeId, 0)
+	if NodeBreakerState(nodeId) != kiwi.BreakerClosed || !nodeReady(nodeId) {
+		t.Fatal("probe ok", NodeBreakerState(nodeId))
+	}
+}
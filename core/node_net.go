@@ -1,23 +1,37 @@
 package core
 
 import (
+	"crypto/tls"
 	"fmt"
 	"github.com/15mga/kiwi"
 	"github.com/15mga/kiwi/ds"
 	"github.com/15mga/kiwi/network"
 	"github.com/15mga/kiwi/util"
 	"github.com/15mga/kiwi/worker"
-	"math/rand"
+	"github.com/xtaci/kcp-go/v5"
 	"net"
+	"strconv"
+	"time"
 )
 
 type (
 	NodeOption func(opt *nodeOption)
 	nodeOption struct {
-		ip       string
-		port     int
-		connType NodeConnType
-		selector NodeDialerSelector
+		ip          string
+		port        int
+		connType    NodeConnType
+		newBalancer func() INodeBalancer
+		balancers   map[kiwi.TSvc]INodeBalancer
+		tlsServer   *tls.Config
+		tlsClient   *tls.Config
+		certFile    string
+		keyFile     string
+		caFile      string
+		compressor  kiwi.ICompressor
+		compressMin int
+		udpBlock    kcp.BlockCrypt
+		crypto      bool
+		cryptoPsk   []byte
 	}
 	NodeConnType uint8
 )
@@ -49,7 +63,66 @@ func NodePort(port int) NodeOption {
 
 func NodeSelector(selector NodeDialerSelector) NodeOption {
 	return func(opt *nodeOption) {
-		opt.selector = selector
+		opt.newBalancer = func() INodeBalancer {
+			return NewSelectorBalancer(selector)
+		}
+	}
+}
+
+// NodeDefBalancer 未单独指定策略的服务使用的选择策略,默认随机
+func NodeDefBalancer(fac func() INodeBalancer) NodeOption {
+	return func(opt *nodeOption) {
+		opt.newBalancer = fac
+	}
+}
+
+// NodeSvcBalancer 指定服务的选择策略
+func NodeSvcBalancer(svc kiwi.TSvc, balancer INodeBalancer) NodeOption {
+	return func(opt *nodeOption) {
+		opt.balancers[svc] = balancer
+	}
+}
+
+// NodeTls 节点间连接使用tls,server要求客户端证书时为mTLS,只支持Tcp
+func NodeTls(server, client *tls.Config) NodeOption {
+	return func(opt *nodeOption) {
+		opt.tlsServer = server
+		opt.tlsClient = client
+	}
+}
+
+// NodeMTls 所有节点使用同一个ca签发的证书,只有集群成员可以连接,证书文件更新后自动加载,
+// 证书需要包含节点ip
+func NodeMTls(certFile, keyFile, caFile string) NodeOption {
+	return func(opt *nodeOption) {
+		opt.certFile = certFile
+		opt.keyFile = keyFile
+		opt.caFile = caFile
+	}
+}
+
+// NodeUdpCrypt udp节点之间kcp的包加密,所有节点使用相同的key,需要保密时同时使用NodeCrypto
+func NodeUdpCrypt(block kcp.BlockCrypt) NodeOption {
+	return func(opt *nodeOption) {
+		opt.udpBlock = block
+	}
+}
+
+// NodeCrypto 节点之间连接的AES-GCM加密,所有节点使用相同的psk,
+// 连接是单向的,拨号端发送随机salt后用psk和salt派生密钥,见kiwi.AgentCrypto
+func NodeCrypto(psk []byte) NodeOption {
+	return func(opt *nodeOption) {
+		opt.crypto = true
+		opt.cryptoPsk = psk
+	}
+}
+
+// NodeCompress 通过NodeMeta.Data的"compress"告知其他节点,
+// 其他节点按名称找到network.RegisterCompressor注册的compressor后压缩发送给本节点大于等于min的包
+func NodeCompress(compressor kiwi.ICompressor, min int) NodeOption {
+	return func(opt *nodeOption) {
+		opt.compressor = compressor
+		opt.compressMin = min
 	}
 }
 
@@ -59,16 +132,18 @@ func InitNodeNet(opts ...NodeOption) {
 
 func NewNodeNet(opts ...NodeOption) kiwi.INode {
 	opt := &nodeOption{
-		connType: Tcp,
-		selector: func(set *ds.Set2Item[kiwi.TSvc, int64, kiwi.INodeDialer]) (int64, *util.Err) {
-			i := rand.Intn(set.Count())
-			dialer, _ := set.GetWithIdx(i)
-			return dialer.NodeId(), nil
-		},
+		connType:    Tcp,
+		newBalancer: NewRandomBalancer,
+		balancers:   make(map[kiwi.TSvc]INodeBalancer),
 	}
 	for _, o := range opts {
 		o(opt)
 	}
+	if opt.crypto && len(opt.cryptoPsk) == 0 {
+		kiwi.Fatal2(util.EcParamsErr, util.M{
+			"error": "NodeCrypto requires psk",
+		})
+	}
 	n := &nodeNet{
 		option:   opt,
 		nodeBase: newNodeBase(),
@@ -79,19 +154,51 @@ func NewNodeNet(opts ...NodeOption) kiwi.INode {
 			return dialer.NodeId()
 		}),
 		codeToWatchers: make(map[kiwi.TCode]map[int64]struct{}),
+		watcherToCodes: make(map[int64][]kiwi.TCode),
+		drainNodes:     make(map[int64]struct{}),
 	}
+	kiwi.BindEvent(kiwi.Evt_Svc_Connected, n.onSvcConnected)
+	kiwi.BindEvent(kiwi.Evt_Svc_Disonnected, n.onSvcDisconnected)
+	kiwi.BindEvent(kiwi.Evt_Node_Register, onNodeRegister)
 	ip, err := util.CheckLocalIp(opt.ip)
 	if err != nil {
 		kiwi.Fatal(err)
 	}
 	opt.ip = ip
+	if opt.certFile != "" {
+		opt.tlsServer, err = network.NewTlsServerConf(opt.certFile, opt.keyFile, opt.caFile)
+		if err != nil {
+			kiwi.Fatal(err)
+		}
+		opt.tlsClient, err = network.NewTlsClientConf(opt.certFile, opt.keyFile, opt.caFile, "")
+		if err != nil {
+			kiwi.Fatal(err)
+		}
+	}
+	if opt.connType != Tcp && (opt.tlsServer != nil || opt.tlsClient != nil) {
+		kiwi.Fatal2(util.EcNotImplement, util.M{
+			"conn type": opt.connType,
+			"error":     "tls only supports tcp",
+		})
+	}
+	if opt.compressor != nil {
+		kiwi.GetNodeMeta().Data.Set(nodeHeadCompress, opt.compressor.Name())
+	}
 	n.worker = worker.NewJobWorker(n.processor)
 	addr := fmt.Sprintf("%s:%d", n.option.ip, n.option.port)
 	switch opt.connType {
 	case Tcp:
-		n.listener = network.NewTcpListener(addr, n.onAddTcpConn)
+		if opt.tlsServer != nil {
+			n.listener = network.NewTcpTlsListener(addr, opt.tlsServer, n.onAddTcpConn)
+		} else {
+			n.listener = network.NewTcpListener(addr, n.onAddTcpConn)
+		}
 	case Udp:
-		n.listener = network.NewUdpListener(addr, n.onAddUdpConn)
+		if opt.udpBlock != nil {
+			n.listener = network.NewUdpCryptListener(addr, opt.udpBlock, n.onAddUdpConn)
+		} else {
+			n.listener = network.NewUdpListener(addr, n.onAddUdpConn)
+		}
 	}
 
 	err = n.listener.Start()
@@ -112,6 +219,7 @@ type nodeNet struct {
 	listener       kiwi.IListener
 	codeToWatchers map[kiwi.TCode]map[int64]struct{} //本机方法的远程监听者
 	watcherToCodes map[int64][]kiwi.TCode            //远程机监听的方法
+	drainNodes     map[int64]struct{}                //排空中的远程节点
 }
 
 func (n *nodeNet) Init() *util.Err {
@@ -201,7 +309,7 @@ func (n *nodeNet) RequestNode(nodeId int64, req kiwi.ISndRequest) {
 		n.requestSelf(req)
 		return
 	}
-	n.worker.Push(nodeRequestNode, req)
+	n.worker.Push(nodeRequestNode, nodeId, req)
 }
 
 func (n *nodeNet) Notify(ntf kiwi.ISndNotice) {
@@ -216,6 +324,104 @@ func (n *nodeNet) SendToNode(nodeId int64, bytes []byte, fnErr util.FnErr) {
 	n.worker.Push(nodeSendNode, nodeId, bytes, fnErr)
 }
 
+func (n *nodeNet) Drain(nodeId int64) {
+	n.worker.Push(nodeDrain, nodeId)
+}
+
+// Info 已连接的服务节点和本机方法的远程监听者
+func (n *nodeNet) Info(fn util.FnM) {
+	n.worker.Push(nodeInfo, fn)
+}
+
+// selectNode 按负载均衡选择服务的节点
+func (n *nodeNet) selectNode(svc kiwi.TSvc, head util.M) (int64, *util.Err) {
+	type result struct {
+		nodeId int64
+		err    *util.Err
+	}
+	ch := make(chan result, 1)
+	n.worker.Push(nodeSelect, svc, head, func(nodeId int64, err *util.Err) {
+		ch <- result{nodeId, err}
+	})
+	res := <-ch
+	return res.nodeId, res.err
+}
+
+// resend 重试和对冲,优先选择没有尝试过的节点
+func (n *nodeNet) resend(req *SRequest, tid int64, except []int64) {
+	n.worker.Push(nodeResend, req, tid, except)
+}
+
+// resendRequest 请求可能已经结束并被复用,tid不同时不再发送
+func (n *nodeNet) resendRequest(req *SRequest, tid int64, except []int64) {
+	if req.isDisposed() || req.Tid() != tid {
+		return
+	}
+	head := req.Head()
+	bytes, err := kiwi.Packer().PackRequest(tid, req)
+	if err != nil {
+		kiwi.TE(tid, err)
+		return
+	}
+	dialer, err := n.selectDialerExcept(req.Svc(), head, except)
+	if err != nil {
+		kiwi.TE(tid, err)
+		kiwi.Router().OnResponseFail(tid, head, err.Code())
+		return
+	}
+	req.SetNodeId(dialer.NodeId())
+	acquireNode(dialer.NodeId())
+	nodeId := dialer.NodeId()
+	dialer.Send(bytes, func(err *util.Err) {
+		if err == nil {
+			return
+		}
+		kiwi.TE(tid, err)
+		kiwi.Router().OnResponseFail(tid, sendErrHead(head, nodeId), util.EcSendErr)
+	})
+}
+
+// sendErrHead 发送失败时复制包头并标记失败的节点,重试按它报告熔断
+func sendErrHead(head util.M, nodeId int64) util.M {
+	h := head.Copy(nil)
+	h[HeadResId] = nodeId
+	return h
+}
+
+// dispatch 在worker中分发事件
+func (n *nodeNet) dispatch(name string, data any) {
+	n.worker.Push(nodeDispatch, name, data)
+}
+
+// broadcast 发送给所有已连接的节点
+func (n *nodeNet) broadcast(bytes []byte) {
+	n.worker.Push(nodeBroadcast, bytes)
+}
+
+func (n *nodeNet) Dispose() {
+	n.listener.Close()
+}
+
+// flush 等待worker中已有的发送任务和连接的写队列完成
+func (n *nodeNet) flush(deadline time.Time) bool {
+	ch := make(chan []kiwi.IAgent, 1)
+	n.worker.Push(nodeFlush, ch)
+	var agents []kiwi.IAgent
+	select {
+	case agents = <-ch:
+	case <-time.After(time.Until(deadline)):
+		return false
+	}
+	return waitUntil(deadline, func() bool {
+		for _, agent := range agents {
+			if agent.Pending() > 0 {
+				return false
+			}
+		}
+		return true
+	})
+}
+
 func (n *nodeNet) onAddTcpConn(conn net.Conn) {
 	addr := conn.RemoteAddr().String()
 	agent := network.NewTcpAgent(addr, n.receive,
@@ -225,6 +431,8 @@ func (n *nodeNet) onAddTcpConn(conn net.Conn) {
 		}),
 		kiwi.AgentMode(kiwi.AgentR),
 		kiwi.AgentDeadline(30),
+		kiwi.AgentCompress(n.option.compressor, n.option.compressMin, kiwi.CompressOff),
+		n.cryptoOption(),
 	)
 	agent.Start(util.Ctx(), conn)
 }
@@ -238,16 +446,39 @@ func (n *nodeNet) onAddUdpConn(conn net.Conn) {
 		}),
 		kiwi.AgentMode(kiwi.AgentR),
 		kiwi.AgentDeadline(30),
+		kiwi.AgentCompress(n.option.compressor, n.option.compressMin, kiwi.CompressOff),
+		n.cryptoOption(),
 	)
 	agent.Start(util.Ctx(), conn)
 }
 
-func (n *nodeNet) createDialer(name, addr string) kiwi.IDialer {
+func (n *nodeNet) cryptoOption() kiwi.AgentOption {
+	return func(o *kiwi.AgentOpt) {
+		o.Crypto = n.option.crypto
+		o.CryptoPsk = n.option.cryptoPsk
+	}
+}
+
+func (n *nodeNet) createDialer(name, addr string, head util.M) kiwi.IDialer {
+	opts := []kiwi.AgentOption{kiwi.AgentMode(kiwi.AgentW), n.cryptoOption()}
+	if c, ok := n.peerCompressor(head); ok {
+		min := n.option.compressMin
+		if min == 0 {
+			min = network.DefCompressMin
+		}
+		opts = append(opts, kiwi.AgentCompress(c, min, kiwi.CompressOn))
+	}
 	switch n.option.connType {
 	case Tcp:
-		return network.NewTcpDialer(name, addr, n.receive, kiwi.AgentMode(kiwi.AgentW))
+		if n.option.tlsClient != nil {
+			return network.NewTcpTlsDialer(name, addr, n.option.tlsClient, n.receive, opts...)
+		}
+		return network.NewTcpDialer(name, addr, n.receive, opts...)
 	case Udp:
-		return network.NewUdpDialer(name, addr, n.receive, kiwi.AgentMode(kiwi.AgentW))
+		if n.option.udpBlock != nil {
+			return network.NewUdpCryptDialer(name, addr, n.option.udpBlock, n.receive, opts...)
+		}
+		return network.NewUdpDialer(name, addr, n.receive, opts...)
 	default:
 		kiwi.Fatal2(util.EcParamsErr, util.M{
 			"conn type": n.option.connType,
@@ -256,6 +487,26 @@ func (n *nodeNet) createDialer(name, addr string) kiwi.IDialer {
 	}
 }
 
+const nodeHeadCompress = "compress"
+
+// peerCompressor 远程节点开启了压缩
+func (n *nodeNet) peerCompressor(head util.M) (kiwi.ICompressor, bool) {
+	name, ok := util.MGet[string](head, nodeHeadCompress)
+	if !ok {
+		return nil, false
+	}
+	if n.option.compressor != nil && n.option.compressor.Name() == name {
+		return n.option.compressor, true
+	}
+	c, ok := network.GetCompressor(name)
+	if !ok {
+		kiwi.Warn2(util.EcNotExist, util.M{
+			"compressor": name,
+		})
+	}
+	return c, ok
+}
+
 func (n *nodeNet) processor(job *worker.Job) {
 	switch job.Name {
 	case nodeConnect:
@@ -266,6 +517,9 @@ func (n *nodeNet) processor(job *worker.Job) {
 			})
 			return
 		}
+		if !checkSchema(svc, nodeId, ver, head) {
+			return
+		}
 		kiwi.Info("connect service", util.M{
 			"ip":      ip,
 			"port":    port,
@@ -274,7 +528,7 @@ func (n *nodeNet) processor(job *worker.Job) {
 			"ver":     ver,
 			"head":    head,
 		})
-		dialer := n.createDialer(fmt.Sprintf("%s_%d", svc, nodeId), fmt.Sprintf("%s:%d", ip, port))
+		dialer := n.createDialer(fmt.Sprintf("%d_%d", svc, nodeId), fmt.Sprintf("%s:%d", ip, port), head)
 		newNodeDialer(dialer, svc, nodeId, ver, head, n.onConnected, n.onDisconnected).connect()
 	case nodeConnected:
 		dialer := util.SplitSlc1[*nodeDialer](job.Data)
@@ -290,6 +544,7 @@ func (n *nodeNet) processor(job *worker.Job) {
 			})
 		}
 		_ = n.idToDialer.Set(dialer)
+		addNodeBreaker(dialer.svc, dialer.nodeId)
 		kiwi.Info("service connected", util.M{
 			"svc":     dialer.svc,
 			"ver":     dialer.ver,
@@ -302,7 +557,7 @@ func (n *nodeNet) processor(job *worker.Job) {
 			bytes := kiwi.Packer().PackWatchNotify(kiwi.GetNodeMeta().NodeId, codes)
 			dialer.Send(bytes, kiwi.Error)
 		}
-		var head util.M
+		head := util.M{}
 		dialer.head.CopyTo(head)
 		kiwi.DispatchEvent(kiwi.Evt_Svc_Connected, &kiwi.EvtSvcConnected{
 			Svc:  dialer.svc,
@@ -321,6 +576,8 @@ func (n *nodeNet) processor(job *worker.Job) {
 			return
 		}
 		_, _ = n.idToDialer.Del(nodeId)
+		delete(n.drainNodes, nodeId)
+		delNodeBreaker(nodeId)
 		codes, ok := n.watcherToCodes[nodeId]
 		if ok {
 			for _, code := range codes {
@@ -404,7 +661,10 @@ func (n *nodeNet) processor(job *worker.Job) {
 			kiwi.TE(tid, err)
 			return
 		}
-		n.sendToSvc(pus.Svc(), bytes, func(err *util.Err) {
+		n.sendToSvc(pus.Svc(), pus.Head(), bytes, func(err *util.Err) {
+			if err != nil {
+				addDeadLetter(HdPush, tid, pus.Head(), bytes, err)
+			}
 			kiwi.TE(tid, err)
 		})
 	case nodePushNode:
@@ -416,66 +676,228 @@ func (n *nodeNet) processor(job *worker.Job) {
 			return
 		}
 		n.sendToNode(nodeId, bytes, func(err *util.Err) {
+			if err != nil {
+				addDeadLetter(HdPush, tid, pus.Head(), bytes, err)
+			}
 			kiwi.TE(tid, err)
 		})
 	case nodeRequest:
 		req := job.Data[0].(kiwi.ISndRequest)
-		tid := req.Tid()
+		tid, head := req.Tid(), req.Head()
 		bytes, err := kiwi.Packer().PackRequest(tid, req)
 		if err != nil {
 			kiwi.TE(tid, err)
 			return
 		}
-		n.sendToSvc(req.Svc(), bytes, func(err *util.Err) {
+		dialer, err := n.selectDialer(req.Svc(), head)
+		if err != nil {
 			kiwi.TE(tid, err)
+			kiwi.Router().OnResponseFail(tid, head, err.Code())
+			return
+		}
+		req.SetNodeId(dialer.NodeId())
+		acquireNode(dialer.NodeId())
+		nodeId := dialer.NodeId()
+		dialer.Send(bytes, func(err *util.Err) {
+			if err == nil {
+				return
+			}
+			kiwi.TE(tid, err)
+			kiwi.Router().OnResponseFail(tid, sendErrHead(head, nodeId), util.EcSendErr)
 		})
 	case nodeRequestNode:
 		nodeId, req := util.SplitSlc2[int64, kiwi.ISndRequest](job.Data)
-		tid := req.Tid()
+		tid, head := req.Tid(), req.Head()
 		bytes, err := kiwi.Packer().PackRequest(tid, req)
 		if err != nil {
 			kiwi.TE(tid, err)
 			return
 		}
+		req.SetNodeId(nodeId)
+		acquireNode(nodeId)
 		n.sendToNode(nodeId, bytes, func(err *util.Err) {
+			if err == nil {
+				return
+			}
 			kiwi.TE(tid, err)
+			kiwi.Router().OnResponseFail(tid, sendErrHead(head, nodeId), err.Code())
 		})
 	case nodeSendNode:
 		n.sendToNode(util.SplitSlc3[int64, []byte, util.FnErr](job.Data))
+	case nodeResend:
+		req, tid, except := util.SplitSlc3[*SRequest, int64, []int64](job.Data)
+		n.resendRequest(req, tid, except)
+	case nodeSelect:
+		svc, head, fn := util.SplitSlc3[kiwi.TSvc, util.M, func(int64, *util.Err)](job.Data)
+		dialer, err := n.selectDialer(svc, head)
+		if err != nil {
+			fn(0, err)
+			return
+		}
+		fn(dialer.NodeId(), nil)
+	case nodeDrain:
+		nodeId := util.SplitSlc1[int64](job.Data)
+		if _, ok := n.drainNodes[nodeId]; ok {
+			return
+		}
+		n.drainNodes[nodeId] = struct{}{}
+		kiwi.Info("node draining", util.M{
+			"node id": nodeId,
+		})
+	case nodeFlush:
+		ch := util.SplitSlc1[chan []kiwi.IAgent](job.Data)
+		agents := make([]kiwi.IAgent, 0, n.idToDialer.Count())
+		n.idToDialer.Iter(func(dialer kiwi.INodeDialer) {
+			agents = append(agents, dialer.Dialer().Agent())
+		})
+		ch <- agents
+	case nodeBroadcast:
+		bytes := util.SplitSlc1[[]byte](job.Data)
+		n.idToDialer.Iter(func(dialer kiwi.INodeDialer) {
+			nodeId := dialer.NodeId()
+			dialer.Send(util.CopyBytes(bytes), func(err *util.Err) {
+				if err == nil {
+					return
+				}
+				err.AddParam("node id", nodeId)
+				kiwi.Error(err)
+			})
+		})
+	case nodeDispatch:
+		kiwi.DispatchEvent(util.SplitSlc2[string, any](job.Data))
+	case nodeInfo:
+		fn := util.SplitSlc1[util.FnM](job.Data)
+		dialers := make(util.M, n.svcToDialer.Count())
+		n.svcToDialer.Iter(func(set *NodeDialerSet) {
+			slc := make([]util.M, 0, set.Count())
+			set.Iter(func(dialer kiwi.INodeDialer) {
+				id := dialer.NodeId()
+				_, draining := n.drainNodes[id]
+				slc = append(slc, util.M{
+					"node id":  id,
+					"addr":     dialer.Dialer().Agent().Addr(),
+					"head":     dialer.Head(),
+					"draining": draining,
+					"breaker":  NodeBreakerState(id),
+					"pending":  NodePending(id),
+				})
+			})
+			dialers[strconv.Itoa(int(set.Key()))] = slc
+		})
+		watchers := make(util.M, len(n.codeToWatchers))
+		for code, m := range n.codeToWatchers {
+			ids := make([]int64, 0, len(m))
+			for id := range m {
+				ids = append(ids, id)
+			}
+			watchers[strconv.Itoa(int(code))] = ids
+		}
+		fn(util.M{
+			"dialers":  dialers,
+			"watchers": watchers,
+		})
+	}
+}
+
+func (n *nodeNet) getBalancer(svc kiwi.TSvc) INodeBalancer {
+	balancer, ok := n.option.balancers[svc]
+	if !ok {
+		balancer = n.option.newBalancer()
+		n.option.balancers[svc] = balancer
 	}
+	return balancer
 }
 
-func (n *nodeNet) sendToSvc(svc kiwi.TSvc, bytes []byte, fnErr util.FnErr) {
+func (n *nodeNet) onSvcConnected(_ util.M, data any) {
+	evt := data.(*kiwi.EvtSvcConnected)
+	n.getBalancer(evt.Svc).Add(evt.Id, evt.Head)
+}
+
+func (n *nodeNet) onSvcDisconnected(_ util.M, data any) {
+	evt := data.(*kiwi.EvtSvcDisconnected)
+	n.getBalancer(evt.Svc).Del(evt.Id)
+}
+
+func (n *nodeNet) selectDialer(svc kiwi.TSvc, head util.M) (kiwi.INodeDialer, *util.Err) {
+	return n.selectDialerExcept(svc, head, nil)
+}
+
+// selectDialerExcept 优先排除except中的节点,都被排除时从所有可用节点中选择
+func (n *nodeNet) selectDialerExcept(svc kiwi.TSvc, head util.M, except []int64) (kiwi.INodeDialer, *util.Err) {
 	set, ok := n.svcToDialer.Get(svc)
 	if !ok {
-		fnErr(util.NewErr(util.EcNotExist, util.M{
+		return nil, util.NewErr(util.EcNotExist, util.M{
 			"svc": svc,
-		}))
-		return
+		})
 	}
+	ready := n.readySet(set, except)
+	if ready.Count() == 0 && len(except) > 0 {
+		ready = n.readySet(set, nil)
+	}
+	set = versionSet(svc, ready)
 	switch set.Count() {
 	case 0:
-		fnErr(util.NewErr(util.EcNotExist, util.M{
+		return nil, util.NewErr(util.EcUnavailable, util.M{
 			"svc": svc,
-		}))
+		})
 	case 1:
 		dialer, _ := set.GetWithIdx(0)
-		dialer.Send(bytes, fnErr)
+		return dialer, nil
 	default:
-		nodeId, err := n.option.selector(set)
+		nodeId, err := n.getBalancer(svc).Select(head, set)
 		if err != nil {
-			fnErr(err)
-			return
+			return nil, err
 		}
 		dialer, ok := n.idToDialer.Get(nodeId)
 		if !ok {
-			fnErr(util.NewErr(util.EcNotExist, util.M{
+			return nil, util.NewErr(util.EcNotExist, util.M{
 				"id": nodeId,
-			}))
-			return
+			})
 		}
-		dialer.Send(bytes, fnErr)
+		return dialer, nil
+	}
+}
+
+func (n *nodeNet) isReady(nodeId int64) bool {
+	if _, ok := n.drainNodes[nodeId]; ok {
+		return false
 	}
+	return nodeReady(nodeId)
+}
+
+// readySet 过滤熔断、排空中和except中的节点,都可用时直接返回原集合
+func (n *nodeNet) readySet(set *NodeDialerSet, except []int64) *NodeDialerSet {
+	isReady := func(nodeId int64) bool {
+		for _, id := range except {
+			if id == nodeId {
+				return false
+			}
+		}
+		return n.isReady(nodeId)
+	}
+	if !set.Any(func(dialer kiwi.INodeDialer) bool {
+		return !isReady(dialer.NodeId())
+	}) {
+		return set
+	}
+	ready := ds.NewSet2Item[kiwi.TSvc, int64, kiwi.INodeDialer](set.Key(), set.Count(), func(dialer kiwi.INodeDialer) int64 {
+		return dialer.NodeId()
+	})
+	set.Iter(func(dialer kiwi.INodeDialer) {
+		if isReady(dialer.NodeId()) {
+			ready.Set(dialer)
+		}
+	})
+	return ready
+}
+
+func (n *nodeNet) sendToSvc(svc kiwi.TSvc, head util.M, bytes []byte, fnErr util.FnErr) {
+	dialer, err := n.selectDialer(svc, head)
+	if err != nil {
+		fnErr(err)
+		return
+	}
+	dialer.Send(bytes, fnErr)
 }
 
 func (n *nodeNet) sendToNode(nodeId int64, bytes []byte, fnErr util.FnErr) {
@@ -501,4 +923,11 @@ const (
 	nodeRequest      = "request"
 	nodeRequestNode  = "request_node"
 	nodeSendNode     = "send_node"
+	nodeSelect       = "select"
+	nodeResend       = "resend"
+	nodeDrain        = "drain"
+	nodeFlush        = "flush"
+	nodeInfo         = "info"
+	nodeBroadcast    = "broadcast"
+	nodeDispatch     = "dispatch"
 )
@@ -0,0 +1,233 @@
+package core
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/ds"
+	"github.com/15mga/kiwi/util"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+type SchemaMode uint8
+
+const (
+	SchemaIgnore SchemaMode = iota
+	// SchemaWarn 消息结构不兼容或主版本不同时记录日志,仍然连接
+	SchemaWarn
+	// SchemaRefuse 消息结构不兼容或主版本不同时不连接
+	SchemaRefuse
+)
+
+const nodeHeadSchema = "schema"
+
+var (
+	// SchemaCheck 连接服务节点时检查服务主版本和双方都注册的消息结构
+	SchemaCheck = SchemaWarn
+	// _SvcToVer 调用方期望的服务版本,只在启动时写入
+	_SvcToVer = make(map[kiwi.TSvc]string)
+)
+
+// BindSvcVer 本节点调用的服务版本,选择节点时优先主版本相同的节点,
+// 本节点提供的服务使用NodeMeta中的版本
+func BindSvcVer(svc kiwi.TSvc, ver string) {
+	_SvcToVer[svc] = ver
+}
+
+func expectSvcVer(svc kiwi.TSvc) (string, bool) {
+	ver, ok := kiwi.GetNodeMeta().Services[svc]
+	if ok && ver != "" {
+		return ver, true
+	}
+	ver, ok = _SvcToVer[svc]
+	return ver, ok && ver != ""
+}
+
+// verMajor v1.2.3和1.2都取1
+func verMajor(ver string) string {
+	ver = strings.TrimPrefix(strings.TrimPrefix(ver, "v"), "V")
+	if i := strings.IndexByte(ver, '.'); i > -1 {
+		return ver[:i]
+	}
+	return ver
+}
+
+// SchemaFields 消息的字段编号和类型,引用消息的字段编号用.连接,不包括名称,改名不影响兼容
+func SchemaFields(desc protoreflect.MessageDescriptor) map[string]string {
+	m := make(map[string]string)
+	writeSchema(m, "", desc, make(map[protoreflect.FullName]struct{}))
+	return m
+}
+
+func writeSchema(m map[string]string, prefix string, desc protoreflect.MessageDescriptor, visited map[protoreflect.FullName]struct{}) {
+	if _, ok := visited[desc.FullName()]; ok {
+		//递归引用不再展开
+		return
+	}
+	visited[desc.FullName()] = struct{}{}
+	defer delete(visited, desc.FullName())
+	fields := desc.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		key := prefix + strconv.Itoa(int(field.Number()))
+		m[key] = field.Cardinality().String() + " " + field.Kind().String()
+		if msg := field.Message(); msg != nil {
+			writeSchema(m, key+".", msg, visited)
+		}
+	}
+}
+
+// localSchema 本节点svc的所有消息结构,key为code
+func localSchema(svc kiwi.TSvc) util.M {
+	c, ok := kiwi.Codec().(*codec)
+	if !ok {
+		return nil
+	}
+	m := util.M{}
+	for sc, fac := range c.fac {
+		s, code := kiwi.SplitSvcCode(sc)
+		if s != svc {
+			continue
+		}
+		m[strconv.Itoa(int(code))] = SchemaFields(fac().ProtoReflect().Descriptor())
+	}
+	return m
+}
+
+// onNodeRegister 注册前发布本节点服务的消息结构,服务都已经绑定消息
+func onNodeRegister(_ util.M, data any) {
+	meta := data.(*kiwi.NodeMeta)
+	schema := util.M{}
+	for svc := range meta.Services {
+		m := localSchema(svc)
+		if len(m) > 0 {
+			schema[strconv.Itoa(int(svc))] = m
+		}
+	}
+	meta.Data.Set(nodeHeadSchema, schema)
+}
+
+func toM(v any) (util.M, bool) {
+	switch m := v.(type) {
+	case util.M:
+		return m, true
+	case map[string]any:
+		return m, true
+	case map[string]string:
+		r := make(util.M, len(m))
+		for k, v := range m {
+			r[k] = v
+		}
+		return r, true
+	default:
+		return nil, false
+	}
+}
+
+// compatSchema 相同编号的字段类型相同时兼容,只有一方有的字段是新增的,不影响兼容
+func compatSchema(local map[string]string, remote util.M) bool {
+	for num, kind := range local {
+		v, ok := remote[num]
+		if !ok {
+			continue
+		}
+		if k, _ := v.(string); k != kind {
+			return false
+		}
+	}
+	return true
+}
+
+// diffSchema 双方都注册且不兼容的code
+func diffSchema(svc kiwi.TSvc, head util.M) []kiwi.TCode {
+	all, ok := toM(head[nodeHeadSchema])
+	if !ok {
+		return nil
+	}
+	remote, ok := toM(all[strconv.Itoa(int(svc))])
+	if !ok {
+		return nil
+	}
+	var codes []kiwi.TCode
+	for code, v := range localSchema(svc) {
+		fields, ok := toM(remote[code])
+		if !ok {
+			continue
+		}
+		if !compatSchema(v.(map[string]string), fields) {
+			c, _ := strconv.Atoi(code)
+			codes = append(codes, kiwi.TCode(c))
+		}
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		return codes[i] < codes[j]
+	})
+	return codes
+}
+
+// checkSchema 在连接前检查,返回false时不连接
+func checkSchema(svc kiwi.TSvc, nodeId int64, ver string, head util.M) bool {
+	if SchemaCheck == SchemaIgnore {
+		return true
+	}
+	if expect, ok := expectSvcVer(svc); ok && verMajor(expect) != verMajor(ver) {
+		m := util.M{
+			"error":   "service major version mismatch",
+			"svc":     svc,
+			"node id": nodeId,
+			"ver":     ver,
+			"expect":  expect,
+		}
+		if SchemaCheck == SchemaRefuse {
+			kiwi.Error2(util.EcIllegalOp, m)
+			return false
+		}
+		kiwi.Warn2(util.EcIllegalOp, m)
+	}
+	codes := diffSchema(svc, head)
+	if len(codes) == 0 {
+		return true
+	}
+	m := util.M{
+		"error":   "message schema mismatch",
+		"svc":     svc,
+		"node id": nodeId,
+		"ver":     ver,
+		"codes":   codes,
+	}
+	if SchemaCheck == SchemaRefuse {
+		kiwi.Error2(util.EcIllegalOp, m)
+		return false
+	}
+	kiwi.Warn2(util.EcIllegalOp, m)
+	return true
+}
+
+// versionSet 有主版本相同的节点时只从这些节点中选择
+func versionSet(svc kiwi.TSvc, set *NodeDialerSet) *NodeDialerSet {
+	expect, ok := expectSvcVer(svc)
+	if !ok || set.Count() < 2 {
+		return set
+	}
+	major := verMajor(expect)
+	match := func(dialer kiwi.INodeDialer) bool {
+		d, ok := dialer.(*nodeDialer)
+		return !ok || verMajor(d.ver) == major
+	}
+	if !set.Any(func(dialer kiwi.INodeDialer) bool {
+		return !match(dialer)
+	}) || !set.Any(match) {
+		return set
+	}
+	matched := ds.NewSet2Item[kiwi.TSvc, int64, kiwi.INodeDialer](set.Key(), set.Count(), func(dialer kiwi.INodeDialer) int64 {
+		return dialer.NodeId()
+	})
+	set.Iter(func(dialer kiwi.INodeDialer) {
+		if match(dialer) {
+			matched.Set(dialer)
+		}
+	})
+	return matched
+}
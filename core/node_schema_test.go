@@ -0,0 +1,108 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/15mga/kiwi/util"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func testField(name string, num int32, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+	return &descriptorpb.FieldDescriptorProto{
+		Name:   proto.String(name),
+		Number: proto.Int32(num),
+		Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type:   typ.Enum(),
+	}
+}
+
+func testSchemaMsg(t *testing.T, fields ...*descriptorpb.FieldDescriptorProto) protoreflect.MessageDescriptor {
+	fd, e := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("schema.proto"),
+		Package: proto.String("schema"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name:  proto.String("Msg"),
+			Field: fields,
+		}},
+	}, nil)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return fd.Messages().Get(0)
+}
+
+// testRemoteSchema 与发现中的NodeMeta相同经过json
+func testRemoteSchema(t *testing.T, desc protoreflect.MessageDescriptor) util.M {
+	bytes, err := util.JsonMarshal(SchemaFields(desc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := util.M{}
+	err = util.JsonUnmarshal(bytes, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestSchemaFields(t *testing.T) {
+	a := SchemaFields(testSchemaMsg(t, testField("a", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64)))
+	//改名兼容
+	if !compatSchema(a, testRemoteSchema(t, testSchemaMsg(t, testField("b", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64)))) {
+		t.Fatal("rename")
+	}
+	//新增字段兼容,双方都可能是新增的一方
+	added := testSchemaMsg(t,
+		testField("a", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64),
+		testField("c", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING))
+	if !compatSchema(a, testRemoteSchema(t, added)) || !compatSchema(SchemaFields(added), testRemoteSchema(t, testSchemaMsg(t))) {
+		t.Fatal("added")
+	}
+	//相同编号改类型不兼容
+	if compatSchema(a, testRemoteSchema(t, testSchemaMsg(t, testField("a", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING)))) {
+		t.Fatal("kind changed")
+	}
+	//引用的消息展开,递归引用不展开
+	m := SchemaFields((&descriptorpb.DescriptorProto{}).ProtoReflect().Descriptor())
+	if _, ok := m["3.1"]; ok || m["3"] != "repeated message" || m["2.1"] != "optional string" {
+		t.Fatal("nested", m)
+	}
+}
+
+func TestCheckSchemaVer(t *testing.T) {
+	const svc = 201
+	mode := SchemaCheck
+	defer func() {
+		SchemaCheck = mode
+		delete(_SvcToVer, svc)
+	}()
+	BindSvcVer(svc, "v1.2")
+	SchemaCheck = SchemaWarn
+	if !checkSchema(svc, 1, "v2.0", nil) {
+		t.Fatal("warn")
+	}
+	SchemaCheck = SchemaRefuse
+	if checkSchema(svc, 1, "v2.0", nil) {
+		t.Fatal("refuse")
+	}
+	if !checkSchema(svc, 1, "v1.3", nil) {
+		t.Fatal("same major")
+	}
+}
+
+func TestVerMajor(t *testing.T) {
+	for ver, major := range map[string]string{
+		"v1.2.3": "1",
+		"2.0":    "2",
+		"3":      "3",
+		"":       "",
+	} {
+		if verMajor(ver) != major {
+			t.Fatal(ver, verMajor(ver))
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package core
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	_NodeToPending sync.Map
+)
+
+// NodePending 发往节点且未结束的请求数量
+func NodePending(nodeId int64) int64 {
+	v, ok := _NodeToPending.Load(nodeId)
+	if !ok {
+		return 0
+	}
+	return atomic.LoadInt64(v.(*int64))
+}
+
+func addNodePending(nodeId, delta int64) {
+	v, ok := _NodeToPending.Load(nodeId)
+	if !ok {
+		v, _ = _NodeToPending.LoadOrStore(nodeId, new(int64))
+	}
+	atomic.AddInt64(v.(*int64), delta)
+}
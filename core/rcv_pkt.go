@@ -4,6 +4,7 @@ import (
 	"github.com/15mga/kiwi"
 	"github.com/15mga/kiwi/util"
 	"sync/atomic"
+	"time"
 )
 
 func NewRcvPusPkt() *RcvPusPkt {
@@ -31,6 +32,8 @@ type rcvPkt struct {
 	workerType kiwi.EWorker
 	workerKey  string
 	completed  int32
+	retry      bool
+	canceled   int32
 }
 
 func (p *rcvPkt) Worker() kiwi.EWorker {
@@ -46,6 +49,15 @@ func (p *rcvPkt) SetWorker(typ kiwi.EWorker, key string) {
 	p.workerKey = key
 }
 
+func (p *rcvPkt) Deadline() (int64, bool) {
+	return util.MGet[int64](p.head, HeadDeadline)
+}
+
+func (p *rcvPkt) Expired() bool {
+	deadline, ok := p.Deadline()
+	return ok && time.Now().UnixMilli() > deadline
+}
+
 func (p *rcvPkt) InitWithBytes(msgType uint8, tid int64, head util.M, json bool, payload []byte) *util.Err {
 	var (
 		msg util.IMsg
@@ -68,6 +80,9 @@ func (p *rcvPkt) InitWithBytes(msgType uint8, tid int64, head util.M, json bool,
 	p.msg = msg
 	p.headId, _ = util.MGet[string](p.head, HeadId)
 	p.senderId, _ = util.MGet[int64](p.head, HeadSndId)
+	kiwi.BindTraceCtx(tid, head)
+	logTid(p.svc, p.code, tid)
+	metricPkt(msgType, p.svc, p.code)
 	atomic.AddUint64(&_ReceivePktCount, 1)
 	return nil
 }
@@ -82,6 +97,9 @@ func (p *rcvPkt) InitWithMsg(msgType uint8, tid int64, head util.M, json bool, m
 	p.svc, _ = util.MGet[kiwi.TSvc](p.head, HeadSvc)
 	p.code, _ = util.MGet[kiwi.TCode](p.head, HeadCode)
 	p.senderId, _ = util.MGet[int64](p.head, HeadSndId)
+	kiwi.BindTraceCtx(tid, head)
+	logTid(p.svc, p.code, tid)
+	metricPkt(msgType, p.svc, p.code)
 	atomic.AddUint64(&_ReceivePktCount, 1)
 }
 
@@ -119,6 +137,11 @@ func (p *rcvPkt) Msg() util.IMsg {
 
 func (p *rcvPkt) Complete() {
 	if atomic.CompareAndSwapInt32(&p.completed, 0, 1) {
+		kiwi.UnbindTraceCtx(p.tid)
+		if p.retry {
+			_RetryRequests.CompareAndDelete(p.tid, p)
+		}
+		metricPktComplete(p.svc, p.code, p.head)
 		atomic.AddUint64(&_CompletePktCount, 1)
 	}
 }
@@ -126,17 +149,20 @@ func (p *rcvPkt) Complete() {
 func (p *rcvPkt) Err(err *util.Err) {
 	if err != nil {
 		kiwi.TE(p.tid, err)
+		metricPktErr(p.svc, p.code)
 	}
 	p.Complete()
 }
 
 func (p *rcvPkt) Err2(code util.TErrCode, m util.M) {
 	kiwi.TE2(p.tid, code, m)
+	metricPktErr(p.svc, p.code)
 	p.Complete()
 }
 
 func (p *rcvPkt) Err3(code util.TErrCode, e error) {
 	kiwi.TE3(p.tid, code, e)
+	metricPktErr(p.svc, p.code)
 	p.Complete()
 }
 
@@ -3,16 +3,46 @@ package core
 import (
 	"github.com/15mga/kiwi"
 	"github.com/15mga/kiwi/util"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
+var (
+	_RetryRequests sync.Map //tid:*rcvPkt,请求方有重试策略的请求
+)
+
+// watchCancel 请求方有重试策略时记录请求,Complete时删除
+func watchCancel(pkt *RcvReqPkt) {
+	if retry, _ := util.MGet[bool](pkt.head, HeadRetry); !retry {
+		return
+	}
+	pkt.retry = true
+	_RetryRequests.Store(pkt.tid, &pkt.rcvPkt)
+}
+
+// cancelRequest 其他节点已经响应,还没开始处理的请求在isExpired中丢弃,已经开始的处理照常执行
+func cancelRequest(bytes []byte) *util.Err {
+	var buffer util.ByteBuffer
+	buffer.InitBytes(bytes)
+	buffer.SetPos(1)
+	tid, err := buffer.RInt64()
+	if err != nil {
+		return err
+	}
+	v, ok := _RetryRequests.Load(tid)
+	if ok {
+		atomic.StoreInt32(&v.(*rcvPkt).canceled, 1)
+	}
+	return nil
+}
+
 type RcvReqPkt struct {
 	rcvPkt
 }
 
 func (p *RcvReqPkt) Ok(msg util.IMsg) {
-	if !IsExcludeLog(p.svc, p.code) {
+	if logTid(p.svc, p.code, p.tid) {
 		sndTs, _ := util.MGet[int64](p.head, HeadSndTs)
 		kiwi.TI(p.tid, "ok", util.M{
 			"dur":  time.Now().UnixMilli() - sndTs,
@@ -39,7 +69,7 @@ func (p *RcvReqPkt) Ok(msg util.IMsg) {
 		kiwi.Error(err)
 		return
 	}
-	res, err := kiwi.Packer().PackResponseOk(p.tid, p.head, payload)
+	res, err := kiwi.Packer().PackResponseOk(p.tid, p.resHead(), payload)
 	if err != nil {
 		kiwi.Error(err)
 		return
@@ -61,7 +91,7 @@ func (p *RcvReqPkt) Err(err *util.Err) {
 		kiwi.Router().OnResponseFail(p.tid, p.head, err.Code())
 		return
 	}
-	payload, e := kiwi.Packer().PackResponseFail(p.tid, p.head, err.Code())
+	payload, e := kiwi.Packer().PackResponseFail(p.tid, p.resHead(), err.Code())
 	if e != nil {
 		kiwi.Error(e)
 		return
@@ -70,7 +100,7 @@ func (p *RcvReqPkt) Err(err *util.Err) {
 }
 
 func (p *RcvReqPkt) Fail(code uint16) {
-	if !IsExcludeLog(p.svc, p.code) {
+	if logTid(p.svc, p.code, p.tid) {
 		sndTs, _ := util.MGet[int64](p.head, HeadSndTs)
 		kiwi.TI(p.tid, "fail", util.M{
 			"dur":   time.Now().UnixMilli() - sndTs,
@@ -79,12 +109,13 @@ func (p *RcvReqPkt) Fail(code uint16) {
 			"error": util.ErrCodeToStr(code),
 		})
 	}
+	metricPktErr(p.svc, p.code)
 	p.Complete()
 	if p.senderId == kiwi.GetNodeMeta().NodeId {
 		kiwi.Router().OnResponseFail(p.tid, p.head, code)
 		return
 	}
-	payload, e := kiwi.Packer().PackResponseFail(p.tid, p.head, code)
+	payload, e := kiwi.Packer().PackResponseFail(p.tid, p.resHead(), code)
 	if e != nil {
 		kiwi.Error(e)
 		return
@@ -92,6 +123,16 @@ func (p *RcvReqPkt) Fail(code uint16) {
 	kiwi.Node().SendToNode(p.senderId, payload, p.onSendErr)
 }
 
+// resHead 响应带上本节点id,请求方按它报告熔断
+func (p *RcvReqPkt) resHead() util.M {
+	p.head.Set(HeadResId, kiwi.GetNodeMeta().NodeId)
+	return p.head
+}
+
+func (p *RcvReqPkt) isCanceled() bool {
+	return atomic.LoadInt32(&p.canceled) == 1
+}
+
 func (p *RcvReqPkt) onSendErr(err *util.Err) {
 	if err == nil {
 		return
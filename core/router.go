@@ -12,6 +12,7 @@ func InitRouter() {
 	s := &router{
 		pusHandle: make(map[kiwi.TSvcCode]kiwi.FnRcvPus),
 		reqHandle: make(map[kiwi.TSvcCode]kiwi.FnRcvReq),
+		strHandle: make(map[kiwi.TSvcCode]kiwi.FnRcvStream),
 		idToRequest: cmap.NewWithCustomShardingFunction[int64, kiwi.ISndRequest](func(key int64) uint32 {
 			return uint32(key)
 		}),
@@ -25,6 +26,7 @@ type router struct {
 	leaseId       int64
 	pusHandle     map[kiwi.TSvcCode]kiwi.FnRcvPus
 	reqHandle     map[kiwi.TSvcCode]kiwi.FnRcvReq
+	strHandle     map[kiwi.TSvcCode]kiwi.FnRcvStream
 	idToRequest   cmap.ConcurrentMap[int64, kiwi.ISndRequest]
 	watchCodes    map[kiwi.TSvc][]kiwi.TCode
 	notifyHandler map[kiwi.TSvcCode][]kiwi.NotifyHandler
@@ -33,10 +35,12 @@ type router struct {
 func (s *router) OnPush(pkt kiwi.IRcvPush) {
 	fn, ok := s.pusHandle[kiwi.MergeSvcCode(pkt.Svc(), pkt.Code())]
 	if !ok {
-		kiwi.TE(pkt.Tid(), util.NewErr(util.EcNotExist, util.M{
+		err := util.NewErr(util.EcNotExist, util.M{
 			"service": pkt.Svc(),
 			"code":    pkt.Code(),
-		}))
+		})
+		addRcvDeadLetter(HdPush, pkt, err)
+		pkt.Err(err)
 		return
 	}
 	fn(pkt)
@@ -45,7 +49,7 @@ func (s *router) OnPush(pkt kiwi.IRcvPush) {
 func (s *router) OnRequest(pkt kiwi.IRcvRequest) {
 	fn, ok := s.reqHandle[kiwi.MergeSvcCode(pkt.Svc(), pkt.Code())]
 	if !ok {
-		kiwi.TE(pkt.Tid(), util.NewErr(util.EcNotExist, util.M{
+		pkt.Err(util.NewErr(util.EcNotExist, util.M{
 			"service": pkt.Svc(),
 			"code":    pkt.Code(),
 		}))
@@ -54,6 +58,56 @@ func (s *router) OnRequest(pkt kiwi.IRcvRequest) {
 	fn(pkt)
 }
 
+func (s *router) OnStream(stream kiwi.IRcvStream) {
+	fn, ok := s.strHandle[kiwi.MergeSvcCode(stream.Svc(), stream.Code())]
+	if !ok {
+		kiwi.TE2(stream.Tid(), util.EcNotExist, util.M{
+			"service": stream.Svc(),
+			"code":    stream.Code(),
+		})
+		stream.Cancel(util.EcNotExist)
+		return
+	}
+	worker.Go(func([]any) {
+		fn(stream)
+	})
+}
+
+// Handlers 已注册的push、request和stream处理
+func (s *router) Handlers() util.M {
+	pus := make([]util.M, 0, len(s.pusHandle))
+	for sc := range s.pusHandle {
+		svc, code := kiwi.SplitSvcCode(sc)
+		pus = append(pus, util.M{"svc": svc, "code": code})
+	}
+	req := make([]util.M, 0, len(s.reqHandle))
+	for sc := range s.reqHandle {
+		svc, code := kiwi.SplitSvcCode(sc)
+		req = append(req, util.M{"svc": svc, "code": code})
+	}
+	str := make([]util.M, 0, len(s.strHandle))
+	for sc := range s.strHandle {
+		svc, code := kiwi.SplitSvcCode(sc)
+		str = append(str, util.M{"svc": svc, "code": code})
+	}
+	return util.M{
+		"push":    pus,
+		"request": req,
+		"stream":  str,
+		"pending": s.idToRequest.Count(),
+	}
+}
+
+func (s *router) hasPus(sc kiwi.TSvcCode) bool {
+	_, ok := s.pusHandle[sc]
+	return ok
+}
+
+func (s *router) hasNotice(sc kiwi.TSvcCode) bool {
+	_, ok := s.notifyHandler[sc]
+	return ok
+}
+
 func (s *router) BindPus(svc kiwi.TSvc, code kiwi.TCode, fn kiwi.FnRcvPus) {
 	s.pusHandle[kiwi.MergeSvcCode(svc, code)] = fn
 }
@@ -62,6 +116,10 @@ func (s *router) BindReq(svc kiwi.TSvc, code kiwi.TCode, fn kiwi.FnRcvReq) {
 	s.reqHandle[kiwi.MergeSvcCode(svc, code)] = fn
 }
 
+func (s *router) BindStream(svc kiwi.TSvc, code kiwi.TCode, fn kiwi.FnRcvStream) {
+	s.strHandle[kiwi.MergeSvcCode(svc, code)] = fn
+}
+
 func (s *router) AddRequest(req kiwi.ISndRequest) {
 	s.idToRequest.Set(req.Tid(), req)
 }
@@ -86,9 +144,25 @@ func (s *router) OnResponseOkBytes(tid int64, head util.M, bytes []byte) {
 	req.OkBytes(head, bytes)
 }
 
+// retrier 按RetryPolicy重试的请求
+type retrier interface {
+	canRetry(head util.M, code uint16) bool
+}
+
+// OnResponseFail 可以重试的请求保留在idToRequest中,下一次尝试使用相同的tid
 func (s *router) OnResponseFail(tid int64, head util.M, code uint16) {
-	req, ok := s.idToRequest.Pop(tid)
-	if !ok {
+	var req kiwi.ISndRequest
+	s.idToRequest.RemoveCb(tid, func(_ int64, v kiwi.ISndRequest, exists bool) bool {
+		if !exists {
+			return false
+		}
+		if r, ok := v.(retrier); ok && r.canRetry(head, code) {
+			return false
+		}
+		req = v
+		return true
+	})
+	if req == nil {
 		return
 	}
 	req.Fail(head, code)
@@ -119,10 +193,12 @@ func (s *router) GetWatchCodes(svc kiwi.TSvc) ([]kiwi.TCode, bool) {
 func (s *router) OnNotice(pkt kiwi.IRcvNotice) {
 	handlerSlc, ok := s.notifyHandler[kiwi.MergeSvcCode(pkt.Svc(), pkt.Code())]
 	if !ok {
-		kiwi.TE2(pkt.Tid(), util.EcNotExist, util.M{
+		err := util.NewErr(util.EcNotExist, util.M{
 			"service": pkt.Svc(),
 			"code":    pkt.Code(),
 		})
+		addRcvDeadLetter(HdNotify, pkt, err)
+		pkt.Err(err)
 		return
 	}
 	for _, handler := range handlerSlc {
@@ -140,6 +216,7 @@ func ActivePrcPus[Pus util.IMsg](pkt kiwi.IRcvPush, key string, handler func(kiw
 			"name": pus.ProtoReflect().Descriptor().Name(),
 		})
 		handler(pkt, pus)
+		pkt.Complete()
 	}, pkt, handler)
 }
 
@@ -153,6 +230,7 @@ func SharePrcPus[Pus util.IMsg](pkt kiwi.IRcvPush, key string, handler func(kiwi
 			"name": pus.ProtoReflect().Descriptor().Name(),
 		})
 		handler(pkt, pus)
+		pkt.Complete()
 	}, pkt, handler)
 }
 
@@ -165,9 +243,10 @@ func GoPrcPus[Pus util.IMsg](pkt kiwi.IRcvPush, handler func(kiwi.IRcvPush, Pus)
 			"name": pus.ProtoReflect().Descriptor().Name(),
 		})
 		handler(pkt, pus)
+		pkt.Complete()
 	})
 	if e != nil {
-		kiwi.TE3(pkt.Tid(), util.EcServiceErr, e)
+		pkt.Err3(util.EcServiceErr, e)
 	}
 }
 
@@ -181,6 +260,7 @@ func GlobalPrcPus[Pus util.IMsg](pkt kiwi.IRcvPush, handler func(kiwi.IRcvPush,
 			"name": pus.ProtoReflect().Descriptor().Name(),
 		})
 		handler(pkt, pus)
+		pkt.Complete()
 	}, pkt, handler)
 }
 
@@ -192,12 +272,38 @@ func SelfPrcPus[Pus util.IMsg](pkt kiwi.IRcvPush, handler func(kiwi.IRcvPush, Pu
 		"name": pus.ProtoReflect().Descriptor().Name(),
 	})
 	handler(pkt, pus)
+	pkt.Complete()
+}
+
+// canceler 请求方收到其他节点的响应后取消的请求
+type canceler interface {
+	isCanceled() bool
+}
+
+// isExpired 请求方已超时或取消,不再执行处理
+func isExpired(pkt kiwi.IRcvRequest) bool {
+	if c, ok := pkt.(canceler); ok && c.isCanceled() {
+		kiwi.TI(pkt.Tid(), "canceled", nil)
+		pkt.Complete()
+		return true
+	}
+	if !pkt.Expired() {
+		return false
+	}
+	deadline, _ := pkt.Deadline()
+	pkt.Err2(util.EcTimeout, util.M{
+		"deadline": deadline,
+	})
+	return true
 }
 
 func ActivePrcReq[Req, Res util.IMsg](pkt kiwi.IRcvRequest, key string, handler func(kiwi.IRcvRequest, Req, Res)) {
 	pkt.SetWorker(kiwi.EWorkerActive, key)
 	worker.Active().Push(key, func(params []any) {
 		pkt, handler := util.SplitSlc2[kiwi.IRcvRequest, func(kiwi.IRcvRequest, Req, Res)](params)
+		if isExpired(pkt) {
+			return
+		}
 		code := pkt.Code()
 		res, err := kiwi.CodecSpawnRes[Res](pkt.Svc(), code)
 		if err != nil {
@@ -213,6 +319,9 @@ func SharePrcReq[Req, Res util.IMsg](pkt kiwi.IRcvRequest, key string, handler f
 	pkt.SetWorker(kiwi.EWorkerShare, key)
 	worker.Share().Push(key, func(params []any) {
 		pkt, handler := util.SplitSlc2[kiwi.IRcvRequest, func(kiwi.IRcvRequest, Req, Res)](params)
+		if isExpired(pkt) {
+			return
+		}
 		code := pkt.Code()
 		res, err := kiwi.CodecSpawnRes[Res](pkt.Svc(), code)
 		if err != nil {
@@ -227,6 +336,9 @@ func SharePrcReq[Req, Res util.IMsg](pkt kiwi.IRcvRequest, key string, handler f
 func GoPrcReq[Req, Res util.IMsg](pkt kiwi.IRcvRequest, handler func(kiwi.IRcvRequest, Req, Res)) {
 	pkt.SetWorker(kiwi.EWorkerGo, "")
 	e := ants.Submit(func() {
+		if isExpired(pkt) {
+			return
+		}
 		code := pkt.Code()
 		res, err := kiwi.CodecSpawnRes[Res](pkt.Svc(), code)
 		if err != nil {
@@ -237,7 +349,7 @@ func GoPrcReq[Req, Res util.IMsg](pkt kiwi.IRcvRequest, handler func(kiwi.IRcvRe
 		handler(pkt, req, res)
 	})
 	if e != nil {
-		kiwi.TE3(pkt.Tid(), util.EcServiceErr, e)
+		pkt.Err(util.WrapErr(util.EcServiceErr, e))
 	}
 }
 
@@ -245,6 +357,9 @@ func GlobalPrcReq[Req, Res util.IMsg](pkt kiwi.IRcvRequest, handler func(kiwi.IR
 	pkt.SetWorker(kiwi.EWorkerGlobal, "")
 	worker.Global().Push(func(params []any) {
 		pkt, handler := util.SplitSlc2[kiwi.IRcvRequest, func(kiwi.IRcvRequest, Req, Res)](params)
+		if isExpired(pkt) {
+			return
+		}
 		code := pkt.Code()
 		res, err := kiwi.CodecSpawnRes[Res](pkt.Svc(), code)
 		if err != nil {
@@ -258,6 +373,9 @@ func GlobalPrcReq[Req, Res util.IMsg](pkt kiwi.IRcvRequest, handler func(kiwi.IR
 
 func SelfPrcReq[Req, Res util.IMsg](pkt kiwi.IRcvRequest, handler func(kiwi.IRcvRequest, Req, Res)) {
 	pkt.SetWorker(kiwi.EWorkerSelf, "")
+	if isExpired(pkt) {
+		return
+	}
 	code := pkt.Code()
 	res, err := kiwi.CodecSpawnRes[Res](pkt.Svc(), code)
 	if err != nil {
@@ -278,6 +396,7 @@ func ActivePrcNtc[Ntc util.IMsg](pkt kiwi.IRcvNotice, key string, handler func(k
 			"name": ntc.ProtoReflect().Descriptor().Name(),
 		})
 		handler(pkt, ntc)
+		pkt.Complete()
 	}, pkt, handler)
 }
 
@@ -291,6 +410,7 @@ func SharePrcNtc[Ntc util.IMsg](pkt kiwi.IRcvNotice, key string, handler func(ki
 			"name": ntc.ProtoReflect().Descriptor().Name(),
 		})
 		handler(pkt, ntc)
+		pkt.Complete()
 	}, pkt, handler)
 }
 
@@ -303,9 +423,10 @@ func GoPrcNtc[Ntc util.IMsg](pkt kiwi.IRcvNotice, handler func(kiwi.IRcvNotice,
 			"name": ntc.ProtoReflect().Descriptor().Name(),
 		})
 		handler(pkt, ntc)
+		pkt.Complete()
 	})
 	if e != nil {
-		kiwi.TE3(pkt.Tid(), util.EcServiceErr, e)
+		pkt.Err3(util.EcServiceErr, e)
 	}
 }
 
@@ -319,6 +440,7 @@ func GlobalPrcNtc[Ntc util.IMsg](pkt kiwi.IRcvNotice, handler func(kiwi.IRcvNoti
 			"name": ntc.ProtoReflect().Descriptor().Name(),
 		})
 		handler(pkt, ntc)
+		pkt.Complete()
 	}, pkt, handler)
 }
 
@@ -330,6 +452,7 @@ func SelfPrcNtc[Ntc util.IMsg](pkt kiwi.IRcvNotice, handler func(kiwi.IRcvNotice
 		"name": ntc.ProtoReflect().Descriptor().Name(),
 	})
 	handler(pkt, ntc)
+	pkt.Complete()
 }
 
 var (
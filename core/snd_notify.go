@@ -4,6 +4,7 @@ import (
 	"sync"
 
 	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/sid"
 	"github.com/15mga/kiwi/util"
 )
 
@@ -21,14 +22,13 @@ func newNotify(pid int64, head util.M, msg util.IMsg) *SNotify {
 		kiwi.Fatal(err)
 		return nil
 	}
-	if head == nil {
-		head = util.M{}
-	}
+	head = sndHead(head)
 	GenHead(head)
 	svc, code := kiwi.Codec().MsgToSvcCode(msg)
 	ntf := _NtfPool.Get().(*SNotify)
 	ntf.pid = pid
-	ntf.tid = kiwi.TC(pid, head, IsExcludeLog(svc, code))
+	tid := sid.GetId()
+	ntf.tid = kiwi.TCId(pid, tid, head, !logTid(svc, code, tid))
 	ntf.svc, ntf.code = svc, code
 	ntf.json = false
 	ntf.head = head
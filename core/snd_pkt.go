@@ -13,8 +13,22 @@ const (
 	HeadCode  = "cod"
 	HeadSndId = "snd_id"
 	HeadSndTs = "snd_ts"
+	// HeadDeadline 请求方的截止时间戳(毫秒)
+	HeadDeadline = "ddl"
+	// HeadResId 响应的节点,请求方按这个节点报告熔断
+	HeadResId = "res_id"
+	// HeadRetry 请求方有重试策略,其他节点先响应时会发送HdCancel
+	HeadRetry = "rty"
 )
 
+// sndHead 复制调用方的包头,调用方可能复用收到的包头,写入traceparent等字段不能影响它
+func sndHead(head util.M) util.M {
+	if head == nil {
+		return util.M{}
+	}
+	return head.Copy(nil)
+}
+
 type sndPkt struct {
 	pid     int64
 	tid     int64
@@ -4,6 +4,7 @@ import (
 	"github.com/15mga/kiwi"
 	"sync"
 
+	"github.com/15mga/kiwi/sid"
 	"github.com/15mga/kiwi/util"
 )
 
@@ -25,9 +26,7 @@ func newSPush(pid int64, head util.M, msg util.IMsg) *SPush {
 		return nil
 	}
 
-	if head == nil {
-		head = util.M{}
-	}
+	head = sndHead(head)
 	GenHead(head)
 	svc, code := kiwi.Codec().MsgToSvcCode(msg)
 
@@ -38,7 +37,8 @@ func newSPush(pid int64, head util.M, msg util.IMsg) *SPush {
 	pus.head = head
 	pus.payload = payload
 	pus.InitHead()
-	pus.tid = kiwi.TC(pid, head, IsExcludeLog(svc, code))
+	tid := sid.GetId()
+	pus.tid = kiwi.TCId(pid, tid, head, !logTid(svc, code, tid))
 	return pus
 }
 
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"context"
 	"github.com/15mga/kiwi/worker"
 	"github.com/panjf2000/ants/v2"
 	"reflect"
@@ -9,16 +10,20 @@ import (
 	"time"
 
 	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/sid"
 	"github.com/15mga/kiwi/util"
 )
 
 type SRequest struct {
 	sndPkt
 	isBytes  bool
-	timer    *time.Timer
+	nodeId   int64
+	ctx      context.Context
+	watcher  *reqWatcher
 	okBytes  util.FnInt64MBytes
 	okMsg    util.FnInt64MMsg
 	fail     util.FnInt64MUint16
+	retry    *reqRetry
 	disposed int32
 }
 
@@ -39,6 +44,10 @@ func (r *SRequest) OkBytes(head util.M, bytes []byte) {
 		return
 	}
 	defer r.Dispose()
+	r.reportNode(head, 0)
+	if r.retry != nil {
+		r.retry.ok()
+	}
 
 	if r.isBytes {
 		if r.okBytes == nil {
@@ -75,9 +84,21 @@ func (r *SRequest) Ok(head util.M, msg util.IMsg) {
 		return
 	}
 	defer r.Dispose()
+	r.reportNode(head, 0)
+	if r.retry != nil {
+		r.retry.ok()
+	}
 
 	if r.isBytes {
-		bytes, err := kiwi.Codec().PbMarshal(msg)
+		var (
+			bytes []byte
+			err   *util.Err
+		)
+		if r.json {
+			bytes, err = kiwi.Codec().JsonMarshal(msg)
+		} else {
+			bytes, err = kiwi.Codec().PbMarshal(msg)
+		}
 		if err != nil {
 			kiwi.TE(r.tid, err)
 			r.fail(r.tid, head, err.Code())
@@ -94,11 +115,113 @@ func (r *SRequest) Fail(head util.M, code uint16) {
 		return
 	}
 	defer r.Dispose()
+	r.reportNode(head, code)
+	if r.fail == nil {
+		return
+	}
 	r.fail(r.tid, head, code)
 }
 
-func (r *SRequest) timeout() {
-	kiwi.TE2(r.tid, util.EcTimeout, r.head)
+func (r *SRequest) SetNodeId(nodeId int64) {
+	if r.isDisposed() || r.nodeId == nodeId {
+		return
+	}
+	if r.nodeId != 0 {
+		addNodePending(r.nodeId, -1)
+	}
+	r.nodeId = nodeId
+	addNodePending(nodeId, 1)
+	if r.retry != nil {
+		r.retry.addTried(nodeId)
+	}
+}
+
+// reportNode 有重试时按响应的节点报告所有尝试过的节点
+func (r *SRequest) reportNode(head util.M, code uint16) {
+	nodeId, _ := util.MGet[int64](head, HeadResId)
+	if r.retry != nil {
+		r.retry.finish(nodeId, code)
+		return
+	}
+	if nodeId == 0 {
+		nodeId = r.nodeId
+	}
+	reportNode(nodeId, code)
+}
+
+func (r *SRequest) NodeId() int64 {
+	return r.nodeId
+}
+
+func (r *SRequest) canRetry(head util.M, code uint16) bool {
+	if r.retry == nil {
+		return false
+	}
+	nodeId, _ := util.MGet[int64](head, HeadResId)
+	return r.retry.retry(nodeId, code)
+}
+
+// reqWatcher 计时器和ctx都通过router结束请求,Pop保证只有一个结果生效,
+// 不放回池中,请求已经回收后再start也是安全的
+type reqWatcher struct {
+	mtx     sync.Mutex
+	stopped bool
+	timer   *time.Timer
+	stopCh  chan struct{}
+}
+
+// start 在请求发送后调用,请求已经结束时不再计时
+func (w *reqWatcher) start(ctx context.Context, tid int64, head util.M, dur time.Duration) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.stopped {
+		return
+	}
+	w.timer = time.AfterFunc(dur, func() {
+		kiwi.TE2(tid, util.EcTimeout, util.M{
+			"dur": dur.Milliseconds(),
+		})
+		kiwi.Router().OnResponseFail(tid, head, util.EcTimeout)
+	})
+	doneCh := ctx.Done()
+	if doneCh == nil {
+		return
+	}
+	w.stopCh = make(chan struct{})
+	go func(stopCh <-chan struct{}) {
+		select {
+		case <-doneCh:
+			code, _ := ctxCode(ctx)
+			kiwi.TW2(tid, code, nil)
+			kiwi.Router().OnResponseFail(tid, head, code)
+		case <-stopCh:
+		}
+	}(w.stopCh)
+}
+
+func (w *reqWatcher) stop() {
+	w.mtx.Lock()
+	w.stopped = true
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	if w.stopCh != nil {
+		close(w.stopCh)
+		w.stopCh = nil
+	}
+	w.mtx.Unlock()
+}
+
+func ctxCode(ctx context.Context) (uint16, bool) {
+	switch ctx.Err() {
+	case nil:
+		return 0, false
+	case context.DeadlineExceeded:
+		return util.EcTimeout, true
+	default:
+		return util.EcCanceled, true
+	}
 }
 
 func (r *SRequest) isDisposed() bool {
@@ -107,8 +230,20 @@ func (r *SRequest) isDisposed() bool {
 
 func (r *SRequest) Dispose() {
 	if atomic.CompareAndSwapInt32(&r.disposed, 0, 1) {
+		if r.retry != nil {
+			r.retry.stop()
+			r.retry = nil
+		}
 		r.sndPkt.Dispose()
-		r.timer.Stop()
+		if r.nodeId != 0 {
+			addNodePending(r.nodeId, -1)
+			r.nodeId = 0
+		}
+		r.ctx = nil
+		if r.watcher != nil {
+			r.watcher.stop()
+			r.watcher = nil
+		}
 		_ReqPool.Put(r)
 	}
 }
@@ -122,10 +257,8 @@ var (
 	}
 )
 
-func newBytesRequest(pid int64, svc kiwi.TSvc, code kiwi.TCode, head util.M, json bool, payload []byte) *SRequest {
-	if head == nil {
-		head = util.M{}
-	}
+func newBytesRequest(ctx context.Context, pid int64, svc kiwi.TSvc, code kiwi.TCode, head util.M, json bool, payload []byte) *SRequest {
+	head = sndHead(head)
 	GenHead(head)
 
 	req := _ReqPool.Get().(*SRequest)
@@ -135,13 +268,14 @@ func newBytesRequest(pid int64, svc kiwi.TSvc, code kiwi.TCode, head util.M, jso
 	req.head = head
 	req.payload = payload
 	req.InitHead()
-	req.timer = time.AfterFunc(ResponseTimeoutDur, req.timeout)
-	req.tid = kiwi.TC(pid, head, IsExcludeLog(svc, code))
+	tid := sid.GetId()
+	req.tid = kiwi.TCId(pid, tid, head, !logTid(svc, code, tid))
+	req.ctx = ctx
 	atomic.StoreInt32(&req.disposed, 0)
 	return req
 }
 
-func newRequest(pid int64, head util.M, json bool, msg util.IMsg) *SRequest {
+func newRequest(ctx context.Context, pid int64, head util.M, json bool, msg util.IMsg) *SRequest {
 	var (
 		payload []byte
 		err     *util.Err
@@ -156,13 +290,37 @@ func newRequest(pid int64, head util.M, json bool, msg util.IMsg) *SRequest {
 		return nil
 	}
 	svc, code := kiwi.Codec().MsgToSvcCode(msg)
-	req := newBytesRequest(pid, svc, code, head, json, payload)
+	req := newBytesRequest(ctx, pid, svc, code, head, json, payload)
 	req.msg = msg
 	return req
 }
 
-func Req[ResT util.IMsg](pid int64, head util.M, msg util.IMsg) (ResT, util.M, uint16) {
-	req := newRequest(pid, head, false, msg)
+// sendRequest ctx已经结束时直接失败,不加入router也不发送;
+// 先加入router再发送,发送后才开始计时,避免打包时请求已经被结束并放回池中
+func sendRequest(req *SRequest, send func(*SRequest)) {
+	ctx, tid, head := req.ctx, req.tid, req.head
+	dur := ResponseTimeoutDur
+	if deadline, ok := ctx.Deadline(); ok {
+		dur = time.Until(deadline)
+		head.Set(HeadDeadline, deadline.UnixMilli())
+	}
+	code, done := ctxCode(ctx)
+	if !done && dur <= 0 {
+		code, done = util.EcTimeout, true
+	}
+	if done {
+		kiwi.TW2(tid, code, nil)
+		req.Fail(head, code)
+		return
+	}
+	w := &reqWatcher{}
+	req.watcher = w
+	kiwi.Router().AddRequest(req)
+	send(req)
+	w.start(ctx, tid, head, dur)
+}
+
+func waitMsg[ResT any](req *SRequest, send func(*SRequest)) (ResT, util.M, uint16) {
 	okCh := make(chan util.IMsg, 1)
 	failCh := make(chan uint16, 1)
 	var rm util.M
@@ -173,8 +331,7 @@ func Req[ResT util.IMsg](pid int64, head util.M, msg util.IMsg) (ResT, util.M, u
 		rm = m
 		okCh <- a
 	})
-	kiwi.Router().AddRequest(req)
-	kiwi.Node().Request(req)
+	sendRequest(req, send)
 	select {
 	case res := <-okCh:
 		r, ok := res.(ResT)
@@ -187,31 +344,8 @@ func Req[ResT util.IMsg](pid int64, head util.M, msg util.IMsg) (ResT, util.M, u
 	}
 }
 
-func Req2(pid int64, head util.M, msg util.IMsg) (util.IMsg, util.M, uint16) {
-	req := newRequest(pid, head, false, msg)
-	okCh := make(chan util.IMsg)
-	failCh := make(chan uint16, 1)
-	var rm util.M
-	req.SetHandler(func(_ int64, m util.M, code uint16) {
-		rm = m
-		failCh <- code
-	}, func(_ int64, m util.M, a util.IMsg) {
-		rm = m
-		okCh <- a
-	})
-	kiwi.Router().AddRequest(req)
-	kiwi.Node().Request(req)
-	select {
-	case res := <-okCh:
-		return res, rm, 0
-	case code := <-failCh:
-		return nil, rm, code
-	}
-}
-
-func ReqBytes(pid int64, svc kiwi.TSvc, code kiwi.TCode, head util.M, json bool, payload []byte) ([]byte, util.M, uint16) {
-	req := newBytesRequest(pid, svc, code, head, json, payload)
-	okCh := make(chan []byte)
+func waitBytes(req *SRequest, send func(*SRequest)) ([]byte, util.M, uint16) {
+	okCh := make(chan []byte, 1)
 	failCh := make(chan uint16, 1)
 	var rm util.M
 	req.SetBytesHandler(func(_ int64, m util.M, code uint16) {
@@ -221,8 +355,7 @@ func ReqBytes(pid int64, svc kiwi.TSvc, code kiwi.TCode, head util.M, json bool,
 		rm = m
 		okCh <- payload
 	})
-	kiwi.Router().AddRequest(req)
-	kiwi.Node().Request(req)
+	sendRequest(req, send)
 	select {
 	case res := <-okCh:
 		return res, rm, 0
@@ -231,82 +364,99 @@ func ReqBytes(pid int64, svc kiwi.TSvc, code kiwi.TCode, head util.M, json bool,
 	}
 }
 
-func ReqNode[ResT any](nodeId, pid int64, head util.M, msg util.IMsg) (ResT, util.M, uint16) {
-	req := newRequest(pid, head, false, msg)
-	okCh := make(chan util.IMsg, 1)
-	failCh := make(chan uint16, 1)
-	var rm util.M
-	req.SetHandler(func(_ int64, m util.M, code uint16) {
-		rm = m
-		failCh <- code
-	}, func(_ int64, m util.M, a util.IMsg) {
-		rm = m
-		okCh <- a
-	})
-	kiwi.Router().AddRequest(req)
-	kiwi.Node().RequestNode(nodeId, req)
-	select {
-	case res := <-okCh:
-		r, ok := res.(ResT)
-		if !ok {
-			return util.Default[ResT](), nil, util.EcWrongType
-		}
-		return r, rm, 0
-	case code := <-failCh:
-		return util.Default[ResT](), nil, code
+func sendToSvc(req *SRequest) {
+	requestSvc(req)
+}
+
+func sendToNode(nodeId int64) func(*SRequest) {
+	return func(req *SRequest) {
+		kiwi.Node().RequestNode(nodeId, req)
 	}
 }
 
+func Req[ResT util.IMsg](pid int64, head util.M, msg util.IMsg) (ResT, util.M, uint16) {
+	return ReqCtx[ResT](context.Background(), pid, head, msg)
+}
+
+// ReqCtx ctx的截止时间覆盖ResponseTimeoutDur,取消ctx时以EcCanceled结束请求
+func ReqCtx[ResT util.IMsg](ctx context.Context, pid int64, head util.M, msg util.IMsg) (ResT, util.M, uint16) {
+	return waitMsg[ResT](newRequest(ctx, pid, head, false, msg), sendToSvc)
+}
+
+func Req2(pid int64, head util.M, msg util.IMsg) (util.IMsg, util.M, uint16) {
+	return Req2Ctx(context.Background(), pid, head, msg)
+}
+
+func Req2Ctx(ctx context.Context, pid int64, head util.M, msg util.IMsg) (util.IMsg, util.M, uint16) {
+	return waitMsg[util.IMsg](newRequest(ctx, pid, head, false, msg), sendToSvc)
+}
+
+func ReqBytes(pid int64, svc kiwi.TSvc, code kiwi.TCode, head util.M, json bool, payload []byte) ([]byte, util.M, uint16) {
+	return ReqBytesCtx(context.Background(), pid, svc, code, head, json, payload)
+}
+
+func ReqBytesCtx(ctx context.Context, pid int64, svc kiwi.TSvc, code kiwi.TCode, head util.M, json bool, payload []byte) ([]byte, util.M, uint16) {
+	return waitBytes(newBytesRequest(ctx, pid, svc, code, head, json, payload), sendToSvc)
+}
+
+func ReqNode[ResT any](nodeId, pid int64, head util.M, msg util.IMsg) (ResT, util.M, uint16) {
+	return ReqNodeCtx[ResT](context.Background(), nodeId, pid, head, msg)
+}
+
+func ReqNodeCtx[ResT any](ctx context.Context, nodeId, pid int64, head util.M, msg util.IMsg) (ResT, util.M, uint16) {
+	return waitMsg[ResT](newRequest(ctx, pid, head, false, msg), sendToNode(nodeId))
+}
+
 func ReqNodeBytes(nodeId, pid int64, svc kiwi.TSvc, code kiwi.TCode, head util.M, json bool, payload []byte) ([]byte, util.M, uint16) {
-	req := newBytesRequest(pid, svc, code, head, json, payload)
-	okCh := make(chan []byte, 1)
-	failCh := make(chan uint16, 1)
-	var rm util.M
-	req.SetBytesHandler(func(_ int64, m util.M, code uint16) {
-		rm = m
-		failCh <- code
-	}, func(_ int64, m util.M, payload []byte) {
-		rm = m
-		okCh <- payload
-	})
-	kiwi.Router().AddRequest(req)
-	kiwi.Node().RequestNode(nodeId, req)
-	select {
-	case res := <-okCh:
-		return res, rm, 0
-	case code := <-failCh:
-		return nil, nil, code
-	}
+	return ReqNodeBytesCtx(context.Background(), nodeId, pid, svc, code, head, json, payload)
+}
+
+func ReqNodeBytesCtx(ctx context.Context, nodeId, pid int64, svc kiwi.TSvc, code kiwi.TCode, head util.M, json bool, payload []byte) ([]byte, util.M, uint16) {
+	return waitBytes(newBytesRequest(ctx, pid, svc, code, head, json, payload), sendToNode(nodeId))
 }
 
 func AsyncReq(pid int64, head util.M, msg util.IMsg, onFail util.FnInt64MUint16, onOk util.FnInt64MMsg) {
-	req := newRequest(pid, head, false, msg)
+	AsyncReqCtx(context.Background(), pid, head, msg, onFail, onOk)
+}
+
+func AsyncReqCtx(ctx context.Context, pid int64, head util.M, msg util.IMsg, onFail util.FnInt64MUint16, onOk util.FnInt64MMsg) {
+	req := newRequest(ctx, pid, head, false, msg)
 	req.SetHandler(onFail, onOk)
-	kiwi.Router().AddRequest(req)
-	kiwi.Node().Request(req)
+	sendRequest(req, requestSvc)
 }
 
 func AsyncReqBytes(pid int64, svc kiwi.TSvc, code kiwi.TCode, head util.M, json bool, payload []byte,
 	onFail util.FnInt64MUint16, onOk util.FnInt64MBytes) {
-	req := newBytesRequest(pid, svc, code, head, json, payload)
+	AsyncReqBytesCtx(context.Background(), pid, svc, code, head, json, payload, onFail, onOk)
+}
+
+func AsyncReqBytesCtx(ctx context.Context, pid int64, svc kiwi.TSvc, code kiwi.TCode, head util.M, json bool, payload []byte,
+	onFail util.FnInt64MUint16, onOk util.FnInt64MBytes) {
+	req := newBytesRequest(ctx, pid, svc, code, head, json, payload)
 	req.SetBytesHandler(onFail, onOk)
-	kiwi.Router().AddRequest(req)
-	kiwi.Node().Request(req)
+	sendRequest(req, requestSvc)
 }
 
 func AsyncReqNode(pid, nodeId int64, head util.M, msg util.IMsg, onFail util.FnInt64MUint16, onOk util.FnInt64MMsg) {
-	req := newRequest(pid, head, false, msg)
+	AsyncReqNodeCtx(context.Background(), pid, nodeId, head, msg, onFail, onOk)
+}
+
+func AsyncReqNodeCtx(ctx context.Context, pid, nodeId int64, head util.M, msg util.IMsg, onFail util.FnInt64MUint16, onOk util.FnInt64MMsg) {
+	req := newRequest(ctx, pid, head, false, msg)
 	req.SetHandler(onFail, onOk)
-	kiwi.Router().AddRequest(req)
-	kiwi.Node().RequestNode(nodeId, req)
+	sendRequest(req, sendToNode(nodeId))
 }
 
 func AsyncReqNodeBytes(pid, nodeId int64, svc kiwi.TSvc, code kiwi.TCode, head util.M, json bool, payload []byte,
 	onFail util.FnInt64MUint16, onOk util.FnInt64MBytes) {
-	req := newBytesRequest(pid, svc, code, head, json, payload)
+	AsyncReqNodeBytesCtx(context.Background(), pid, nodeId, svc, code, head, json, payload, onFail, onOk)
+}
+
+func AsyncReqNodeBytesCtx(ctx context.Context, pid, nodeId int64, svc kiwi.TSvc, code kiwi.TCode, head util.M, json bool, payload []byte,
+	onFail util.FnInt64MUint16, onOk util.FnInt64MBytes) {
+	req := newBytesRequest(ctx, pid, svc, code, head, json, payload)
 	req.SetBytesHandler(onFail, onOk)
-	kiwi.Router().AddRequest(req)
-	kiwi.Node().RequestNode(nodeId, req)
+	sendRequest(req, sendToNode(nodeId))
 }
 
 func AsyncSubReq[ResT util.IMsg](pkt kiwi.IRcvRequest, head util.M, req util.IMsg, resFail util.FnInt64MUint16, resOk func(int64, util.M, ResT)) {
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/sid"
+	"github.com/15mga/kiwi/util"
+)
+
+// dropNode 只记录发送次数,不返回响应
+type dropNode struct {
+	nodeBase
+	sent   int32
+	toNode func(nodeId int64, bytes []byte)
+}
+
+func (n *dropNode) SendToNode(nodeId int64, bytes []byte, _ util.FnErr) {
+	if n.toNode != nil {
+		n.toNode(nodeId, bytes)
+	}
+}
+
+func (n *dropNode) Request(kiwi.ISndRequest) {
+	atomic.AddInt32(&n.sent, 1)
+}
+
+func (n *dropNode) RequestNode(int64, kiwi.ISndRequest) {
+	atomic.AddInt32(&n.sent, 1)
+}
+
+var _InitDropNode sync.Once
+
+// testDropNode 只初始化一次router,上一个测试中已经触发的计时器仍可能访问它
+func testDropNode() *dropNode {
+	_InitDropNode.Do(func() {
+		sid.SetNodeId(1)
+		InitCodec()
+		InitRouter()
+	})
+	n := &dropNode{}
+	kiwi.SetNode(n)
+	return n
+}
+
+func pendingRequests() int {
+	return kiwi.Router().(*router).idToRequest.Count()
+}
+
+func TestReqCtxDone(t *testing.T) {
+	n := testDropNode()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, code := ReqBytesCtx(ctx, 0, 1, 1, nil, false, nil)
+	if code != util.EcCanceled {
+		t.Fatal("canceled", code)
+	}
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	_, _, code = ReqBytesCtx(ctx, 0, 1, 1, nil, false, nil)
+	if code != util.EcTimeout {
+		t.Fatal("expired", code)
+	}
+	if atomic.LoadInt32(&n.sent) != 0 || pendingRequests() != 0 {
+		t.Fatal("sent", n.sent, pendingRequests())
+	}
+}
+
+func TestReqCtxDeadline(t *testing.T) {
+	n := testDropNode()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, _, code := ReqBytesCtx(ctx, 0, 1, 1, nil, false, nil)
+	if code != util.EcTimeout || time.Since(start) >= ResponseTimeoutDur {
+		t.Fatal("deadline", code, time.Since(start))
+	}
+	if atomic.LoadInt32(&n.sent) != 1 || pendingRequests() != 0 {
+		t.Fatal("sent", n.sent, pendingRequests())
+	}
+}
+
+func TestReqCtxCancel(t *testing.T) {
+	n := testDropNode()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	ch := make(chan uint16, 1)
+	AsyncReqBytesCtx(ctx, 0, 1, 1, nil, false, nil, func(_ int64, _ util.M, code uint16) {
+		ch <- code
+	}, nil)
+	select {
+	case code := <-ch:
+		if code != util.EcCanceled {
+			t.Fatal("cancel", code)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("not canceled")
+	}
+	if atomic.LoadInt32(&n.sent) != 1 || pendingRequests() != 0 {
+		t.Fatal("sent", n.sent, pendingRequests())
+	}
+}
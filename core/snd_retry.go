@@ -0,0 +1,306 @@
+package core
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+)
+
+var (
+	// HedgeMinSamples 响应耗时样本少于这个数量时不按p95对冲
+	HedgeMinSamples = 20
+	// LatencySamples 每个请求保留最近多少次的响应耗时
+	LatencySamples    = 128
+	_DefRetryCodes    = []uint16{util.EcUnavailable, util.EcBusy, util.EcSendErr}
+	_SvcCodeToLatency sync.Map
+)
+
+type latencyRing struct {
+	mtx     sync.Mutex
+	idx     int
+	samples []time.Duration
+}
+
+func (l *latencyRing) add(dur time.Duration) {
+	l.mtx.Lock()
+	if len(l.samples) < LatencySamples {
+		l.samples = append(l.samples, dur)
+	} else {
+		l.samples[l.idx] = dur
+		l.idx = (l.idx + 1) % len(l.samples)
+	}
+	l.mtx.Unlock()
+}
+
+func (l *latencyRing) p95() (time.Duration, bool) {
+	l.mtx.Lock()
+	if len(l.samples) < HedgeMinSamples {
+		l.mtx.Unlock()
+		return 0, false
+	}
+	slc := make([]time.Duration, len(l.samples))
+	copy(slc, l.samples)
+	l.mtx.Unlock()
+	sort.Slice(slc, func(i, j int) bool {
+		return slc[i] < slc[j]
+	})
+	return slc[len(slc)*95/100], true
+}
+
+func recordLatency(svc kiwi.TSvc, code kiwi.TCode, dur time.Duration) {
+	sc := kiwi.MergeSvcCode(svc, code)
+	v, ok := _SvcCodeToLatency.Load(sc)
+	if !ok {
+		v, _ = _SvcCodeToLatency.LoadOrStore(sc, &latencyRing{})
+	}
+	v.(*latencyRing).add(dur)
+}
+
+// LatencyP95 请求最近响应耗时的p95,样本不足时ok为false
+func LatencyP95(svc kiwi.TSvc, code kiwi.TCode) (time.Duration, bool) {
+	v, ok := _SvcCodeToLatency.Load(kiwi.MergeSvcCode(svc, code))
+	if !ok {
+		return 0, false
+	}
+	return v.(*latencyRing).p95()
+}
+
+// nodeResender 重发时排除已经尝试过的节点
+type nodeResender interface {
+	resend(req *SRequest, tid int64, except []int64)
+}
+
+// reqRetry 请求的所有尝试共用router中的tid,
+// 最先到达的响应取出请求,其他还没有结果的节点收到HdCancel,未执行的重试和对冲在Dispose时停止。
+// 远端已经开始的处理不会中断,之后的响应找不到tid被丢弃
+type reqRetry struct {
+	mtx      sync.Mutex
+	policy   *kiwi.RetryPolicy
+	req      *SRequest
+	tid      int64
+	svc      kiwi.TSvc
+	code     kiwi.TCode
+	start    time.Time
+	deadline time.Time
+	attempts int
+	retries  int
+	tried    []int64
+	pending  []int64 //还没有向熔断器报告结果的节点
+	timer    *time.Timer
+	done     bool
+}
+
+func newReqRetry(req *SRequest, policy *kiwi.RetryPolicy) *reqRetry {
+	r := &reqRetry{
+		policy:   policy,
+		req:      req,
+		tid:      req.tid,
+		svc:      req.svc,
+		code:     req.code,
+		start:    time.Now(),
+		attempts: 1,
+	}
+	if deadline, ok := req.ctx.Deadline(); ok {
+		r.deadline = deadline
+	} else {
+		r.deadline = r.start.Add(ResponseTimeoutDur)
+	}
+	return r
+}
+
+func (r *reqRetry) retryable(code uint16) bool {
+	codes := r.policy.Codes
+	if len(codes) == 0 {
+		codes = _DefRetryCodes
+	}
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *reqRetry) backoff() time.Duration {
+	dur := r.policy.Backoff
+	for i := 0; i < r.retries && dur > 0; i++ {
+		dur <<= 1
+		if r.policy.MaxBackoff > 0 && dur >= r.policy.MaxBackoff {
+			break
+		}
+	}
+	if r.policy.MaxBackoff > 0 && dur > r.policy.MaxBackoff {
+		dur = r.policy.MaxBackoff
+	}
+	return dur
+}
+
+// retry 在router取出请求前调用,返回true时请求保留在router中等待下一次尝试,
+// 不能再重试但还有其他尝试没有结果时也返回true,等它们都失败后才结束请求
+func (r *reqRetry) retry(nodeId int64, code uint16) bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.done || !r.retryable(code) {
+		return false
+	}
+	dur := r.backoff()
+	if r.attempts >= r.policy.MaxAttempts || !time.Now().Add(dur).Before(r.deadline) {
+		if !r.waitLocked(nodeId) {
+			return false
+		}
+		r.reportLocked(nodeId, code)
+		return true
+	}
+	r.reportLocked(nodeId, code)
+	r.attempts++
+	r.retries++
+	kiwi.TW2(r.tid, code, util.M{
+		"attempt": r.attempts,
+		"backoff": dur.Milliseconds(),
+	})
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	r.timer = time.AfterFunc(dur, r.resend)
+	return true
+}
+
+// waitLocked 除了失败的节点还有没有结果的尝试
+func (r *reqRetry) waitLocked(nodeId int64) bool {
+	for _, id := range r.pending {
+		if id != nodeId {
+			return true
+		}
+	}
+	return false
+}
+
+// hedge 发送后调用,超过延迟没有响应时再发送一次
+func (r *reqRetry) hedge() {
+	if !r.policy.Hedge || kiwi.GetNodeMeta().HasService(r.svc) {
+		return
+	}
+	dur := r.policy.HedgeDelay
+	if dur == 0 {
+		var ok bool
+		dur, ok = LatencyP95(r.svc, r.code)
+		if !ok {
+			return
+		}
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.done || r.attempts >= r.policy.MaxAttempts {
+		return
+	}
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	r.timer = time.AfterFunc(dur, func() {
+		r.mtx.Lock()
+		if r.done || r.attempts >= r.policy.MaxAttempts {
+			r.mtx.Unlock()
+			return
+		}
+		r.attempts++
+		attempts := r.attempts
+		r.mtx.Unlock()
+		kiwi.TI(r.tid, "hedge", util.M{
+			"attempt": attempts,
+		})
+		r.resend()
+	})
+}
+
+func (r *reqRetry) resend() {
+	r.mtx.Lock()
+	if r.done {
+		r.mtx.Unlock()
+		return
+	}
+	except := append([]int64(nil), r.tried...)
+	r.mtx.Unlock()
+	if s, ok := kiwi.Node().(nodeResender); ok && !kiwi.GetNodeMeta().HasService(r.svc) {
+		s.resend(r.req, r.tid, except)
+	} else {
+		kiwi.Node().Request(r.req)
+	}
+	r.hedge()
+}
+
+func (r *reqRetry) addTried(nodeId int64) {
+	r.mtx.Lock()
+	r.tried = append(r.tried, nodeId)
+	r.pending = append(r.pending, nodeId)
+	r.mtx.Unlock()
+}
+
+func (r *reqRetry) reportLocked(nodeId int64, code uint16) {
+	for i, id := range r.pending {
+		if id == nodeId {
+			r.pending = append(r.pending[:i], r.pending[i+1:]...)
+			reportNode(nodeId, code)
+			return
+		}
+	}
+}
+
+// finish 请求结束时报告响应的节点,其他没有结果的尝试释放半开的探测并通知取消,
+// nodeId为0的失败(超时等)没有响应的节点,所有尝试都按code报告
+func (r *reqRetry) finish(nodeId int64, code uint16) {
+	r.mtx.Lock()
+	pending := r.pending
+	r.pending = nil
+	r.mtx.Unlock()
+	for _, id := range pending {
+		if id == nodeId || (nodeId == 0 && code != 0) {
+			reportNode(id, code)
+			continue
+		}
+		reportNode(id, util.EcCanceled)
+		kiwi.Node().SendToNode(id, packCancel(r.tid), func(err *util.Err) {
+			if err != nil {
+				kiwi.TE(r.tid, err)
+			}
+		})
+	}
+}
+
+func packCancel(tid int64) []byte {
+	var buffer util.ByteBuffer
+	buffer.InitCap(9)
+	buffer.WUint8(HdCancel)
+	buffer.WInt64(tid)
+	return buffer.All()
+}
+
+func (r *reqRetry) ok() {
+	recordLatency(r.svc, r.code, time.Since(r.start))
+}
+
+func (r *reqRetry) stop() {
+	r.mtx.Lock()
+	r.done = true
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+	r.mtx.Unlock()
+}
+
+// requestSvc 按请求的RetryPolicy发送
+func requestSvc(req *SRequest) {
+	policy, ok := kiwi.Codec().GetRetry(req.svc, req.code)
+	if ok && policy.MaxAttempts > 1 {
+		r := newReqRetry(req, policy)
+		req.retry = r
+		req.Head().Set(HeadRetry, true)
+		kiwi.Node().Request(req)
+		r.hedge()
+		return
+	}
+	kiwi.Node().Request(req)
+}
@@ -0,0 +1,141 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	r := &reqRetry{
+		policy: &kiwi.RetryPolicy{
+			MaxAttempts: 5,
+			Backoff:     10 * time.Millisecond,
+			MaxBackoff:  50 * time.Millisecond,
+		},
+	}
+	for i, dur := range []time.Duration{10, 20, 40, 50, 50} {
+		r.retries = i
+		if r.backoff() != dur*time.Millisecond {
+			t.Fatal(i, r.backoff())
+		}
+	}
+	if !r.retryable(util.EcUnavailable) || r.retryable(util.EcTimeout) {
+		t.Fatal("default codes")
+	}
+	r.policy.Codes = []uint16{util.EcTimeout}
+	if r.retryable(util.EcUnavailable) || !r.retryable(util.EcTimeout) {
+		t.Fatal("codes")
+	}
+}
+
+func TestLatencyP95(t *testing.T) {
+	l := &latencyRing{}
+	for i := 1; i < HedgeMinSamples; i++ {
+		l.add(time.Duration(i))
+	}
+	if _, ok := l.p95(); ok {
+		t.Fatal("not enough samples")
+	}
+	for i := HedgeMinSamples; i <= LatencySamples+100; i++ {
+		l.add(time.Duration(i))
+	}
+	if len(l.samples) != LatencySamples {
+		t.Fatal("samples", len(l.samples))
+	}
+	p95, ok := l.p95()
+	if !ok || p95 != time.Duration(100+LatencySamples*95/100+1) {
+		t.Fatal("p95", p95)
+	}
+}
+
+func TestRetryFinish(t *testing.T) {
+	failCount, openDur := BreakerFailCount, BreakerOpenDur
+	BreakerFailCount, BreakerOpenDur = 1, 10*time.Millisecond
+	defer func() {
+		BreakerFailCount, BreakerOpenDur = failCount, openDur
+	}()
+	node := testDropNode()
+	var canceled []int64
+	node.toNode = func(nodeId int64, bytes []byte) {
+		if bytes[0] == HdCancel {
+			canceled = append(canceled, nodeId)
+		}
+	}
+	const nodeA, nodeB = 201, 202
+	for _, id := range []int64{nodeA, nodeB} {
+		addNodeBreaker(1, id)
+		defer delNodeBreaker(id)
+		reportNode(id, util.EcTimeout)
+	}
+	time.Sleep(BreakerOpenDur)
+	//两个节点都在半开探测中,先发送的对冲尝试没有响应
+	r := &reqRetry{tid: 1}
+	for _, id := range []int64{nodeA, nodeB} {
+		if !nodeReady(id) {
+			t.Fatal("not half open", id)
+		}
+		acquireNode(id)
+		r.addTried(id)
+	}
+	r.finish(nodeB, 0)
+	if !nodeReady(nodeA) || NodeBreakerState(nodeA) != kiwi.BreakerHalfOpen {
+		t.Fatal("probe not released", NodeBreakerState(nodeA))
+	}
+	if NodeBreakerState(nodeB) != kiwi.BreakerClosed {
+		t.Fatal("winner", NodeBreakerState(nodeB))
+	}
+	if len(canceled) != 1 || canceled[0] != nodeA {
+		t.Fatal("cancel", canceled)
+	}
+	//已经报告过的节点不再报告
+	r.finish(nodeB, util.EcTimeout)
+	if NodeBreakerState(nodeB) != kiwi.BreakerClosed {
+		t.Fatal("reported twice", NodeBreakerState(nodeB))
+	}
+}
+
+func TestRetryWait(t *testing.T) {
+	const nodeA, nodeB = 211, 212
+	r := &reqRetry{
+		policy: &kiwi.RetryPolicy{
+			MaxAttempts: 2,
+		},
+		attempts: 2,
+		deadline: time.Now().Add(time.Second),
+	}
+	r.addTried(nodeA)
+	r.addTried(nodeB)
+	//对冲后A先失败,B还没有结果
+	if !r.retry(nodeA, util.EcUnavailable) {
+		t.Fatal("not wait")
+	}
+	if len(r.pending) != 1 || r.pending[0] != nodeB {
+		t.Fatal("pending", r.pending)
+	}
+	//所有尝试都失败后结束,B留给finish报告
+	if r.retry(nodeB, util.EcUnavailable) {
+		t.Fatal("wait")
+	}
+	if len(r.pending) != 1 {
+		t.Fatal("pending", r.pending)
+	}
+}
+
+func TestRequestCancel(t *testing.T) {
+	pkt := NewRcvReqPkt()
+	pkt.tid = 221
+	pkt.head = util.M{HeadRetry: true}
+	watchCancel(pkt)
+	if err := cancelRequest(packCancel(pkt.tid)); err != nil {
+		t.Fatal(err)
+	}
+	if !isExpired(pkt) {
+		t.Fatal("not canceled")
+	}
+	if _, ok := _RetryRequests.Load(pkt.tid); ok {
+		t.Fatal("not removed")
+	}
+}
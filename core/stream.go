@@ -0,0 +1,629 @@
+package core
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/sid"
+	"github.com/15mga/kiwi/util"
+)
+
+var (
+	// StreamWindow 每个方向的额度,接收方处理一半后补充
+	StreamWindow uint32 = 32
+	// StreamIdleDur 超过这个时间既没有收到对端的帧也没有发送消息时以EcTimeout取消,
+	// 服务端流中客户端关闭发送后只有服务端发送,收不到对端的帧
+	StreamIdleDur = time.Minute
+)
+
+var (
+	// 本节点打开的流和收到的流分开保存,同一节点内两端的tid相同
+	_ClientStreams sync.Map
+	_ServerStreams sync.Map
+)
+
+type nodeSelector interface {
+	selectNode(svc kiwi.TSvc, head util.M) (int64, *util.Err)
+}
+
+type stream struct {
+	tid        int64
+	nodeId     int64 //对端节点
+	server     bool
+	svc        kiwi.TSvc
+	code       kiwi.TCode
+	recvCode   kiwi.TCode //接收消息的code,客户端是响应的code
+	head       util.M
+	json       bool
+	msg        util.IMsg
+	ctx        context.Context
+	cancel     context.CancelFunc
+	timer      *time.Timer
+	recvCh     chan util.IMsg
+	wake       chan struct{}
+	consumed   uint32 //只在Recv中使用
+	mtx        sync.Mutex
+	credit     uint32
+	sendEnd    bool
+	recvEnd    bool
+	endCode    uint16 //recvCh关闭前设置
+	canceled   bool
+	cancelCode uint16
+	finished   bool
+}
+
+func newStream(ctx context.Context, tid, nodeId int64, server bool, head util.M, json bool, credit uint32) *stream {
+	s := &stream{
+		tid:    tid,
+		nodeId: nodeId,
+		server: server,
+		head:   head,
+		json:   json,
+		credit: credit,
+		recvCh: make(chan util.IMsg, StreamWindow),
+		wake:   make(chan struct{}, 1),
+	}
+	s.svc, _ = util.MGet[kiwi.TSvc](head, HeadSvc)
+	s.code, _ = util.MGet[kiwi.TCode](head, HeadCode)
+	s.recvCode = s.code
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.timer = time.AfterFunc(StreamIdleDur, func() {
+		kiwi.TW2(s.tid, util.EcTimeout, util.M{
+			"error": "stream idle",
+		})
+		s.abort(util.EcTimeout, true)
+	})
+	return s
+}
+
+func (s *stream) Tid() int64 {
+	return s.tid
+}
+
+func (s *stream) Svc() kiwi.TSvc {
+	return s.svc
+}
+
+func (s *stream) Code() kiwi.TCode {
+	return s.code
+}
+
+func (s *stream) Head() util.M {
+	return s.head
+}
+
+func (s *stream) Json() bool {
+	return s.json
+}
+
+func (s *stream) Msg() util.IMsg {
+	return s.msg
+}
+
+func (s *stream) SenderId() int64 {
+	return s.nodeId
+}
+
+func (s *stream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *stream) Send(msg util.IMsg) *util.Err {
+	for {
+		s.mtx.Lock()
+		if s.canceled {
+			code := s.cancelCode
+			s.mtx.Unlock()
+			return util.NewErr(code, util.M{
+				"tid": s.tid,
+			})
+		}
+		if s.sendEnd {
+			s.mtx.Unlock()
+			return util.NewErr(util.EcClosed, util.M{
+				"tid": s.tid,
+			})
+		}
+		if s.credit > 0 {
+			s.credit--
+			s.mtx.Unlock()
+			s.timer.Reset(StreamIdleDur)
+			break
+		}
+		s.mtx.Unlock()
+		select {
+		case <-s.wake:
+		case <-s.ctx.Done():
+			return util.NewErr(s.doneCode(), util.M{
+				"tid": s.tid,
+			})
+		}
+	}
+	var (
+		payload []byte
+		err     *util.Err
+	)
+	if s.json {
+		payload, err = kiwi.Codec().JsonMarshal(msg)
+	} else {
+		payload, err = kiwi.Codec().PbMarshal(msg)
+	}
+	if err != nil {
+		return err
+	}
+	s.sendFrame(packStreamData(s.tid, !s.server, payload))
+	return nil
+}
+
+func (s *stream) Recv() (util.IMsg, uint16) {
+	select {
+	case msg, ok := <-s.recvCh:
+		return s.received(msg, ok)
+	case <-s.ctx.Done():
+		s.mtx.Lock()
+		canceled := s.canceled
+		s.mtx.Unlock()
+		if !canceled {
+			//正常结束时recvCh已经关闭,先取出剩余的消息
+			select {
+			case msg, ok := <-s.recvCh:
+				return s.received(msg, ok)
+			default:
+			}
+		}
+		return nil, s.doneCode()
+	}
+}
+
+func (s *stream) received(msg util.IMsg, ok bool) (util.IMsg, uint16) {
+	if !ok {
+		return nil, s.endCode
+	}
+	s.consumed++
+	if s.consumed >= StreamWindow>>1 {
+		s.mtx.Lock()
+		active := !s.recvEnd && !s.canceled
+		s.mtx.Unlock()
+		if active {
+			s.sendFrame(packStreamCredit(s.tid, !s.server, s.consumed))
+		}
+		s.consumed = 0
+	}
+	return msg, 0
+}
+
+func (s *stream) CloseSend(code uint16) {
+	s.mtx.Lock()
+	if s.sendEnd || s.canceled {
+		s.mtx.Unlock()
+		return
+	}
+	s.sendEnd = true
+	done := s.recvEnd
+	s.mtx.Unlock()
+	s.sendFrame(packStreamCode(HdStreamEnd, s.tid, !s.server, code))
+	if done {
+		s.finish()
+	}
+}
+
+func (s *stream) Cancel(code uint16) {
+	s.abort(code, true)
+}
+
+func (s *stream) doneCode() uint16 {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.canceled {
+		return s.cancelCode
+	}
+	if s.ctx.Err() == context.DeadlineExceeded {
+		return util.EcTimeout
+	}
+	if s.finished {
+		return util.EcClosed
+	}
+	return util.EcCanceled
+}
+
+// abort notify为true时通知对端
+func (s *stream) abort(code uint16, notify bool) {
+	s.mtx.Lock()
+	if s.canceled || s.finished {
+		s.mtx.Unlock()
+		return
+	}
+	s.canceled = true
+	s.cancelCode = code
+	s.mtx.Unlock()
+	if notify {
+		s.sendFrame(packStreamCode(HdStreamCancel, s.tid, !s.server, code))
+	}
+	s.finish()
+}
+
+func (s *stream) finish() {
+	s.mtx.Lock()
+	s.finished = true
+	s.mtx.Unlock()
+	if s.server {
+		_ServerStreams.CompareAndDelete(s.tid, s)
+		kiwi.UnbindTraceCtx(s.tid)
+	} else {
+		_ClientStreams.CompareAndDelete(s.tid, s)
+	}
+	s.timer.Stop()
+	s.cancel()
+}
+
+func (s *stream) onData(payload []byte) {
+	var (
+		msg util.IMsg
+		err *util.Err
+	)
+	if s.json {
+		msg, err = kiwi.Codec().JsonUnmarshal2(s.svc, s.recvCode, payload)
+	} else {
+		msg, err = kiwi.Codec().PbUnmarshal2(s.svc, s.recvCode, payload)
+	}
+	if err != nil {
+		kiwi.TE(s.tid, err)
+		s.abort(err.Code(), true)
+		return
+	}
+	s.mtx.Lock()
+	if s.recvEnd || s.canceled {
+		s.mtx.Unlock()
+		return
+	}
+	select {
+	case s.recvCh <- msg:
+		s.mtx.Unlock()
+	default:
+		s.mtx.Unlock()
+		//对端没有遵守额度
+		s.abort(util.EcTooMuch, true)
+	}
+}
+
+func (s *stream) onEnd(code uint16) {
+	s.mtx.Lock()
+	if s.recvEnd || s.canceled {
+		s.mtx.Unlock()
+		return
+	}
+	s.recvEnd = true
+	s.endCode = code
+	close(s.recvCh)
+	done := s.sendEnd
+	s.mtx.Unlock()
+	if done {
+		s.finish()
+	}
+}
+
+func (s *stream) onCredit(n uint32) {
+	s.mtx.Lock()
+	s.credit += n
+	s.mtx.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *stream) sendFrame(bytes []byte) {
+	if s.nodeId == kiwi.GetNodeMeta().NodeId {
+		err := receiveStream(bytes)
+		if err != nil {
+			kiwi.TE(s.tid, err)
+		}
+		return
+	}
+	kiwi.Node().SendToNode(s.nodeId, bytes, func(err *util.Err) {
+		if err == nil {
+			return
+		}
+		kiwi.TE(s.tid, err)
+		s.abort(util.EcSendErr, false)
+	})
+}
+
+// OpenStream 打开流,msg是第一条请求并决定svc、code,按负载均衡选择节点;
+// ctx结束时取消流
+func OpenStream(ctx context.Context, pid int64, head util.M, msg util.IMsg) (kiwi.IStream, *util.Err) {
+	return OpenStreamNode(ctx, 0, pid, head, msg)
+}
+
+// OpenStreamNode nodeId为0时按负载均衡选择节点
+func OpenStreamNode(ctx context.Context, nodeId, pid int64, head util.M, msg util.IMsg) (kiwi.IStream, *util.Err) {
+	payload, err := kiwi.Codec().PbMarshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	svc, code := kiwi.Codec().MsgToSvcCode(msg)
+	return openStream(ctx, nodeId, pid, svc, code, head, false, payload)
+}
+
+// OpenStreamBytes payload为空时不发送第一条请求
+func OpenStreamBytes(ctx context.Context, nodeId, pid int64, svc kiwi.TSvc, code kiwi.TCode, head util.M, json bool, payload []byte) (kiwi.IStream, *util.Err) {
+	return openStream(ctx, nodeId, pid, svc, code, head, json, payload)
+}
+
+func openStream(ctx context.Context, nodeId, pid int64, svc kiwi.TSvc, code kiwi.TCode, head util.M, json bool, payload []byte) (kiwi.IStream, *util.Err) {
+	resCode, err := kiwi.Codec().ReqToResCode(svc, code)
+	if err != nil {
+		return nil, err
+	}
+	head = sndHead(head)
+	GenHead(head)
+	head.Set(HeadSvc, svc)
+	head.Set(HeadCode, code)
+	selfId := kiwi.GetNodeMeta().NodeId
+	head.Set(HeadSndId, selfId)
+	head.Set(HeadSndTs, time.Now().UnixMilli())
+	if deadline, ok := ctx.Deadline(); ok {
+		head.Set(HeadDeadline, deadline.UnixMilli())
+	}
+	if nodeId == 0 {
+		nodeId = selfId
+		if s, ok := kiwi.Node().(nodeSelector); ok && !kiwi.GetNodeMeta().HasService(svc) {
+			nodeId, err = s.selectNode(svc, head)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	tid := sid.GetId()
+	tid = kiwi.TCId(pid, tid, head, !logTid(svc, code, tid))
+	s := newStream(ctx, tid, nodeId, false, head, json, 0)
+	s.recvCode = resCode
+	_ClientStreams.Store(tid, s)
+	bytes, err := packStreamOpen(tid, head, json, StreamWindow, payload)
+	if err != nil {
+		s.finish()
+		return nil, err
+	}
+	go func() {
+		//ctx结束时通知服务端
+		<-s.ctx.Done()
+		s.mtx.Lock()
+		finished := s.finished
+		s.mtx.Unlock()
+		if !finished {
+			s.abort(s.doneCode(), true)
+		}
+	}()
+	s.sendFrame(bytes)
+	return s, nil
+}
+
+// StreamRecv 接收对端的消息,结束时ok为false,code为结束码
+func StreamRecv[T util.IMsg](s kiwi.IStream) (msg T, ok bool, code uint16) {
+	m, code := s.Recv()
+	if m == nil {
+		return
+	}
+	msg, ok = m.(T)
+	if !ok {
+		s.Cancel(util.EcWrongType)
+		code = util.EcWrongType
+	}
+	return
+}
+
+// ServerStream 服务端流,fn返回false时取消,返回服务端的结束码
+func ServerStream[ResT util.IMsg](ctx context.Context, pid int64, head util.M, req util.IMsg, fn func(ResT) bool) uint16 {
+	s, err := OpenStream(ctx, pid, head, req)
+	if err != nil {
+		return err.Code()
+	}
+	s.CloseSend(0)
+	for {
+		res, ok, code := StreamRecv[ResT](s)
+		if !ok {
+			return code
+		}
+		if !fn(res) {
+			s.Cancel(util.EcCanceled)
+			return util.EcCanceled
+		}
+	}
+}
+
+// ClientStream 客户端流,first打开流,之后发送next的消息直到返回false,返回服务端的响应
+func ClientStream[ResT util.IMsg](ctx context.Context, pid int64, head util.M, first util.IMsg, next func() (util.IMsg, bool)) (ResT, uint16) {
+	var res ResT
+	s, err := OpenStream(ctx, pid, head, first)
+	if err != nil {
+		return res, err.Code()
+	}
+	for {
+		msg, ok := next()
+		if !ok {
+			break
+		}
+		err = s.Send(msg)
+		if err != nil {
+			return res, err.Code()
+		}
+	}
+	s.CloseSend(0)
+	res, ok, code := StreamRecv[ResT](s)
+	if !ok {
+		if code == 0 {
+			code = util.EcEmpty
+		}
+		return res, code
+	}
+	for {
+		_, ok, _ = StreamRecv[util.IMsg](s)
+		if !ok {
+			break
+		}
+	}
+	return res, 0
+}
+
+func packStreamOpen(tid int64, head util.M, json bool, credit uint32, payload []byte) ([]byte, *util.Err) {
+	var buffer util.ByteBuffer
+	buffer.InitCap(256 + len(payload))
+	buffer.WUint8(HdStreamOpen)
+	buffer.WInt64(tid)
+	err := buffer.WMAny(head)
+	if err != nil {
+		return nil, err
+	}
+	buffer.WBool(json)
+	buffer.WUint32(credit)
+	_, _ = buffer.Write(payload)
+	return buffer.All(), nil
+}
+
+func packStreamData(tid int64, toServer bool, payload []byte) []byte {
+	var buffer util.ByteBuffer
+	buffer.InitCap(10 + len(payload))
+	buffer.WUint8(HdStreamData)
+	buffer.WInt64(tid)
+	buffer.WBool(toServer)
+	_, _ = buffer.Write(payload)
+	return buffer.All()
+}
+
+func packStreamCode(hd uint8, tid int64, toServer bool, code uint16) []byte {
+	var buffer util.ByteBuffer
+	buffer.InitCap(12)
+	buffer.WUint8(hd)
+	buffer.WInt64(tid)
+	buffer.WBool(toServer)
+	buffer.WUint16(code)
+	return buffer.All()
+}
+
+func packStreamCredit(tid int64, toServer bool, credit uint32) []byte {
+	var buffer util.ByteBuffer
+	buffer.InitCap(14)
+	buffer.WUint8(HdStreamCredit)
+	buffer.WInt64(tid)
+	buffer.WBool(toServer)
+	buffer.WUint32(credit)
+	return buffer.All()
+}
+
+func (n *nodeBase) onStream(agent kiwi.IAgent, bytes []byte) {
+	err := receiveStream(bytes)
+	if err != nil {
+		if agent != nil {
+			err.AddParam("addr", agent.Addr())
+		}
+		kiwi.Error(err)
+	}
+}
+
+func receiveStream(bytes []byte) *util.Err {
+	var buffer util.ByteBuffer
+	buffer.InitBytes(bytes)
+	buffer.SetPos(1)
+	tid, err := buffer.RInt64()
+	if err != nil {
+		return err
+	}
+	if bytes[0] == HdStreamOpen {
+		return onStreamOpen(tid, &buffer)
+	}
+	toServer, err := buffer.RBool()
+	if err != nil {
+		return err
+	}
+	m := &_ClientStreams
+	if toServer {
+		m = &_ServerStreams
+	}
+	v, ok := m.Load(tid)
+	if !ok {
+		//已经结束的流
+		return nil
+	}
+	s := v.(*stream)
+	s.timer.Reset(StreamIdleDur)
+	switch bytes[0] {
+	case HdStreamData:
+		s.onData(buffer.RAvailable())
+	case HdStreamEnd:
+		code, err := buffer.RUint16()
+		if err != nil {
+			return err
+		}
+		s.onEnd(code)
+	case HdStreamCancel:
+		code, err := buffer.RUint16()
+		if err != nil {
+			return err
+		}
+		s.abort(code, false)
+	case HdStreamCredit:
+		credit, err := buffer.RUint32()
+		if err != nil {
+			return err
+		}
+		s.onCredit(credit)
+	}
+	return nil
+}
+
+func onStreamOpen(tid int64, buffer *util.ByteBuffer) *util.Err {
+	head := util.M{}
+	err := buffer.RMAny(head)
+	if err != nil {
+		return err
+	}
+	json, err := buffer.RBool()
+	if err != nil {
+		return err
+	}
+	credit, err := buffer.RUint32()
+	if err != nil {
+		return err
+	}
+	payload := buffer.RAvailable()
+	senderId, _ := util.MGet[int64](head, HeadSndId)
+	ctx := util.Ctx()
+	var cancel context.CancelFunc
+	if deadline, ok := util.MGet[int64](head, HeadDeadline); ok {
+		ctx, cancel = context.WithDeadline(ctx, time.UnixMilli(deadline))
+	}
+	s := newStream(ctx, tid, senderId, true, head, json, credit)
+	go func() {
+		//超过客户端的deadline时结束并通知客户端
+		<-s.ctx.Done()
+		s.mtx.Lock()
+		finished := s.finished
+		s.mtx.Unlock()
+		if !finished {
+			s.abort(s.doneCode(), true)
+		}
+		if cancel != nil {
+			cancel()
+		}
+	}()
+	kiwi.BindTraceCtx(tid, head)
+	logTid(s.svc, s.code, tid)
+	if len(payload) > 0 {
+		if json {
+			s.msg, err = kiwi.Codec().JsonUnmarshal2(s.svc, s.code, payload)
+		} else {
+			s.msg, err = kiwi.Codec().PbUnmarshal2(s.svc, s.code, payload)
+		}
+		if err != nil {
+			s.abort(err.Code(), true)
+			return err
+		}
+	}
+	_ServerStreams.Store(tid, s)
+	//客户端的额度由服务端在打开时给出
+	s.sendFrame(packStreamCredit(tid, false, StreamWindow))
+	kiwi.Router().OnStream(s)
+	return nil
+}
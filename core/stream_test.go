@@ -0,0 +1,180 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestStreamFrame(t *testing.T) {
+	s := newStream(context.Background(), 1001, 1, false, util.M{}, false, 0)
+	_ClientStreams.Store(s.tid, s)
+	defer s.finish()
+
+	err := receiveStream(packStreamCredit(s.tid, false, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.credit != 3 {
+		t.Fatal("credit", s.credit)
+	}
+	//发给服务端的帧不会找到客户端的流
+	err = receiveStream(packStreamCredit(s.tid, true, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.credit != 3 {
+		t.Fatal("credit", s.credit)
+	}
+
+	err = receiveStream(packStreamCode(HdStreamEnd, s.tid, false, util.EcNotExist))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, code := s.Recv()
+	if msg != nil || code != util.EcNotExist {
+		t.Fatal("end", code)
+	}
+
+	if receiveStream(packStreamCredit(s.tid, false, 1)[:5]) == nil {
+		t.Fatal("short frame")
+	}
+}
+
+// TestClientStream 同一节点内客户端发送超过额度的消息
+func TestClientStream(t *testing.T) {
+	testDropNode()
+	kiwi.Codec().BindFac(50, 1, func() util.IMsg {
+		return &wrapperspb.StringValue{}
+	})
+	kiwi.Codec().BindFac(50, 2, func() util.IMsg {
+		return &wrapperspb.Int32Value{}
+	})
+	kiwi.Codec().BindReqToRes(50, 1, 2)
+	kiwi.Router().BindStream(50, 1, func(s kiwi.IRcvStream) {
+		n := int32(0)
+		for {
+			_, ok, _ := StreamRecv[*wrapperspb.StringValue](s)
+			if !ok {
+				break
+			}
+			n++
+		}
+		_ = s.Send(wrapperspb.Int32(n))
+		s.CloseSend(0)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	count := int(StreamWindow) * 3
+	i := 0
+	res, code := ClientStream[*wrapperspb.Int32Value](ctx, 0, nil, wrapperspb.String("first"), func() (util.IMsg, bool) {
+		if i == count {
+			return nil, false
+		}
+		i++
+		return wrapperspb.String("next"), true
+	})
+	if code != 0 || res.GetValue() != int32(count) {
+		t.Fatal("client stream", code, res.GetValue())
+	}
+}
+
+// TestServerStreamSlow 客户端关闭发送后服务端发送间隔小于StreamIdleDur,总时长超过也不超时
+func TestServerStreamSlow(t *testing.T) {
+	testDropNode()
+	idle := StreamIdleDur
+	StreamIdleDur = 50 * time.Millisecond
+	defer func() {
+		StreamIdleDur = idle
+	}()
+	kiwi.Codec().BindFac(52, 1, func() util.IMsg {
+		return &wrapperspb.StringValue{}
+	})
+	kiwi.Codec().BindFac(52, 2, func() util.IMsg {
+		return &wrapperspb.Int32Value{}
+	})
+	kiwi.Codec().BindReqToRes(52, 1, 2)
+	kiwi.Router().BindStream(52, 1, func(s kiwi.IRcvStream) {
+		go func() {
+			for i := int32(0); i < 4; i++ {
+				time.Sleep(20 * time.Millisecond)
+				if s.Send(wrapperspb.Int32(i)) != nil {
+					return
+				}
+			}
+			s.CloseSend(0)
+		}()
+	})
+
+	var slc []int32
+	code := ServerStream[*wrapperspb.Int32Value](context.Background(), 0, nil, wrapperspb.String("watch"), func(res *wrapperspb.Int32Value) bool {
+		slc = append(slc, res.GetValue())
+		return true
+	})
+	if code != 0 || len(slc) != 4 {
+		t.Fatal("server stream", code, slc)
+	}
+}
+
+// TestServerStreamDeadline 超过客户端的deadline时服务端结束流
+func TestServerStreamDeadline(t *testing.T) {
+	n := testDropNode()
+	frames := make(chan []byte, 4)
+	n.toNode = func(_ int64, bytes []byte) {
+		frames <- bytes
+	}
+	kiwi.Codec().BindFac(53, 1, func() util.IMsg {
+		return &wrapperspb.StringValue{}
+	})
+	done := make(chan uint16, 1)
+	kiwi.Router().BindStream(53, 1, func(s kiwi.IRcvStream) {
+		go func() {
+			_, code := s.Recv()
+			done <- code
+		}()
+	})
+	const tid = 1053
+	//客户端在其他节点,服务端只能通过deadline结束
+	head := util.M{
+		HeadSvc:              kiwi.TSvc(53),
+		HeadCode:             kiwi.TCode(1),
+		HeadSndId:            int64(2),
+		HeadDeadline:         time.Now().Add(20 * time.Millisecond).UnixMilli(),
+		kiwi.HeadTraceParent: kiwi.FormatTraceParent(kiwi.NewTraceId(), 1, "01"),
+	}
+	bytes, err := packStreamOpen(tid, head, false, StreamWindow, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = receiveStream(bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := <-done; code != util.EcTimeout {
+		t.Fatal("code", code)
+	}
+	//第一帧是客户端的额度,之后通知客户端取消
+	for {
+		select {
+		case bytes := <-frames:
+			if bytes[0] != HdStreamCancel {
+				continue
+			}
+		case <-time.After(time.Second):
+			t.Fatal("client not notified")
+		}
+		break
+	}
+	if !waitUntil(time.Now().Add(time.Second), func() bool {
+		_, ok := _ServerStreams.Load(int64(tid))
+		_, bound := kiwi.BoundTraceParent(tid)
+		return !ok && !bound
+	}) {
+		t.Fatal("stream left")
+	}
+}
@@ -0,0 +1,28 @@
+package kiwi
+
+import "github.com/15mga/kiwi/util"
+
+// DeadLetter 无法投递的推送和通知
+type DeadLetter struct {
+	Id   int64  `json:"id"`
+	Ts   int64  `json:"ts"`
+	Tid  int64  `json:"tid"`
+	Type uint8  `json:"type"`
+	Svc  TSvc   `json:"svc"`
+	Code TCode  `json:"code"`
+	Head util.M `json:"head"`
+	// Bytes 打包后的数据,重放时直接解包
+	Bytes   []byte `json:"bytes"`
+	ErrCode uint16 `json:"errCode"`
+	Reason  string `json:"reason"`
+}
+
+// IDeadLetter 死信存储,需要支持并发调用
+type IDeadLetter interface {
+	Add(letter *DeadLetter) *util.Err
+	// List 按写入顺序,返回从start开始最多limit条和总数
+	List(start, limit int) ([]*DeadLetter, int, *util.Err)
+	Get(id int64) (*DeadLetter, *util.Err)
+	// Del 不存在的id忽略
+	Del(ids ...int64) *util.Err
+}
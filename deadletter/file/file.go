@@ -0,0 +1,274 @@
+package file
+
+import (
+	"bufio"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+	"github.com/15mga/kiwi/worker"
+)
+
+type option struct {
+	maxLetters int
+	retention  time.Duration
+}
+
+type Option func(o *option)
+
+// MaxLetters 最多保留的死信数量,超过时丢弃最早的,默认100000
+func MaxLetters(max int) Option {
+	return func(o *option) {
+		o.maxLetters = max
+	}
+}
+
+// Retention 死信保留的时长,默认7天,为0时不按时间丢弃
+func Retention(dur time.Duration) Option {
+	return func(o *option) {
+		o.retention = dur
+	}
+}
+
+// New 每行一条json的文件,启动时读入内存,丢弃的死信在文件中累积到与保留的数量相同时重写文件。
+// 文件io在单独的worker中按顺序执行,Add、Del只修改内存,写入失败记录日志,退出前等待写入完成
+func New(path string, opts ...Option) (kiwi.IDeadLetter, *util.Err) {
+	o := &option{
+		maxLetters: 100000,
+		retention:  7 * 24 * time.Hour,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	s := &store{
+		option: o,
+		path:   path,
+		idToL:  make(map[int64]*kiwi.DeadLetter),
+		writer: worker.NewFnWorker(),
+	}
+	err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	f, e := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if e != nil {
+		return nil, ioErr(path, e)
+	}
+	s.file = f
+	s.writer.Start()
+	if s.trim(time.Now().UnixMilli()) {
+		s.compact()
+	}
+	kiwi.BeforeExitFn("dead letter file", s.close)
+	return s, nil
+}
+
+type store struct {
+	*option
+	mtx     sync.Mutex
+	path    string
+	letters []*kiwi.DeadLetter
+	idToL   map[int64]*kiwi.DeadLetter
+	lines   int //文件中的行数,包括已经丢弃的
+	writer  *worker.FnWorker
+	file    *os.File //只在writer中访问
+}
+
+func (s *store) load() *util.Err {
+	f, e := os.Open(s.path)
+	if e != nil {
+		if os.IsNotExist(e) {
+			return nil
+		}
+		return ioErr(s.path, e)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		letter := &kiwi.DeadLetter{}
+		err := util.JsonUnmarshal(line, letter)
+		if err != nil {
+			//写入一半的行,跳过
+			kiwi.Warn(err)
+			continue
+		}
+		s.letters = append(s.letters, letter)
+		s.idToL[letter.Id] = letter
+		s.lines++
+	}
+	if e = scanner.Err(); e != nil {
+		return ioErr(s.path, e)
+	}
+	return nil
+}
+
+func (s *store) Add(letter *kiwi.DeadLetter) *util.Err {
+	bytes, err := util.JsonMarshal(letter)
+	if err != nil {
+		return err
+	}
+	bytes = append(bytes, '\n')
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.letters = append(s.letters, letter)
+	s.idToL[letter.Id] = letter
+	s.lines++
+	s.writer.Push(s.append, bytes)
+	if s.trim(time.Now().UnixMilli()) {
+		s.compact()
+	}
+	return nil
+}
+
+// trim 丢弃超出数量和保留时长的死信,返回是否需要重写文件
+func (s *store) trim(now int64) bool {
+	i := 0
+	if s.maxLetters > 0 && len(s.letters) > s.maxLetters {
+		i = len(s.letters) - s.maxLetters
+	}
+	if s.retention > 0 {
+		ts := now - s.retention.Milliseconds()
+		for i < len(s.letters) && s.letters[i].Ts < ts {
+			i++
+		}
+	}
+	if i > 0 {
+		for _, letter := range s.letters[:i] {
+			delete(s.idToL, letter.Id)
+		}
+		s.letters = append([]*kiwi.DeadLetter(nil), s.letters[i:]...)
+	}
+	stale := s.lines - len(s.letters)
+	return stale > 0 && stale >= len(s.letters)
+}
+
+func (s *store) List(start, limit int) ([]*kiwi.DeadLetter, int, *util.Err) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	total := len(s.letters)
+	if start < 0 || start >= total || limit <= 0 {
+		return nil, total, nil
+	}
+	end := start + limit
+	if end > total {
+		end = total
+	}
+	slc := make([]*kiwi.DeadLetter, end-start)
+	copy(slc, s.letters[start:end])
+	return slc, total, nil
+}
+
+func (s *store) Get(id int64) (*kiwi.DeadLetter, *util.Err) {
+	s.mtx.Lock()
+	letter, ok := s.idToL[id]
+	s.mtx.Unlock()
+	if !ok {
+		return nil, util.NewErr(util.EcNotExist, util.M{
+			"id": id,
+		})
+	}
+	return letter, nil
+}
+
+func (s *store) Del(ids ...int64) *util.Err {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	n := len(s.idToL)
+	for _, id := range ids {
+		delete(s.idToL, id)
+	}
+	if n == len(s.idToL) {
+		return nil
+	}
+	letters := make([]*kiwi.DeadLetter, 0, len(s.idToL))
+	for _, letter := range s.letters {
+		if _, ok := s.idToL[letter.Id]; ok {
+			letters = append(letters, letter)
+		}
+	}
+	s.letters = letters
+	s.compact()
+	return nil
+}
+
+// compact 在持有mtx时调用,按当前的死信重写文件
+func (s *store) compact() {
+	s.lines = len(s.letters)
+	s.writer.Push(s.rewrite, append([]*kiwi.DeadLetter(nil), s.letters...))
+}
+
+func (s *store) append(params []any) {
+	_, e := s.file.Write(params[0].([]byte))
+	if e != nil {
+		kiwi.Error(ioErr(s.path, e))
+	}
+}
+
+func (s *store) rewrite(params []any) {
+	err := s.rewriteFile(params[0].([]*kiwi.DeadLetter))
+	if err != nil {
+		kiwi.Error(err)
+	}
+}
+
+// rewriteFile 先写临时文件再替换,中途失败不影响原文件
+func (s *store) rewriteFile(letters []*kiwi.DeadLetter) *util.Err {
+	tmp := s.path + ".tmp"
+	f, e := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if e != nil {
+		return ioErr(tmp, e)
+	}
+	w := bufio.NewWriter(f)
+	for _, letter := range letters {
+		bytes, err := util.JsonMarshal(letter)
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+		_, _ = w.Write(bytes)
+		_ = w.WriteByte('\n')
+	}
+	e = w.Flush()
+	if e == nil {
+		e = f.Close()
+	} else {
+		_ = f.Close()
+	}
+	if e != nil {
+		return ioErr(tmp, e)
+	}
+	e = os.Rename(tmp, s.path)
+	if e != nil {
+		return ioErr(s.path, e)
+	}
+	_ = s.file.Close()
+	s.file, e = os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if e != nil {
+		return ioErr(s.path, e)
+	}
+	return nil
+}
+
+// close 等待排队的写入完成后关闭文件
+func (s *store) close() {
+	ch := make(chan struct{})
+	s.writer.Push(func([]any) {
+		_ = s.file.Close()
+		close(ch)
+	})
+	<-ch
+}
+
+func ioErr(path string, e error) *util.Err {
+	return util.NewErr(util.EcIo, util.M{
+		"path":  path,
+		"error": e.Error(),
+	})
+}
@@ -0,0 +1,87 @@
+package file
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/15mga/kiwi"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead_letter.log")
+	s, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(1); i <= 3; i++ {
+		err = s.Add(&kiwi.DeadLetter{
+			Id:    i,
+			Ts:    time.Now().UnixMilli(),
+			Tid:   i * 10,
+			Bytes: []byte{1, 2, byte(i)},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = s.Del(2, 4)
+	s.(*store).close()
+	s, err = New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slc, total, _ := s.List(0, 10)
+	if total != 2 || len(slc) != 2 || slc[0].Id != 1 || slc[1].Id != 3 {
+		t.Fatal(total, slc)
+	}
+	letter, err := s.Get(3)
+	if err != nil || letter.Tid != 30 || letter.Bytes[2] != 3 {
+		t.Fatal(letter, err)
+	}
+	if _, err = s.Get(2); err == nil {
+		t.Fatal("deleted")
+	}
+	slc, _, _ = s.List(1, 10)
+	if len(slc) != 1 || slc[0].Id != 3 {
+		t.Fatal(slc)
+	}
+}
+
+func TestStoreLimit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead_letter.log")
+	s, err := New(path, MaxLetters(3), Retention(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UnixMilli()
+	_ = s.Add(&kiwi.DeadLetter{
+		Id: 100,
+		Ts: now - 2*time.Hour.Milliseconds(),
+	})
+	if _, total, _ := s.List(0, 10); total != 0 {
+		t.Fatal("retention", total)
+	}
+	for i := int64(1); i <= 6; i++ {
+		_ = s.Add(&kiwi.DeadLetter{
+			Id: i,
+			Ts: now,
+		})
+	}
+	s.(*store).close()
+	//丢弃的死信累积后重写文件
+	data, _ := os.ReadFile(path)
+	if n := bytes.Count(data, []byte{'\n'}); n != 3 {
+		t.Fatal("lines", n)
+	}
+	s, err = New(path, MaxLetters(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	slc, total, _ := s.List(0, 10)
+	if total != 3 || slc[0].Id != 4 || slc[2].Id != 6 {
+		t.Fatal(total, slc)
+	}
+}
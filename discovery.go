@@ -0,0 +1,32 @@
+package kiwi
+
+import "github.com/15mga/kiwi/util"
+
+type (
+	FnNodeMeta  func(meta *NodeMeta)
+	FnSvcNodeId func(svc TSvc, nodeId int64)
+)
+
+var (
+	_Discovery IDiscovery
+)
+
+func Discovery() IDiscovery {
+	return _Discovery
+}
+
+func SetDiscovery(discovery IDiscovery) {
+	_Discovery = discovery
+}
+
+// IDiscovery 服务发现
+type IDiscovery interface {
+	// Register 注册节点,发现服务可以重新分配NodeId
+	Register(meta *NodeMeta) *util.Err
+	// Update 更新已注册的节点信息,比如排空状态
+	Update(meta *NodeMeta) *util.Err
+	// Unregister 注销节点
+	Unregister()
+	// Watch 监听其他节点,先回调已存在的节点,再回调变化,不包括自己
+	Watch(onPut FnNodeMeta, onDel FnSvcNodeId)
+}
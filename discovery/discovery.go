@@ -0,0 +1,63 @@
+package discovery
+
+import (
+	"sync"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+)
+
+// Start 注册本节点并连接发现的节点,排空时更新,排空完成后注销,
+// 没有通过BeforeExitDrain排空时在退出前注销
+func Start(discovery kiwi.IDiscovery) *util.Err {
+	meta := kiwi.GetNodeMeta()
+	kiwi.DispatchEvent(kiwi.Evt_Node_Register, meta)
+	err := discovery.Register(meta)
+	if err != nil {
+		return err
+	}
+	kiwi.SetDiscovery(discovery)
+	kiwi.Info("register node success", util.M{
+		"node": meta.NodeId,
+		"meta": meta,
+	})
+	kiwi.BindEvent(kiwi.Evt_Node_Draining, func(_ util.M, _ any) {
+		err := discovery.Update(meta)
+		if err != nil {
+			kiwi.Error(err)
+		}
+	})
+	var once sync.Once
+	unregister := func() {
+		once.Do(func() {
+			kiwi.Info("unregister node", util.M{
+				"node": meta.NodeId,
+			})
+			discovery.Unregister()
+		})
+	}
+	kiwi.BindEvent(kiwi.Evt_Node_Drained, func(_ util.M, _ any) {
+		unregister()
+	})
+	//排空时由Evt_Node_Drained注销
+	kiwi.BeforeExitFn("node unregister", func() {
+		if !kiwi.ExitDrain() {
+			unregister()
+		}
+	})
+	discovery.Watch(Connect, Disconnect)
+	return nil
+}
+
+func Connect(meta *kiwi.NodeMeta) {
+	for svc, ver := range meta.Services {
+		kiwi.Node().Connect(meta.Ip, meta.Port, svc, meta.NodeId, ver, meta.Data)
+	}
+	if meta.Draining {
+		kiwi.Node().Drain(meta.NodeId)
+	}
+}
+
+func Disconnect(svc kiwi.TSvc, nodeId int64) {
+	kiwi.Node().Disconnect(svc, nodeId)
+}
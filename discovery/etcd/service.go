@@ -3,8 +3,10 @@ package etcd
 import (
 	"fmt"
 	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/discovery"
 	"github.com/15mga/kiwi/util"
 	"github.com/15mga/kiwi/util/etd"
+	"github.com/bwmarrin/snowflake"
 	"go.etcd.io/etcd/api/v3/mvccpb"
 	etcd "go.etcd.io/etcd/client/v3"
 	"strconv"
@@ -16,7 +18,6 @@ var (
 	RegTtl           int64 = 5
 	_RegRoot               = ""
 	_RegSvcIdxOffset       = 1
-	_SvcLeaseId      int64
 )
 
 func SetRegRoot(root string) {
@@ -32,92 +33,117 @@ func RegLockPrefix() string {
 	return _RegRoot + "node.lock"
 }
 
+// RegisterService 使用etcd注册并发现节点
 func RegisterService() {
-	//退出前注销
-	kiwi.BeforeExitFn("service unregister", unregisterSvc)
-	registerSvc()
-	go func() {
-		svcWatch := etd.Client().Watch(util.Ctx(), RegSvcPrefix(), etcd.WithPrefix())
-		for {
-			select {
-			case <-util.Ctx().Done():
-				return
-			case info := <-svcWatch:
-				rcvSvcEvent(info)
-			}
-		}
-	}()
+	err := discovery.Start(New())
+	if err != nil {
+		kiwi.Error(err)
+	}
+}
+
+func New() kiwi.IDiscovery {
+	return &etcdDiscovery{}
+}
+
+type etcdDiscovery struct {
+	leaseId int64
+	nodeId  int64
+	rev     int64
+	nodes   []*kiwi.NodeMeta
 }
 
-func registerSvc() {
-	svcSlc := make([]*kiwi.NodeMeta, 0, 8)
-	err := etd.Lock(RegLockPrefix(), func() *util.Err {
+func (d *etcdDiscovery) Register(meta *kiwi.NodeMeta) *util.Err {
+	return etd.Lock(RegLockPrefix(), func() *util.Err {
+		res, e := etd.Client().Get(util.Ctx(), RegSvcPrefix(), etcd.WithPrefix())
+		if e != nil {
+			return util.WrapErr(util.EcEtcdErr, e)
+		}
+		d.rev = res.Header.Revision
 		nodeIdMap := make(map[int64]struct{}, 8)
-		_, e := etd.Get(RegSvcPrefix(), func(key string, bytes []byte) bool {
+		for _, kv := range res.Kvs {
 			var si kiwi.NodeMeta
-			err := util.JsonUnmarshal(bytes, &si)
+			err := util.JsonUnmarshal(kv.Value, &si)
 			if err != nil {
-				return true
+				continue
 			}
-			nodeIdMap[si.NodeId] = struct{}{}
-			svcSlc = append(svcSlc, &si)
-			return true
-		}, etcd.WithPrefix())
-		if e != nil {
-			return util.WrapErr(util.EcEtcdErr, e)
+			nodeIdMap[snowflake.ParseInt64(si.NodeId).Node()] = struct{}{}
+			d.nodes = append(d.nodes, &si)
 		}
-		nodeId := int64(0)
-		info := kiwi.GetNodeMeta()
-		for ; nodeId < 1024; nodeId++ {
-			if _, ok := nodeIdMap[nodeId]; !ok {
-				info.SetSvcId(nodeId)
+		idx := int64(1)
+		for ; idx < 1024; idx++ {
+			if _, ok := nodeIdMap[idx]; !ok {
+				meta.Init(idx)
 				break
 			}
 		}
-		if nodeId == 0 {
+		if idx == 1024 {
 			return util.NewErr(util.EcServiceErr, util.M{
 				"error": "too much service node",
 			})
 		}
+		d.nodeId = meta.NodeId
 
-		bytes, _ := util.JsonMarshal(info)
-		str := string(bytes)
-		key := getRegSvcKey(info.Svc, info.NodeId)
-		leaseId, err := etd.PutWithTtl(key, str, RegTtl)
+		leaseId, err := etd.Grant(RegTtl)
 		if err != nil {
 			return err
 		}
-		atomic.StoreInt64(&_SvcLeaseId, leaseId)
-		kiwi.Info("register service success", util.M{
-			"node":         leaseId,
-			"lease id":     leaseId,
-			"service info": info,
-		})
+		atomic.StoreInt64(&d.leaseId, leaseId)
+		return d.Update(meta)
+	})
+}
 
+// Update 每个服务一个key,共用一个租约
+func (d *etcdDiscovery) Update(meta *kiwi.NodeMeta) *util.Err {
+	leaseId := atomic.LoadInt64(&d.leaseId)
+	if leaseId == 0 {
 		return nil
-	})
+	}
+	bytes, err := util.JsonMarshal(meta)
 	if err != nil {
-		kiwi.Error(err)
-		return
+		return err
 	}
-
-	for _, si := range svcSlc {
-		kiwi.Node().Connect(si.Ip, si.Port, si.Svc, si.SvcId, si.Ver, si.Data)
+	str := string(bytes)
+	for svc := range meta.Services {
+		err = etd.PutWithLease(getRegSvcKey(svc, meta.NodeId), str, leaseId)
+		if err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-func unregisterSvc() {
-	id := atomic.SwapInt64(&_SvcLeaseId, 0)
+func (d *etcdDiscovery) Unregister() {
+	id := atomic.SwapInt64(&d.leaseId, 0)
 	if id == 0 {
 		return
 	}
-	kiwi.Info("unregister service", util.M{
-		"info": kiwi.GetNodeMeta(),
-	})
 	_ = etd.Revoke(id)
 }
 
-func rcvSvcEvent(res etcd.WatchResponse) {
+func (d *etcdDiscovery) Watch(onPut kiwi.FnNodeMeta, onDel kiwi.FnSvcNodeId) {
+	for _, si := range d.nodes {
+		if si.NodeId != d.nodeId {
+			onPut(si)
+		}
+	}
+	d.nodes = nil
+	go func() {
+		svcWatch := etd.Client().Watch(util.Ctx(), RegSvcPrefix(), etcd.WithPrefix(), etcd.WithRev(d.rev+1))
+		for {
+			select {
+			case <-util.Ctx().Done():
+				return
+			case res, ok := <-svcWatch:
+				if !ok {
+					return
+				}
+				d.onEvent(res, onPut, onDel)
+			}
+		}
+	}()
+}
+
+func (d *etcdDiscovery) onEvent(res etcd.WatchResponse, onPut kiwi.FnNodeMeta, onDel kiwi.FnSvcNodeId) {
 	for _, event := range res.Events {
 		switch event.Type {
 		case mvccpb.PUT:
@@ -125,23 +151,22 @@ func rcvSvcEvent(res etcd.WatchResponse) {
 			err := util.JsonUnmarshal(event.Kv.Value, &si)
 			if err != nil {
 				kiwi.Error(err)
-				return
+				continue
 			}
-			if si.SvcId == kiwi.GetNodeMeta().NodeId {
-				return
+			if si.NodeId == d.nodeId {
+				continue
 			}
-			kiwi.Node().Connect(si.Ip, si.Port, si.Svc, si.SvcId, si.Ver, si.Data)
+			onPut(&si)
 		case mvccpb.DELETE:
-			key := string(event.Kv.Key)
-			svc, id, err := splitRegSvcKey(key)
+			svc, id, err := splitRegSvcKey(string(event.Kv.Key))
 			if err != nil {
 				kiwi.Error(err)
-				return
+				continue
 			}
-			if id == kiwi.GetNodeMeta().NodeId {
-				return
+			if id == d.nodeId {
+				continue
 			}
-			kiwi.Node().Disconnect(svc, id)
+			onDel(svc, id)
 		}
 	}
 }
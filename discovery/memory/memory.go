@@ -0,0 +1,181 @@
+package memory
+
+import (
+	"sync"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+)
+
+var (
+	_Default = NewRegistry()
+)
+
+// New 使用默认注册表
+func New() kiwi.IDiscovery {
+	return _Default.New()
+}
+
+// NewRegistry 进程内注册表,同一进程运行多个节点时共用
+func NewRegistry() *Registry {
+	return &Registry{
+		idToMeta:  make(map[int64]*kiwi.NodeMeta, 8),
+		idToWatch: make(map[int64]*memDiscovery, 8),
+	}
+}
+
+type Registry struct {
+	mtx       sync.Mutex
+	lastId    int64
+	idToMeta  map[int64]*kiwi.NodeMeta
+	idToWatch map[int64]*memDiscovery
+}
+
+func (r *Registry) New() kiwi.IDiscovery {
+	return &memDiscovery{
+		registry: r,
+	}
+}
+
+// Nodes 已注册节点的快照
+func (r *Registry) Nodes() []*kiwi.NodeMeta {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	slc := make([]*kiwi.NodeMeta, 0, len(r.idToMeta))
+	for _, meta := range r.idToMeta {
+		slc = append(slc, meta)
+	}
+	return slc
+}
+
+func (r *Registry) put(meta *kiwi.NodeMeta) {
+	r.mtx.Lock()
+	meta, watchers := r.putLocked(meta)
+	r.mtx.Unlock()
+	notifyPut(meta, watchers)
+}
+
+func (r *Registry) putLocked(meta *kiwi.NodeMeta) (*kiwi.NodeMeta, []*memDiscovery) {
+	meta = cloneMeta(meta)
+	r.idToMeta[meta.NodeId] = meta
+	return meta, r.watchers(meta.NodeId)
+}
+
+func notifyPut(meta *kiwi.NodeMeta, watchers []*memDiscovery) {
+	for _, w := range watchers {
+		w.onPut(meta)
+	}
+}
+
+func (r *Registry) del(nodeId int64) {
+	r.mtx.Lock()
+	meta, ok := r.idToMeta[nodeId]
+	if !ok {
+		r.mtx.Unlock()
+		return
+	}
+	delete(r.idToMeta, nodeId)
+	delete(r.idToWatch, nodeId)
+	watchers := r.watchers(nodeId)
+	r.mtx.Unlock()
+	for _, w := range watchers {
+		for svc := range meta.Services {
+			w.onDel(svc, nodeId)
+		}
+	}
+}
+
+func (r *Registry) watch(d *memDiscovery) []*kiwi.NodeMeta {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if _, ok := r.idToMeta[d.nodeId]; ok {
+		r.idToWatch[d.nodeId] = d
+	}
+	slc := make([]*kiwi.NodeMeta, 0, len(r.idToMeta))
+	for id, meta := range r.idToMeta {
+		if id != d.nodeId {
+			slc = append(slc, meta)
+		}
+	}
+	return slc
+}
+
+func (r *Registry) watchers(except int64) []*memDiscovery {
+	slc := make([]*memDiscovery, 0, len(r.idToWatch))
+	for id, w := range r.idToWatch {
+		if id != except {
+			slc = append(slc, w)
+		}
+	}
+	return slc
+}
+
+type memDiscovery struct {
+	registry *Registry
+	nodeId   int64
+	onPut    kiwi.FnNodeMeta
+	onDel    kiwi.FnSvcNodeId
+}
+
+// Register NodeId为0时分配id生成器的节点号并初始化NodeId,检查和写入在同一个锁内
+func (d *memDiscovery) Register(meta *kiwi.NodeMeta) *util.Err {
+	r := d.registry
+	r.mtx.Lock()
+	if meta.NodeId == 0 {
+		r.lastId++
+		if r.lastId >= 1024 {
+			r.mtx.Unlock()
+			return util.NewErr(util.EcServiceErr, util.M{
+				"error": "too much service node",
+			})
+		}
+		meta.Init(r.lastId)
+	}
+	if _, ok := r.idToMeta[meta.NodeId]; ok {
+		r.mtx.Unlock()
+		return util.NewErr(util.EcExist, util.M{
+			"node": meta.NodeId,
+		})
+	}
+	d.nodeId = meta.NodeId
+	meta, watchers := r.putLocked(meta)
+	r.mtx.Unlock()
+	notifyPut(meta, watchers)
+	return nil
+}
+
+func (d *memDiscovery) Update(meta *kiwi.NodeMeta) *util.Err {
+	if d.nodeId == 0 {
+		return util.NewErr(util.EcNotExist, util.M{
+			"node": meta.NodeId,
+		})
+	}
+	d.registry.put(meta)
+	return nil
+}
+
+func (d *memDiscovery) Unregister() {
+	if d.nodeId == 0 {
+		return
+	}
+	d.registry.del(d.nodeId)
+}
+
+func (d *memDiscovery) Watch(onPut kiwi.FnNodeMeta, onDel kiwi.FnSvcNodeId) {
+	d.onPut = onPut
+	d.onDel = onDel
+	for _, meta := range d.registry.watch(d) {
+		onPut(meta)
+	}
+}
+
+func cloneMeta(meta *kiwi.NodeMeta) *kiwi.NodeMeta {
+	m := *meta
+	m.Data = util.M{}
+	meta.Data.CopyTo(m.Data)
+	m.Services = make(map[kiwi.TSvc]string, len(meta.Services))
+	for svc, ver := range meta.Services {
+		m.Services[svc] = ver
+	}
+	return &m
+}
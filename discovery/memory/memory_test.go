@@ -0,0 +1,78 @@
+package memory
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+)
+
+func newMeta(svc kiwi.TSvc) *kiwi.NodeMeta {
+	return &kiwi.NodeMeta{
+		Data:     util.M{},
+		Services: map[kiwi.TSvc]string{svc: "1"},
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	a, b := r.New(), r.New()
+	metaA, metaB := newMeta(1), newMeta(2)
+	if err := a.Register(metaA); err != nil {
+		t.Fatal(err)
+	}
+	seen := map[int64]*kiwi.NodeMeta{}
+	a.Watch(func(meta *kiwi.NodeMeta) {
+		seen[meta.NodeId] = meta
+	}, func(svc kiwi.TSvc, nodeId int64) {
+		delete(seen, nodeId)
+	})
+	if err := b.Register(metaB); err != nil {
+		t.Fatal(err)
+	}
+	if metaA.NodeId == metaB.NodeId {
+		t.Fatalf("duplicate node id %d", metaA.NodeId)
+	}
+	if _, ok := seen[metaB.NodeId]; !ok || len(seen) != 1 {
+		t.Fatalf("unexpected nodes %v", seen)
+	}
+
+	metaB.Draining = true
+	_ = b.Update(metaB)
+	if !seen[metaB.NodeId].Draining {
+		t.Fatal("draining not watched")
+	}
+
+	b.Unregister()
+	if len(seen) != 0 {
+		t.Fatalf("unexpected nodes %v", seen)
+	}
+}
+
+func TestRegisterExist(t *testing.T) {
+	r := NewRegistry()
+	const count = 8
+	var (
+		wg   sync.WaitGroup
+		mtx  sync.Mutex
+		fail int
+	)
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			meta := newMeta(1)
+			meta.NodeId = 100
+			if err := r.New().Register(meta); err != nil {
+				mtx.Lock()
+				fail++
+				mtx.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if fail != count-1 || len(r.Nodes()) != 1 {
+		t.Fatal("registered", count-fail)
+	}
+}
@@ -0,0 +1,360 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/discovery"
+	"github.com/15mga/kiwi/util"
+	"github.com/15mga/kiwi/util/rds"
+	"github.com/bwmarrin/snowflake"
+	"github.com/gomodule/redigo/redis"
+)
+
+var (
+	RegTtl int64 = 5
+	// PollDur 轮询间隔,开启keyspace通知时作为兜底
+	PollDur          = 3 * time.Second
+	_RegRoot         = ""
+	_RegSvcIdxOffset = 1
+)
+
+func SetRegRoot(root string) {
+	_RegRoot = root
+	_RegSvcIdxOffset = strings.Count(RegSvcPrefix(), ".")
+}
+
+func RegSvcPrefix() string {
+	return _RegRoot + "node.info"
+}
+
+func RegLockPrefix() string {
+	return _RegRoot + "node.lock"
+}
+
+// RegisterService 使用redis注册并发现节点,key的格式与etcd一致
+func RegisterService(opts ...Option) {
+	err := discovery.Start(New(opts...))
+	if err != nil {
+		kiwi.Error(err)
+	}
+}
+
+type option struct {
+	keyspace bool
+}
+
+type Option func(o *option)
+
+// Keyspace 通过keyspace通知及时发现变化,需要redis开启notify-keyspace-events Kgx
+func Keyspace() Option {
+	return func(o *option) {
+		o.keyspace = true
+	}
+}
+
+func New(opts ...Option) kiwi.IDiscovery {
+	o := &option{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return &rdsDiscovery{
+		option: o,
+	}
+}
+
+type rdsDiscovery struct {
+	option   *option
+	mtx      sync.Mutex
+	nodeId   int64
+	keys     []string
+	val      string
+	cancel   context.CancelFunc
+	keyToVal map[string]string
+}
+
+func (d *rdsDiscovery) Register(meta *kiwi.NodeMeta) *util.Err {
+	var err *util.Err
+	e := rds.Lock(RegLockPrefix(), func() {
+		err = d.register(meta)
+	})
+	if e != nil {
+		return e
+	}
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(util.Ctx())
+	d.cancel = cancel
+	go d.heartbeat(ctx)
+	return nil
+}
+
+func (d *rdsDiscovery) register(meta *kiwi.NodeMeta) *util.Err {
+	conn := rds.SpawnConn()
+	defer conn.Close()
+	keyToVal, err := loadNodes(conn)
+	if err != nil {
+		return err
+	}
+	d.keyToVal = keyToVal
+	nodeIdMap := make(map[int64]struct{}, 8)
+	for _, val := range keyToVal {
+		var si kiwi.NodeMeta
+		err := util.JsonUnmarshal([]byte(val), &si)
+		if err != nil {
+			continue
+		}
+		nodeIdMap[snowflake.ParseInt64(si.NodeId).Node()] = struct{}{}
+	}
+	idx := int64(1)
+	for ; idx < 1024; idx++ {
+		if _, ok := nodeIdMap[idx]; !ok {
+			meta.Init(idx)
+			break
+		}
+	}
+	if idx == 1024 {
+		return util.NewErr(util.EcServiceErr, util.M{
+			"error": "too much service node",
+		})
+	}
+	d.nodeId = meta.NodeId
+	return d.Update(meta)
+}
+
+// Update 每个服务一个key,共用一个过期时间
+func (d *rdsDiscovery) Update(meta *kiwi.NodeMeta) *util.Err {
+	bytes, err := util.JsonMarshal(meta)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(meta.Services))
+	for svc := range meta.Services {
+		keys = append(keys, getRegSvcKey(svc, meta.NodeId))
+	}
+	d.mtx.Lock()
+	d.keys = keys
+	d.val = string(bytes)
+	d.mtx.Unlock()
+	return d.put()
+}
+
+func (d *rdsDiscovery) put() *util.Err {
+	d.mtx.Lock()
+	keys, val := d.keys, d.val
+	d.mtx.Unlock()
+	if len(keys) == 0 {
+		return nil
+	}
+	return rds.FnSpawnConn(func(conn redis.Conn) *util.Err {
+		for _, key := range keys {
+			_ = conn.Send(rds.SET, key, val, "EX", RegTtl)
+		}
+		_, e := conn.Do("")
+		if e != nil {
+			return util.WrapErr(util.EcRedisErr, e)
+		}
+		return nil
+	})
+}
+
+func (d *rdsDiscovery) heartbeat(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(RegTtl) * time.Second / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := d.put()
+			if err != nil {
+				kiwi.Error(err)
+			}
+		}
+	}
+}
+
+func (d *rdsDiscovery) Unregister() {
+	d.mtx.Lock()
+	keys := d.keys
+	d.keys = nil
+	cancel := d.cancel
+	d.mtx.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	if len(keys) == 0 {
+		return
+	}
+	args := make([]any, len(keys))
+	for i, key := range keys {
+		args[i] = key
+	}
+	err := rds.FnSpawnConn(func(conn redis.Conn) *util.Err {
+		_, e := conn.Do(rds.DEL, args...)
+		if e != nil {
+			return util.WrapErr(util.EcRedisErr, e)
+		}
+		return nil
+	})
+	if err != nil {
+		kiwi.Error(err)
+	}
+}
+
+func (d *rdsDiscovery) Watch(onPut kiwi.FnNodeMeta, onDel kiwi.FnSvcNodeId) {
+	keyToVal := d.keyToVal
+	d.keyToVal = nil
+	for _, val := range keyToVal {
+		d.onPut(val, onPut)
+	}
+	notify := make(chan struct{}, 1)
+	if d.option.keyspace {
+		go subscribe(notify)
+	}
+	go func() {
+		ticker := time.NewTicker(PollDur)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-util.Ctx().Done():
+				return
+			case <-ticker.C:
+			case <-notify:
+			}
+			var newKeyToVal map[string]string
+			err := rds.FnSpawnConn(func(conn redis.Conn) *util.Err {
+				var err *util.Err
+				newKeyToVal, err = loadNodes(conn)
+				return err
+			})
+			if err != nil {
+				kiwi.Error(err)
+				continue
+			}
+			for key, val := range newKeyToVal {
+				if old, ok := keyToVal[key]; !ok || old != val {
+					d.onPut(val, onPut)
+				}
+			}
+			for key := range keyToVal {
+				if _, ok := newKeyToVal[key]; ok {
+					continue
+				}
+				svc, id, err := splitRegSvcKey(key)
+				if err != nil {
+					kiwi.Error(err)
+					continue
+				}
+				if id == d.nodeId {
+					continue
+				}
+				onDel(svc, id)
+			}
+			keyToVal = newKeyToVal
+		}
+	}()
+}
+
+func (d *rdsDiscovery) onPut(val string, onPut kiwi.FnNodeMeta) {
+	var si kiwi.NodeMeta
+	err := util.JsonUnmarshal([]byte(val), &si)
+	if err != nil {
+		kiwi.Error(err)
+		return
+	}
+	if si.NodeId == d.nodeId {
+		return
+	}
+	onPut(&si)
+}
+
+// subscribe 收到keyspace通知后立即轮询一次
+func subscribe(notify chan<- struct{}) {
+	psc := redis.PubSubConn{Conn: rds.SpawnConn()}
+	defer psc.Close()
+	e := psc.PSubscribe("__keyspace@*__:" + RegSvcPrefix() + ".*")
+	if e != nil {
+		kiwi.Error(util.WrapErr(util.EcRedisErr, e))
+		return
+	}
+	go func() {
+		<-util.Ctx().Done()
+		_ = psc.PUnsubscribe()
+	}()
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.Message:
+			select {
+			case notify <- struct{}{}:
+			default:
+			}
+		case redis.Subscription:
+			if v.Count == 0 {
+				return
+			}
+		case error:
+			kiwi.Error(util.WrapErr(util.EcRedisErr, v))
+			return
+		}
+	}
+}
+
+func loadNodes(conn redis.Conn) (map[string]string, *util.Err) {
+	var keys []any
+	err := rds.Scan(conn, RegSvcPrefix()+".*", 128, func(slc []string) {
+		for _, key := range slc {
+			keys = append(keys, key)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	keyToVal := make(map[string]string, len(keys))
+	if len(keys) == 0 {
+		return keyToVal, nil
+	}
+	vals, e := redis.Strings(conn.Do(rds.MGET, keys...))
+	if e != nil {
+		return nil, util.WrapErr(util.EcRedisErr, e)
+	}
+	for i, val := range vals {
+		if val != "" {
+			keyToVal[keys[i].(string)] = val
+		}
+	}
+	return keyToVal, nil
+}
+
+func getRegSvcKey(svc kiwi.TSvc, id int64) string {
+	return fmt.Sprintf("%s.%d.%d", RegSvcPrefix(), svc, id)
+}
+
+func splitRegSvcKey(key string) (svc kiwi.TSvc, id int64, err *util.Err) {
+	slc := strings.Split(key, ".")
+	l := len(slc)
+	if l != _RegSvcIdxOffset+3 {
+		err = util.NewErr(util.EcRedisErr, util.M{
+			"key": key,
+		})
+		return
+	}
+	svci, e := strconv.Atoi(slc[_RegSvcIdxOffset+1])
+	if e != nil {
+		err = util.WrapErr(util.EcParseErr, e)
+		return
+	}
+	id, e = strconv.ParseInt(slc[_RegSvcIdxOffset+2], 10, 64)
+	if e != nil {
+		err = util.WrapErr(util.EcParseErr, e)
+		return
+	}
+	svc = kiwi.TSvc(svci)
+	return
+}
@@ -0,0 +1,315 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+	"github.com/15mga/kiwi/util/rds"
+	"github.com/bwmarrin/snowflake"
+	"github.com/gomodule/redigo/redis"
+)
+
+// fakeStore 只实现注册和发现用到的命令,过期在读取时检查
+type fakeStore struct {
+	mtx      sync.Mutex
+	keyToVal map[string]string
+	keyToExp map[string]time.Time
+}
+
+func newFakeStore() *fakeStore {
+	s := &fakeStore{
+		keyToVal: make(map[string]string),
+		keyToExp: make(map[string]time.Time),
+	}
+	rds.InitRedis(rds.ConnPool(&redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return &fakeConn{store: s}, nil
+		},
+	}))
+	return s
+}
+
+func (s *fakeStore) get(key string) (string, bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.getLocked(key)
+}
+
+func (s *fakeStore) getLocked(key string) (string, bool) {
+	if exp, ok := s.keyToExp[key]; ok && time.Now().After(exp) {
+		delete(s.keyToVal, key)
+		delete(s.keyToExp, key)
+	}
+	val, ok := s.keyToVal[key]
+	return val, ok
+}
+
+func (s *fakeStore) set(key, val string) {
+	s.mtx.Lock()
+	s.keyToVal[key] = val
+	delete(s.keyToExp, key)
+	s.mtx.Unlock()
+}
+
+func (s *fakeStore) del(key string) {
+	s.mtx.Lock()
+	delete(s.keyToVal, key)
+	delete(s.keyToExp, key)
+	s.mtx.Unlock()
+}
+
+func (s *fakeStore) do(cmd string, args []any) (any, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	str := func(i int) string {
+		switch v := args[i].(type) {
+		case string:
+			return v
+		case []byte:
+			return string(v)
+		default:
+			return fmt.Sprint(v)
+		}
+	}
+	switch strings.ToUpper(cmd) {
+	case "SET":
+		key, val := str(0), str(1)
+		var (
+			exp time.Time
+			nx  bool
+		)
+		for i := 2; i < len(args); i++ {
+			switch strings.ToUpper(str(i)) {
+			case "NX":
+				nx = true
+			case "EX", "PX":
+				n, _ := strconv.ParseInt(str(i+1), 10, 64)
+				unit := time.Second
+				if strings.ToUpper(str(i)) == "PX" {
+					unit = time.Millisecond
+				}
+				exp = time.Now().Add(time.Duration(n) * unit)
+				i++
+			}
+		}
+		if _, ok := s.getLocked(key); ok && nx {
+			return nil, nil
+		}
+		s.keyToVal[key] = val
+		if exp.IsZero() {
+			delete(s.keyToExp, key)
+		} else {
+			s.keyToExp[key] = exp
+		}
+		return "OK", nil
+	case "GET":
+		if val, ok := s.getLocked(str(0)); ok {
+			return []byte(val), nil
+		}
+		return nil, nil
+	case "MGET":
+		vals := make([]any, len(args))
+		for i := range args {
+			if val, ok := s.getLocked(str(i)); ok {
+				vals[i] = []byte(val)
+			}
+		}
+		return vals, nil
+	case "DEL":
+		n := int64(0)
+		for i := range args {
+			if _, ok := s.getLocked(str(i)); ok {
+				delete(s.keyToVal, str(i))
+				delete(s.keyToExp, str(i))
+				n++
+			}
+		}
+		return n, nil
+	case "SCAN":
+		match := str(2)
+		var keys []any
+		for key := range s.keyToVal {
+			if ok, _ := path.Match(match, key); ok {
+				if _, ok = s.getLocked(key); ok {
+					keys = append(keys, []byte(key))
+				}
+			}
+		}
+		return []any{[]byte("0"), keys}, nil
+	case "EVALSHA", "EVAL":
+		//redsync解锁
+		delete(s.keyToVal, str(2))
+		delete(s.keyToExp, str(2))
+		return int64(1), nil
+	default:
+		return nil, errors.New("unknown command " + cmd)
+	}
+}
+
+type fakeConn struct {
+	store   *fakeStore
+	pending []any
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Err() error {
+	return nil
+}
+
+func (c *fakeConn) Do(cmd string, args ...any) (any, error) {
+	if cmd == "" {
+		replies := c.pending
+		c.pending = nil
+		return replies, nil
+	}
+	return c.store.do(cmd, args)
+}
+
+func (c *fakeConn) Send(cmd string, args ...any) error {
+	reply, err := c.store.do(cmd, args)
+	if err != nil {
+		return err
+	}
+	c.pending = append(c.pending, reply)
+	return nil
+}
+
+func (c *fakeConn) Flush() error {
+	return nil
+}
+
+func (c *fakeConn) Receive() (any, error) {
+	if len(c.pending) == 0 {
+		return nil, errors.New("no pending reply")
+	}
+	reply := c.pending[0]
+	c.pending = c.pending[1:]
+	return reply, nil
+}
+
+func testMeta(svc kiwi.TSvc, nodeId int64) *kiwi.NodeMeta {
+	return &kiwi.NodeMeta{
+		NodeId:   nodeId,
+		Data:     util.M{},
+		Services: map[kiwi.TSvc]string{svc: "1"},
+	}
+}
+
+func putMeta(s *fakeStore, meta *kiwi.NodeMeta) string {
+	bytes, _ := util.JsonMarshal(meta)
+	var key string
+	for svc := range meta.Services {
+		key = getRegSvcKey(svc, meta.NodeId)
+		s.set(key, string(bytes))
+	}
+	return key
+}
+
+func TestRegister(t *testing.T) {
+	s := newFakeStore()
+	ttl := RegTtl
+	RegTtl = 1
+	defer func() {
+		RegTtl = ttl
+	}()
+	//节点号为1的其他节点
+	putMeta(s, testMeta(1, int64(1)<<snowflake.StepBits))
+
+	d := New()
+	meta := testMeta(2, 0)
+	if err := d.Register(meta); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Unregister()
+	//节点号1已被占用
+	if node := snowflake.ParseInt64(meta.NodeId).Node(); node != 2 {
+		t.Fatal("allocated node", node)
+	}
+	key := getRegSvcKey(2, meta.NodeId)
+	if _, ok := s.get(key); !ok {
+		t.Fatal("not registered")
+	}
+	//超过ttl后仍然存在,由心跳刷新
+	time.Sleep(1500 * time.Millisecond)
+	if _, ok := s.get(key); !ok {
+		t.Fatal("ttl not refreshed")
+	}
+	d.Unregister()
+	if _, ok := s.get(key); ok {
+		t.Fatal("not unregistered")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	s := newFakeStore()
+	dur := PollDur
+	PollDur = 10 * time.Millisecond
+	defer func() {
+		PollDur = dur
+	}()
+	keyA := putMeta(s, testMeta(1, 11))
+	self := &rdsDiscovery{option: &option{}, nodeId: 99}
+	putMeta(s, testMeta(3, 99))
+	keyToVal, err := func() (map[string]string, *util.Err) {
+		conn := rds.SpawnConn()
+		defer conn.Close()
+		return loadNodes(conn)
+	}()
+	if err != nil {
+		t.Fatal(err)
+	}
+	self.keyToVal = keyToVal
+
+	type event struct {
+		put    bool
+		nodeId int64
+	}
+	ch := make(chan event, 16)
+	self.Watch(func(meta *kiwi.NodeMeta) {
+		ch <- event{true, meta.NodeId}
+	}, func(_ kiwi.TSvc, nodeId int64) {
+		ch <- event{false, nodeId}
+	})
+	next := func() event {
+		select {
+		case e := <-ch:
+			return e
+		case <-time.After(time.Second):
+			t.Fatal("no event")
+			return event{}
+		}
+	}
+	if e := next(); !e.put || e.nodeId != 11 {
+		t.Fatal("initial", e)
+	}
+	putMeta(s, testMeta(2, 12))
+	if e := next(); !e.put || e.nodeId != 12 {
+		t.Fatal("added", e)
+	}
+	draining := testMeta(2, 12)
+	draining.Draining = true
+	putMeta(s, draining)
+	if e := next(); !e.put || e.nodeId != 12 {
+		t.Fatal("changed", e)
+	}
+	s.del(keyA)
+	if e := next(); e.put || e.nodeId != 11 {
+		t.Fatal("deleted", e)
+	}
+	select {
+	case e := <-ch:
+		t.Fatal("unexpected", e)
+	case <-time.After(5 * PollDur):
+	}
+}
@@ -0,0 +1,186 @@
+package static
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/sid"
+	"github.com/15mga/kiwi/util"
+	"gopkg.in/yaml.v3"
+)
+
+// Conf 固定拓扑配置
+type Conf struct {
+	Nodes []*Node `json:"nodes" yaml:"nodes"`
+}
+
+// Node NodeId取值1到1023,同时作为id生成器的节点号
+type Node struct {
+	Ip       string               `json:"ip" yaml:"ip"`
+	Port     int                  `json:"port" yaml:"port"`
+	NodeId   int64                `json:"nodeId" yaml:"nodeId"`
+	Services map[kiwi.TSvc]string `json:"services" yaml:"services"`
+	Data     util.M               `json:"data" yaml:"data"`
+}
+
+func (n *Node) meta() *kiwi.NodeMeta {
+	data := n.Data
+	if data == nil {
+		data = util.M{}
+	}
+	return &kiwi.NodeMeta{
+		Ip:       n.Ip,
+		Port:     n.Port,
+		NodeId:   n.NodeId,
+		Data:     data,
+		Services: n.Services,
+	}
+}
+
+// Load 根据扩展名解析yaml或json
+func Load(path string) (*Conf, *util.Err) {
+	bytes, e := os.ReadFile(path)
+	if e != nil {
+		return nil, util.NewErr(util.EcIo, util.M{
+			"path":  path,
+			"error": e.Error(),
+		})
+	}
+	conf := &Conf{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		e = yaml.Unmarshal(bytes, conf)
+		if e != nil {
+			return nil, util.WrapErr(util.EcUnmarshallErr, e)
+		}
+	case ".json":
+		err := util.JsonUnmarshal(bytes, conf)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, util.NewErr(util.EcNotImplement, util.M{
+			"path": path,
+		})
+	}
+	return conf, nil
+}
+
+type option struct {
+	self int64
+}
+
+type Option func(o *option)
+
+// Self 本节点在配置中的NodeId,缺省按NodeId或ip端口匹配
+func Self(nodeId int64) Option {
+	return func(o *option) {
+		o.self = nodeId
+	}
+}
+
+// New 从文件加载固定拓扑
+func New(path string, opts ...Option) kiwi.IDiscovery {
+	d := newDiscovery(opts)
+	d.path = path
+	return d
+}
+
+func NewWithConf(conf *Conf, opts ...Option) kiwi.IDiscovery {
+	d := newDiscovery(opts)
+	d.conf = conf
+	return d
+}
+
+func newDiscovery(opts []Option) *staticDiscovery {
+	o := &option{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return &staticDiscovery{
+		option: o,
+	}
+}
+
+type staticDiscovery struct {
+	option *option
+	path   string
+	conf   *Conf
+	nodeId int64
+}
+
+func (d *staticDiscovery) Register(meta *kiwi.NodeMeta) *util.Err {
+	if d.conf == nil {
+		conf, err := Load(d.path)
+		if err != nil {
+			return err
+		}
+		d.conf = conf
+	}
+	self := d.findSelf(meta)
+	if self == nil {
+		return util.NewErr(util.EcNotExist, util.M{
+			"error": "node not in static conf",
+			"ip":    meta.Ip,
+			"port":  meta.Port,
+			"node":  meta.NodeId,
+		})
+	}
+	if self.NodeId <= 0 || self.NodeId >= 1024 {
+		return util.NewErr(util.EcParamsErr, util.M{
+			"error": "static node id must be in 1..1023",
+			"node":  self.NodeId,
+		})
+	}
+	meta.NodeId = self.NodeId
+	meta.Ip = self.Ip
+	meta.Port = self.Port
+	d.nodeId = self.NodeId
+	//配置中的NodeId同时作为id生成器的节点号,避免节点间tid重复
+	sid.SetNodeId(self.NodeId)
+	kiwi.SetLogDefParams(util.M{
+		"node": meta.NodeId,
+	})
+	return nil
+}
+
+func (d *staticDiscovery) findSelf(meta *kiwi.NodeMeta) *Node {
+	if d.option.self > 0 {
+		for _, n := range d.conf.Nodes {
+			if n.NodeId == d.option.self {
+				return n
+			}
+		}
+		return nil
+	}
+	for _, n := range d.conf.Nodes {
+		if n.NodeId == meta.NodeId {
+			return n
+		}
+	}
+	for _, n := range d.conf.Nodes {
+		if n.Ip == meta.Ip && n.Port == meta.Port {
+			return n
+		}
+	}
+	return nil
+}
+
+// Update 固定拓扑无法通知其他节点
+func (d *staticDiscovery) Update(*kiwi.NodeMeta) *util.Err {
+	return nil
+}
+
+func (d *staticDiscovery) Unregister() {
+}
+
+func (d *staticDiscovery) Watch(onPut kiwi.FnNodeMeta, _ kiwi.FnSvcNodeId) {
+	for _, n := range d.conf.Nodes {
+		if n.NodeId == d.nodeId {
+			continue
+		}
+		onPut(n.meta())
+	}
+}
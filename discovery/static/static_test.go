@@ -0,0 +1,64 @@
+package static
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/sid"
+	"github.com/15mga/kiwi/util"
+	"github.com/bwmarrin/snowflake"
+)
+
+const testYaml = `nodes:
+  - ip: 127.0.0.1
+    port: 7001
+    nodeId: 1
+    services:
+      1: "1.0"
+  - ip: 127.0.0.1
+    port: 7002
+    nodeId: 2
+    services:
+      2: "1.0"
+`
+
+func TestStatic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nodes.yaml")
+	if e := os.WriteFile(path, []byte(testYaml), 0644); e != nil {
+		t.Fatal(e)
+	}
+	d := New(path)
+	meta := &kiwi.NodeMeta{Ip: "127.0.0.1", Port: 7002, Data: util.M{}}
+	if err := d.Register(meta); err != nil {
+		t.Fatal(err)
+	}
+	if meta.NodeId != 2 {
+		t.Fatal("node id", meta.NodeId)
+	}
+	if node := snowflake.ParseInt64(sid.GetId()).Node(); node != 2 {
+		t.Fatal("sid node", node)
+	}
+	var seen []*kiwi.NodeMeta
+	d.Watch(func(meta *kiwi.NodeMeta) {
+		seen = append(seen, meta)
+	}, nil)
+	if len(seen) != 1 || seen[0].NodeId != 1 || seen[0].Services[1] != "1.0" {
+		t.Fatal("watch", seen)
+	}
+}
+
+func TestStaticErr(t *testing.T) {
+	conf := &Conf{Nodes: []*Node{{Ip: "127.0.0.1", Port: 7001, NodeId: 1024}}}
+	meta := &kiwi.NodeMeta{Ip: "127.0.0.1", Port: 7001}
+	if err := NewWithConf(conf).Register(meta); err == nil {
+		t.Fatal("node id out of range")
+	}
+	if err := NewWithConf(conf, Self(3)).Register(meta); err == nil {
+		t.Fatal("self not in conf")
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "nodes.txt")); err == nil {
+		t.Fatal("unknown ext")
+	}
+}
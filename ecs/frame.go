@@ -3,6 +3,7 @@ package ecs
 import (
 	"context"
 	"github.com/15mga/kiwi/ds"
+	"github.com/15mga/kiwi/metrics"
 	"github.com/15mga/kiwi/worker"
 	"sync"
 	"time"
@@ -15,8 +16,21 @@ const (
 	cmdFrameAddSystem = "add_system"
 	cmdFrameDelSystem = "del_system"
 	cmdFrameJob       = "job_system"
+	cmdFrameInfo      = "info"
 )
 
+var (
+	_Frames sync.Map
+)
+
+// IterFrames 遍历运行中的帧
+func IterFrames(fn func(*Frame)) {
+	_Frames.Range(func(_, value any) bool {
+		fn(value.(*Frame))
+		return true
+	})
+}
+
 type (
 	frameOption struct {
 		maxFrame      int64
@@ -144,8 +158,10 @@ func (f *Frame) GetSystem(typ TSystem) (ISystem, bool) {
 
 func (f *Frame) Start() {
 	completeCh := kiwi.BeforeExitCh("stop frame")
+	_Frames.Store(f, f)
 	go func() {
 		defer func() {
+			_Frames.Delete(f)
 			if f.option.beforeDispose != nil {
 				f.option.beforeDispose(f)
 			}
@@ -204,9 +220,14 @@ func (f *Frame) Stop() {
 	f.ccl()
 }
 
+var (
+	_MetricFrame = metrics.NewHistogramVec("kiwi_ecs_frame_seconds", "ecs frame tick duration", nil)
+)
+
 func (f *Frame) tick() {
 	f.currFrame++
-	now := time.Now().UnixMilli()
+	start := time.Now()
+	now := start.UnixMilli()
 	ms := now - f.nowMillSecs
 	f.nowMillSecs = now
 	f.before.InvokeAndReset()
@@ -215,6 +236,7 @@ func (f *Frame) tick() {
 	}
 	f.after.InvokeAndReset()
 	f.deltaMs = ms
+	_MetricFrame.With().Observe(time.Since(start).Seconds())
 	frameDur := time.Now().UnixMilli() - now
 	//kiwi.Debug("frame", util.M{
 	//	"curr": f.currFrame,
@@ -234,6 +256,28 @@ func (f *Frame) DelSystem(t TSystem) {
 	f.push(cmdFrameDelSystem, t)
 }
 
+// Info 在帧协程中获取场景与系统信息
+func (f *Frame) Info(fn util.FnM) {
+	f.push(cmdFrameInfo, fn)
+}
+
+func (f *Frame) onInfo(data []any) {
+	fn := data[0].(util.FnM)
+	systems := make([]TSystem, 0, len(f.systems))
+	for _, s := range f.systems {
+		systems = append(systems, s.Type())
+	}
+	fn(util.M{
+		"scene id":   f.scene.id,
+		"scene type": f.scene.typ,
+		"entities":   f.scene.EntityCount(),
+		"systems":    systems,
+		"frame":      f.currFrame,
+		"delta ms":   f.deltaMs,
+		"max ms":     f.maxMs,
+	})
+}
+
 // PushJob frame 外部使用，协程安全的
 func (f *Frame) PushJob(name JobName, data ...any) {
 	f.push(cmdFrameJob, name, data)
@@ -345,6 +389,8 @@ func (f *Frame) do() {
 			f.onDelSystem(j.Data)
 		case cmdFrameJob:
 			f.onJobSystem(j.Data)
+		case cmdFrameInfo:
+			f.onInfo(j.Data)
 		}
 		next := j.next
 		j.Data = nil
@@ -11,6 +11,18 @@ const (
 	Evt_Stop            = "stop"
 	Evt_Svc_Connected   = "svc_connected"
 	Evt_Svc_Disonnected = "svc_disconnected"
+	Evt_Node_Breaker    = "node_breaker"
+	Evt_Node_Draining   = "node_draining"
+	Evt_Node_Drained    = "node_drained"
+	Evt_Node_Register   = "node_register"
+)
+
+type BreakerState = uint8
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
 )
 
 type EvtStart struct {
@@ -42,3 +54,9 @@ type EvtSvcDisconnected struct {
 	Svc TSvc
 	Id  int64
 }
+
+type EvtNodeBreaker struct {
+	Svc   TSvc
+	Id    int64
+	State BreakerState
+}
@@ -37,4 +37,12 @@ type IGate interface {
 	GetAddrHeadCache(tid int64, id string, fn util.FnM2Bool)
 	SetRoles(m map[TSvcCode][]int64)
 	Authenticate(mask int64, svc TSvc, code TCode) bool
+	// JoinChannel 按id加入频道,断开后自动离开
+	JoinChannel(tid int64, channel string, ids ...string)
+	LeaveChannel(tid int64, channel string, ids ...string)
+	// ChannelSend 发送给频道所有成员,成员共享同一份bytes
+	ChannelSend(tid int64, channel string, bytes []byte)
+	ChannelMembers(tid int64, channel string, fn util.FnStrSlc)
+	// Resume 客户端重连后用token恢复断线前的会话
+	Resume(tid int64, addr, token string, handler util.FnBool)
 }
@@ -18,6 +18,15 @@ type ILogger interface {
 	Span(level TLevel, tid int64, msg, caller string, stack []byte, params util.M)
 }
 
+// ILevelLogger 支持运行时修改级别,0表示不修改
+type ILevelLogger interface {
+	Name() string
+	SetLevels(logLvl, traceLvl TLevel)
+}
+
+// TraceFilter 返回tid指定的链路级别掩码,ok为false时使用日志自身的掩码
+type TraceFilter func(tid int64) (lvl TLevel, ok bool)
+
 var (
 	TestLevels = []TLevel{TDebug, TInfo, TWarn, TError, TFatal}
 	DevLevels  = []TLevel{TInfo, TWarn, TError, TFatal}
@@ -115,14 +124,48 @@ func copyLogParams(params util.M) {
 }
 
 var (
-	_Loggers    []ILogger
-	_CallerSkip = 2
+	_Loggers     []ILogger
+	_CallerSkip  = 2
+	_TraceFilter TraceFilter
 )
 
 func AddLogger(logger ILogger) {
 	_Loggers = append(_Loggers, logger)
 }
 
+// SetLogLevels 修改所有支持的日志的级别掩码
+func SetLogLevels(logLvl, traceLvl TLevel) {
+	SetLoggerLevels("", logLvl, traceLvl)
+}
+
+// SetLoggerLevels 修改指定名称的日志的级别掩码,name为空时修改全部
+func SetLoggerLevels(name string, logLvl, traceLvl TLevel) bool {
+	ok := false
+	for _, l := range _Loggers {
+		ll, is := l.(ILevelLogger)
+		if !is || (name != "" && ll.Name() != name) {
+			continue
+		}
+		ll.SetLevels(logLvl, traceLvl)
+		ok = true
+	}
+	return ok
+}
+
+func SetTraceFilter(filter TraceFilter) {
+	_TraceFilter = filter
+}
+
+// TestTraceLvl 日志在Span中使用,tid指定了掩码时替换日志自身的掩码
+func TestTraceLvl(tid int64, level, mask TLevel) bool {
+	if _TraceFilter != nil {
+		if lvl, ok := _TraceFilter(tid); ok {
+			return util.TestMask(level, lvl)
+		}
+	}
+	return util.TestMask(level, mask)
+}
+
 func SetCallerSkip(skip int) {
 	_CallerSkip = skip
 }
@@ -142,6 +185,11 @@ func log(level TLevel, msg string, stack []byte, params util.M) {
 }
 
 func span(level TLevel, tid int64, msg string, stack []byte, params util.M) {
+	if _TraceFilter != nil {
+		if lvl, ok := _TraceFilter(tid); ok && !util.TestMask(level, lvl) {
+			return
+		}
+	}
 	var caller string
 	for _, l := range _Loggers {
 		if params == nil && _LogDefParamsLen > 0 {
@@ -217,9 +265,16 @@ func Fatal3(code util.TErrCode, e error) {
 	os.Exit(1)
 }
 
-// TC 链路标记
+// TC 链路标记,params为包头时同时写入traceparent
 func TC(pid int64, params util.M, exclude bool) int64 {
-	tid := sid.GetId()
+	return TCId(pid, sid.GetId(), params, exclude)
+}
+
+// TCId 使用指定的tid标记链路
+func TCId(pid, tid int64, params util.M, exclude bool) int64 {
+	if params != nil {
+		pid = propagateTrace(pid, tid, params)
+	}
 	if !exclude {
 		var caller string
 		for _, l := range _Loggers {
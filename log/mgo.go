@@ -10,6 +10,7 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"os"
+	"sync/atomic"
 	"time"
 )
 
@@ -158,11 +159,11 @@ func NewMgo(opts ...MgoOption) *mgoLogger {
 	_, _ = logColl.Indexes().CreateMany(context.TODO(),
 		append(l.option.logIdx,
 			mongo.IndexModel{
-				Keys:    bson.D{{"ts", -1}},
+				Keys:    bson.D{{Key: "ts", Value: -1}},
 				Options: options.Index().SetExpireAfterSeconds(opt.ttl),
 			},
 			mongo.IndexModel{
-				Keys: bson.D{{"lvl", 1}},
+				Keys: bson.D{{Key: "lvl", Value: 1}},
 			}))
 	l.logBuffer = newMgoBuffer(16, logColl)
 
@@ -176,17 +177,17 @@ func NewMgo(opts ...MgoOption) *mgoLogger {
 	_, _ = traceColl.Indexes().CreateMany(context.TODO(),
 		append(l.option.spanIdx,
 			mongo.IndexModel{
-				Keys:    bson.D{{"ts", -1}},
+				Keys:    bson.D{{Key: "ts", Value: -1}},
 				Options: options.Index().SetExpireAfterSeconds(opt.ttl),
 			},
 			mongo.IndexModel{
-				Keys: bson.D{{"pid", -1}},
+				Keys: bson.D{{Key: "pid", Value: -1}},
 			},
 			mongo.IndexModel{
-				Keys: bson.D{{"tid", -1}},
+				Keys: bson.D{{Key: "tid", Value: -1}},
 			},
 			mongo.IndexModel{
-				Keys: bson.D{{"msg", 1}},
+				Keys: bson.D{{Key: "msg", Value: 1}},
 			}))
 	l.traceBuffer = newMgoBuffer(32, traceColl)
 
@@ -200,17 +201,17 @@ func NewMgo(opts ...MgoOption) *mgoLogger {
 	_, _ = spanColl.Indexes().CreateMany(context.TODO(),
 		append(l.option.traceIdx,
 			mongo.IndexModel{
-				Keys:    bson.D{{"ts", -1}},
+				Keys:    bson.D{{Key: "ts", Value: -1}},
 				Options: options.Index().SetExpireAfterSeconds(opt.ttl),
 			},
 			mongo.IndexModel{
-				Keys: bson.D{{"tid", -1}},
+				Keys: bson.D{{Key: "tid", Value: -1}},
 			},
 			mongo.IndexModel{
-				Keys: bson.D{{"lvl", 1}},
+				Keys: bson.D{{Key: "lvl", Value: 1}},
 			},
 			mongo.IndexModel{
-				Keys: bson.D{{"msg", 1}},
+				Keys: bson.D{{Key: "msg", Value: 1}},
 			}))
 	l.spanBuffer = newMgoBuffer(128, spanColl)
 	l.worker = worker.NewJobWorker(l.process)
@@ -265,7 +266,7 @@ func (l *mgoLogger) conn() *util.Err {
 }
 
 func (l *mgoLogger) Log(level kiwi.TLevel, msg, caller string, stack []byte, params util.M) {
-	if !util.TestMask(level, l.option.logLvl) {
+	if !util.TestMask(level, atomic.LoadInt64(&l.option.logLvl)) {
 		return
 	}
 	l.worker.Push(cmdLog, &log{
@@ -289,6 +290,9 @@ func (l *mgoLogger) Trace(pid, tid int64, caller string, params util.M) {
 }
 
 func (l *mgoLogger) Span(level kiwi.TLevel, tid int64, msg, caller string, stack []byte, params util.M) {
+	if !kiwi.TestTraceLvl(tid, level, atomic.LoadInt64(&l.option.traceLvl)) {
+		return
+	}
 	l.worker.Push(cmdSpan, &span{
 		Timestamp: time.Now().UnixMilli(),
 		Level:     level,
@@ -383,3 +387,16 @@ type span struct {
 	Caller    string      `bson:"cl"`
 	Params    util.M      `bson:"p"`
 }
+
+func (l *mgoLogger) Name() string {
+	return "mgo"
+}
+
+func (l *mgoLogger) SetLevels(logLvl, traceLvl kiwi.TLevel) {
+	if logLvl > 0 {
+		atomic.StoreInt64(&l.option.logLvl, logLvl)
+	}
+	if traceLvl > 0 {
+		atomic.StoreInt64(&l.option.traceLvl, traceLvl)
+	}
+}
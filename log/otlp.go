@@ -0,0 +1,432 @@
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+	"github.com/15mga/kiwi/worker"
+)
+
+const (
+	otlpSpanKindInternal = 1
+	otlpSpanKindServer   = 2
+	otlpStatusError      = 2
+)
+
+type (
+	otlpOption struct {
+		traceLvl    kiwi.TLevel
+		endpoint    string
+		serviceName string
+		headers     map[string]string
+		idle        time.Duration
+		batch       int
+		timeout     time.Duration
+	}
+	OtlpOption func(opt *otlpOption)
+)
+
+// OtlpEndpoint OTLP/HTTP的traces地址
+func OtlpEndpoint(endpoint string) OtlpOption {
+	return func(opt *otlpOption) {
+		opt.endpoint = endpoint
+	}
+}
+
+func OtlpServiceName(name string) OtlpOption {
+	return func(opt *otlpOption) {
+		opt.serviceName = name
+	}
+}
+
+func OtlpHeaders(headers map[string]string) OtlpOption {
+	return func(opt *otlpOption) {
+		opt.headers = headers
+	}
+}
+
+func OtlpTraceLvl(levels ...string) OtlpOption {
+	return func(opt *otlpOption) {
+		opt.traceLvl = kiwi.StrLvlToMask(levels...)
+	}
+}
+
+// OtlpIdle span多久没有新的日志视为结束
+func OtlpIdle(idle time.Duration) OtlpOption {
+	return func(opt *otlpOption) {
+		opt.idle = idle
+	}
+}
+
+func OtlpBatch(batch int) OtlpOption {
+	return func(opt *otlpOption) {
+		opt.batch = batch
+	}
+}
+
+func OtlpTimeout(timeout time.Duration) OtlpOption {
+	return func(opt *otlpOption) {
+		opt.timeout = timeout
+	}
+}
+
+// NewOtlp 将Trace/Span转换为OpenTelemetry span,以OTLP/HTTP JSON导出
+func NewOtlp(opts ...OtlpOption) *otlpLogger {
+	opt := &otlpOption{
+		traceLvl:    kiwi.LvlToMask(kiwi.TestLevels...),
+		endpoint:    "http://localhost:4318/v1/traces",
+		serviceName: "kiwi",
+		idle:        time.Second * 2,
+		batch:       256,
+		timeout:     time.Second * 5,
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+	l := &otlpLogger{
+		option:    opt,
+		client:    &http.Client{Timeout: opt.timeout},
+		tidToSpan: make(map[int64]*otlpSpan, 128),
+	}
+	l.worker = worker.NewJobWorker(l.process)
+	l.worker.Start()
+	clearCh := make(chan chan struct{}, 1)
+	kiwi.BeforeExitFn("otlp log", func() {
+		overCh := make(chan struct{}, 1)
+		go func() {
+			time.Sleep(time.Millisecond * 100)
+			clearCh <- overCh
+		}()
+		<-overCh
+	})
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		for {
+			select {
+			case <-ticker.C:
+				l.worker.Push(cmdFlush)
+			case ch := <-clearCh:
+				ticker.Stop()
+				l.worker.Push(cmdOver, ch)
+				return
+			}
+		}
+	}()
+	return l
+}
+
+type otlpLogger struct {
+	option    *otlpOption
+	client    *http.Client
+	worker    *worker.JobWorker
+	tidToSpan map[int64]*otlpSpan
+	buffer    []*otlpSpan
+}
+
+func (l *otlpLogger) Log(kiwi.TLevel, string, string, []byte, util.M) {
+}
+
+func (l *otlpLogger) Name() string {
+	return "otlp"
+}
+
+func (l *otlpLogger) SetLevels(_, traceLvl kiwi.TLevel) {
+	if traceLvl > 0 {
+		atomic.StoreInt64(&l.option.traceLvl, traceLvl)
+	}
+}
+
+func (l *otlpLogger) Trace(pid, tid int64, caller string, params util.M) {
+	l.worker.Push(cmdTrace, &trace{
+		Timestamp: time.Now().UnixNano(),
+		Pid:       pid,
+		Tid:       tid,
+		Caller:    caller,
+		Params:    params.Copy(nil),
+	})
+}
+
+func (l *otlpLogger) Span(level kiwi.TLevel, tid int64, msg, caller string, stack []byte, params util.M) {
+	if !kiwi.TestTraceLvl(tid, level, atomic.LoadInt64(&l.option.traceLvl)) {
+		return
+	}
+	params = params.Copy(nil)
+	//收到的tid没有经过Trace,带上绑定的链路用于创建span
+	if traceParent, ok := kiwi.BoundTraceParent(tid); ok {
+		params[kiwi.HeadTraceParent] = traceParent
+	}
+	l.worker.Push(cmdSpan, &span{
+		Timestamp: time.Now().UnixNano(),
+		Level:     level,
+		Tid:       tid,
+		Message:   msg,
+		Stack:     string(stack),
+		Caller:    caller,
+		Params:    params,
+	})
+}
+
+func (l *otlpLogger) process(job *worker.Job) {
+	switch job.Name {
+	case cmdTrace:
+		l.onTrace(job.Data[0].(*trace))
+	case cmdSpan:
+		l.onSpan(job.Data[0].(*span))
+	case cmdFlush:
+		l.flush(false)
+	case cmdOver:
+		l.flush(true)
+		job.Data[0].(chan struct{}) <- struct{}{}
+	}
+}
+
+func (l *otlpLogger) onTrace(t *trace) {
+	traceParent, _ := util.MGet[string](t.Params, kiwi.HeadTraceParent)
+	traceId, _, _, ok := kiwi.ParseTraceParent(traceParent)
+	if !ok {
+		traceId = kiwi.NewTraceId()
+	}
+	delete(t.Params, kiwi.HeadTraceParent)
+	delete(t.Params, kiwi.HeadTraceState)
+	s := &otlpSpan{
+		TraceId:           traceId,
+		SpanId:            kiwi.SpanIdToHex(t.Tid),
+		Name:              spanName(t.Params),
+		Kind:              otlpSpanKindInternal,
+		StartTimeUnixNano: strconv.FormatInt(t.Timestamp, 10),
+		EndTimeUnixNano:   strconv.FormatInt(t.Timestamp, 10),
+		Attributes:        toOtlpAttrs(t.Params, "code.filepath", t.Caller),
+		lastTs:            t.Timestamp,
+	}
+	if t.Pid != 0 {
+		s.ParentSpanId = kiwi.SpanIdToHex(t.Pid)
+	}
+	l.tidToSpan[t.Tid] = s
+}
+
+func (l *otlpLogger) onSpan(sp *span) {
+	traceParent, _ := util.MGet[string](sp.Params, kiwi.HeadTraceParent)
+	delete(sp.Params, kiwi.HeadTraceParent)
+	s, ok := l.tidToSpan[sp.Tid]
+	if !ok {
+		s, ok = l.newServerSpan(sp, traceParent)
+		if !ok {
+			return
+		}
+	}
+	attrs := toOtlpAttrs(sp.Params, "level", kiwi.LevelToStr(sp.Level))
+	attrs = append(attrs, otlpAttr("code.filepath", sp.Caller))
+	if sp.Stack != "" {
+		attrs = append(attrs, otlpAttr("exception.stacktrace", sp.Stack))
+	}
+	s.Events = append(s.Events, &otlpEvent{
+		TimeUnixNano: strconv.FormatInt(sp.Timestamp, 10),
+		Name:         sp.Message,
+		Attributes:   attrs,
+	})
+	if sp.Level >= kiwi.TError {
+		s.Status = &otlpStatus{
+			Code:    otlpStatusError,
+			Message: sp.Message,
+		}
+	}
+	s.EndTimeUnixNano = strconv.FormatInt(sp.Timestamp, 10)
+	s.lastTs = sp.Timestamp
+}
+
+// newServerSpan 收到的包的tid即发送方的span id,作为父span
+func (l *otlpLogger) newServerSpan(sp *span, traceParent string) (*otlpSpan, bool) {
+	traceId, spanId, _, ok := kiwi.ParseTraceParent(traceParent)
+	if !ok {
+		return nil, false
+	}
+	s := &otlpSpan{
+		TraceId:           traceId,
+		SpanId:            kiwi.NewTraceId()[:16],
+		ParentSpanId:      kiwi.SpanIdToHex(spanId),
+		Name:              spanName(sp.Params),
+		Kind:              otlpSpanKindServer,
+		StartTimeUnixNano: strconv.FormatInt(sp.Timestamp, 10),
+		Attributes:        []*otlpKeyValue{otlpAttr("kiwi.tid", strconv.FormatInt(sp.Tid, 10))},
+	}
+	l.tidToSpan[sp.Tid] = s
+	return s, true
+}
+
+// flush 导出空闲的span,all为true时导出全部
+func (l *otlpLogger) flush(all bool) {
+	idle := time.Now().Add(-l.option.idle).UnixNano()
+	for tid, s := range l.tidToSpan {
+		if !all && s.lastTs > idle {
+			continue
+		}
+		delete(l.tidToSpan, tid)
+		l.buffer = append(l.buffer, s)
+		if len(l.buffer) >= l.option.batch {
+			l.export()
+		}
+	}
+	l.export()
+}
+
+func (l *otlpLogger) export() {
+	if len(l.buffer) == 0 {
+		return
+	}
+	req := &otlpRequest{
+		ResourceSpans: []*otlpResourceSpans{
+			{
+				Resource: &otlpResource{
+					Attributes: []*otlpKeyValue{
+						otlpAttr("service.name", l.option.serviceName),
+						otlpAttr("service.instance.id", strconv.FormatInt(kiwi.GetNodeMeta().NodeId, 10)),
+					},
+				},
+				ScopeSpans: []*otlpScopeSpans{
+					{
+						Scope: &otlpScope{Name: "kiwi"},
+						Spans: l.buffer,
+					},
+				},
+			},
+		},
+	}
+	l.buffer = nil
+	body, err := util.JsonMarshal(req)
+	if err != nil {
+		_, _ = os.Stderr.WriteString(err.Error() + "\n")
+		return
+	}
+	httpReq, e := http.NewRequest(http.MethodPost, l.option.endpoint, bytes.NewReader(body))
+	if e != nil {
+		_, _ = os.Stderr.WriteString(e.Error() + "\n")
+		return
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	for k, v := range l.option.headers {
+		httpReq.Header.Set(k, v)
+	}
+	res, e := l.client.Do(httpReq)
+	if e != nil {
+		_, _ = os.Stderr.WriteString(e.Error() + "\n")
+		return
+	}
+	_ = res.Body.Close()
+	if res.StatusCode >= 300 {
+		_, _ = os.Stderr.WriteString("otlp export failed: " + res.Status + "\n")
+	}
+}
+
+func spanName(head util.M) string {
+	svc, ok1 := head["svc"]
+	code, ok2 := head["cod"]
+	if ok1 && ok2 {
+		return fmt.Sprintf("%v.%v", svc, code)
+	}
+	return "trace"
+}
+
+func toOtlpAttrs(params util.M, key, val string) []*otlpKeyValue {
+	attrs := make([]*otlpKeyValue, 0, len(params)+2)
+	attrs = append(attrs, otlpAttr(key, val))
+	for k, v := range params {
+		attrs = append(attrs, &otlpKeyValue{
+			Key:   k,
+			Value: toOtlpValue(v),
+		})
+	}
+	return attrs
+}
+
+func otlpAttr(key, val string) *otlpKeyValue {
+	return &otlpKeyValue{
+		Key:   key,
+		Value: &otlpAnyValue{StringValue: &val},
+	}
+}
+
+func toOtlpValue(v any) *otlpAnyValue {
+	switch val := v.(type) {
+	case string:
+		return &otlpAnyValue{StringValue: &val}
+	case bool:
+		return &otlpAnyValue{BoolValue: &val}
+	case int, int8, int16, int32, int64, uint8, uint16, uint32:
+		s := fmt.Sprint(val)
+		return &otlpAnyValue{IntValue: &s}
+	case float32:
+		f := float64(val)
+		return &otlpAnyValue{DoubleValue: &f}
+	case float64:
+		return &otlpAnyValue{DoubleValue: &val}
+	default:
+		s := fmt.Sprint(val)
+		return &otlpAnyValue{StringValue: &s}
+	}
+}
+
+type otlpRequest struct {
+	ResourceSpans []*otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   *otlpResource     `json:"resource"`
+	ScopeSpans []*otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []*otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope *otlpScope  `json:"scope"`
+	Spans []*otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceId           string          `json:"traceId"`
+	SpanId            string          `json:"spanId"`
+	ParentSpanId      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []*otlpKeyValue `json:"attributes,omitempty"`
+	Events            []*otlpEvent    `json:"events,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+	lastTs            int64
+}
+
+type otlpEvent struct {
+	TimeUnixNano string          `json:"timeUnixNano"`
+	Name         string          `json:"name"`
+	Attributes   []*otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string        `json:"key"`
+	Value *otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
@@ -0,0 +1,46 @@
+package log
+
+import (
+	"testing"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+)
+
+func TestOtlpBoundSpan(t *testing.T) {
+	l := &otlpLogger{
+		option:    &otlpOption{},
+		tidToSpan: make(map[int64]*otlpSpan),
+	}
+	traceId := kiwi.NewTraceId()
+	tid := int64(123)
+	kiwi.BindTraceCtx(tid, util.M{
+		kiwi.HeadTraceParent: kiwi.FormatTraceParent(traceId, tid, "01"),
+	})
+	defer kiwi.UnbindTraceCtx(tid)
+	traceParent, ok := kiwi.BoundTraceParent(tid)
+	if !ok {
+		t.Fatal("not bound")
+	}
+	l.onSpan(&span{
+		Level:   kiwi.TInfo,
+		Tid:     tid,
+		Message: "rcv",
+		Params:  util.M{kiwi.HeadTraceParent: traceParent},
+	})
+	s, ok := l.tidToSpan[tid]
+	if !ok {
+		t.Fatal("span dropped")
+	}
+	if s.TraceId != traceId || s.ParentSpanId != kiwi.SpanIdToHex(tid) || s.SpanId == s.ParentSpanId {
+		t.Fatal("span", s.TraceId, s.SpanId, s.ParentSpanId)
+	}
+	if len(s.Events) != 1 || len(s.Events[0].Attributes) != 2 {
+		t.Fatal("events", s.Events)
+	}
+	//未绑定的tid仍然丢弃
+	l.onSpan(&span{Level: kiwi.TInfo, Tid: 456, Params: util.M{}})
+	if _, ok = l.tidToSpan[456]; ok {
+		t.Fatal("unbound span")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"gopkg.in/natefinch/lumberjack.v2"
@@ -207,7 +208,7 @@ func (l *stdLogger) getTimestamp() string {
 }
 
 func (l *stdLogger) Log(level kiwi.TLevel, msg, caller string, stack []byte, params util.M) {
-	if !util.TestMask(level, l.option.logLvl) {
+	if !util.TestMask(level, atomic.LoadInt64(&l.option.logLvl)) {
 		return
 	}
 	var buffer util.ByteBuffer
@@ -266,7 +267,7 @@ func (l *stdLogger) Trace(pid, tid int64, caller string, params util.M) {
 }
 
 func (l *stdLogger) Span(level kiwi.TLevel, tid int64, msg, caller string, stack []byte, params util.M) {
-	if !util.TestMask(level, l.option.traceLvl) {
+	if !kiwi.TestTraceLvl(tid, level, atomic.LoadInt64(&l.option.traceLvl)) {
 		return
 	}
 	var buffer util.ByteBuffer
@@ -302,3 +303,16 @@ func (l *stdLogger) Span(level kiwi.TLevel, tid int64, msg, caller string, stack
 	_, _ = l.option.writer.Write(buffer.All())
 	buffer.Dispose()
 }
+
+func (l *stdLogger) Name() string {
+	return "std"
+}
+
+func (l *stdLogger) SetLevels(logLvl, traceLvl kiwi.TLevel) {
+	if logLvl > 0 {
+		atomic.StoreInt64(&l.option.logLvl, logLvl)
+	}
+	if traceLvl > 0 {
+		atomic.StoreInt64(&l.option.traceLvl, traceLvl)
+	}
+}
@@ -0,0 +1,317 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	typCounter   = "counter"
+	typGauge     = "gauge"
+	typHistogram = "histogram"
+)
+
+var (
+	// DefBuckets 默认的耗时分布,单位秒
+	DefBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+)
+
+type float struct {
+	bits uint64
+}
+
+func (f *float) add(v float64) {
+	for {
+		old := atomic.LoadUint64(&f.bits)
+		n := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&f.bits, old, n) {
+			return
+		}
+	}
+}
+
+func (f *float) set(v float64) {
+	atomic.StoreUint64(&f.bits, math.Float64bits(v))
+}
+
+func (f *float) get() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&f.bits))
+}
+
+type Counter struct {
+	val float
+}
+
+func (c *Counter) Inc() {
+	c.val.add(1)
+}
+
+// Add 计数只增不减,负数忽略
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		return
+	}
+	c.val.add(v)
+}
+
+type Gauge struct {
+	val float
+}
+
+func (g *Gauge) Set(v float64) {
+	g.val.set(v)
+}
+
+func (g *Gauge) Add(v float64) {
+	g.val.add(v)
+}
+
+func (g *Gauge) Inc() {
+	g.val.add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.val.add(-1)
+}
+
+type Histogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	if i < len(h.counts) {
+		atomic.AddUint64(&h.counts[i], 1)
+	}
+	atomic.AddUint64(&h.count, 1)
+	h.sum.add(v)
+}
+
+// vec 按标签值区分的指标集合
+type vec[T any] struct {
+	name    string
+	help    string
+	typ     string
+	labels  []string
+	mtx     sync.RWMutex
+	keyToM  map[string]*T
+	keyToLv map[string][]string
+	newM    func() *T
+	write   func(b *strings.Builder, name, labels string, m *T)
+}
+
+func newVec[T any](name, help, typ string, labels []string, newM func() *T,
+	write func(b *strings.Builder, name, labels string, m *T)) *vec[T] {
+	return &vec[T]{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		keyToM:  make(map[string]*T),
+		keyToLv: make(map[string][]string),
+		newM:    newM,
+		write:   write,
+	}
+}
+
+// With 标签值的数量需要与标签一致
+func (v *vec[T]) With(values ...string) *T {
+	if len(values) != len(v.labels) {
+		panic("metrics " + v.name + " label count mismatch")
+	}
+	key := strings.Join(values, "\xff")
+	v.mtx.RLock()
+	m, ok := v.keyToM[key]
+	v.mtx.RUnlock()
+	if ok {
+		return m
+	}
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+	m, ok = v.keyToM[key]
+	if ok {
+		return m
+	}
+	m = v.newM()
+	v.keyToM[key] = m
+	v.keyToLv[key] = append([]string(nil), values...)
+	return m
+}
+
+func (v *vec[T]) Name() string {
+	return v.name
+}
+
+func (v *vec[T]) Write(b *strings.Builder) {
+	writeMeta(b, v.name, v.help, v.typ)
+	v.mtx.RLock()
+	keys := make([]string, 0, len(v.keyToM))
+	for key := range v.keyToM {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		v.write(b, v.name, formatLabels(v.labels, v.keyToLv[key]), v.keyToM[key])
+	}
+	v.mtx.RUnlock()
+}
+
+type CounterVec struct {
+	*vec[Counter]
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{
+		vec: newVec[Counter](name, help, typCounter, labels, func() *Counter {
+			return &Counter{}
+		}, func(b *strings.Builder, name, labels string, m *Counter) {
+			writeSample(b, name, labels, m.val.get())
+		}),
+	}
+	Register(v)
+	return v
+}
+
+type GaugeVec struct {
+	*vec[Gauge]
+}
+
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	v := &GaugeVec{
+		vec: newVec[Gauge](name, help, typGauge, labels, func() *Gauge {
+			return &Gauge{}
+		}, func(b *strings.Builder, name, labels string, m *Gauge) {
+			writeSample(b, name, labels, m.val.get())
+		}),
+	}
+	Register(v)
+	return v
+}
+
+type HistogramVec struct {
+	*vec[Histogram]
+}
+
+// NewHistogramVec buckets为空时使用DefBuckets
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	v := &HistogramVec{
+		vec: newVec[Histogram](name, help, typHistogram, labels, func() *Histogram {
+			return &Histogram{
+				buckets: buckets,
+				counts:  make([]uint64, len(buckets)),
+			}
+		}, writeHistogram),
+	}
+	Register(v)
+	return v
+}
+
+func writeHistogram(b *strings.Builder, name, labels string, h *Histogram) {
+	var total uint64
+	for i, le := range h.buckets {
+		total += atomic.LoadUint64(&h.counts[i])
+		writeSample(b, name+"_bucket", joinLabels(labels, `le="`+formatFloat(le)+`"`), float64(total))
+	}
+	writeSample(b, name+"_bucket", joinLabels(labels, `le="+Inf"`), float64(atomic.LoadUint64(&h.count)))
+	writeSample(b, name+"_sum", labels, h.sum.get())
+	writeSample(b, name+"_count", labels, float64(atomic.LoadUint64(&h.count)))
+}
+
+// funcCollector 采集时调用函数取值
+type funcCollector struct {
+	name string
+	help string
+	typ  string
+	fn   func() float64
+}
+
+func (c *funcCollector) Name() string {
+	return c.name
+}
+
+func (c *funcCollector) Write(b *strings.Builder) {
+	writeMeta(b, c.name, c.help, c.typ)
+	writeSample(b, c.name, "", c.fn())
+}
+
+func NewGaugeFunc(name, help string, fn func() float64) {
+	Register(&funcCollector{name: name, help: help, typ: typGauge, fn: fn})
+}
+
+func NewCounterFunc(name, help string, fn func() float64) {
+	Register(&funcCollector{name: name, help: help, typ: typCounter, fn: fn})
+}
+
+func writeMeta(b *strings.Builder, name, help, typ string) {
+	b.WriteString("# HELP ")
+	b.WriteString(name)
+	b.WriteByte(' ')
+	b.WriteString(strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	b.WriteString("\n# TYPE ")
+	b.WriteString(name)
+	b.WriteByte(' ')
+	b.WriteString(typ)
+	b.WriteByte('\n')
+}
+
+func writeSample(b *strings.Builder, name, labels string, v float64) {
+	b.WriteString(name)
+	if labels != "" {
+		b.WriteByte('{')
+		b.WriteString(labels)
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatFloat(v))
+	b.WriteByte('\n')
+}
+
+var _LabelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(labels, values []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	for i, label := range labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(label)
+		b.WriteString(`="`)
+		b.WriteString(_LabelReplacer.Replace(values[i]))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+func joinLabels(labels, label string) string {
+	if labels == "" {
+		return label
+	}
+	return labels + "," + label
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
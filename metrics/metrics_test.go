@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestText(t *testing.T) {
+	c := NewCounterVec("test_total", "test counter", "svc")
+	c.With("1").Inc()
+	c.With("1").Add(2)
+	c.With(`a"b`).Inc()
+	h := NewHistogramVec("test_seconds", "test histogram", []float64{0.1, 1})
+	h.With().Observe(0.05)
+	h.With().Observe(0.5)
+	h.With().Observe(5)
+
+	text := Default().String()
+	for _, line := range []string{
+		"# TYPE test_total counter",
+		`test_total{svc="1"} 3`,
+		`test_total{svc="a\"b"} 1`,
+		"# TYPE test_seconds histogram",
+		`test_seconds_bucket{le="0.1"} 1`,
+		`test_seconds_bucket{le="1"} 2`,
+		`test_seconds_bucket{le="+Inf"} 3`,
+		"test_seconds_sum 5.55",
+		"test_seconds_count 3",
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Fatalf("missing %q in\n%s", line, text)
+		}
+	}
+}
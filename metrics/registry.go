@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+)
+
+const (
+	DefPath = "/metrics"
+)
+
+// ICollector 输出Prometheus文本格式
+type ICollector interface {
+	Name() string
+	Write(b *strings.Builder)
+}
+
+var (
+	_Default = NewRegistry()
+)
+
+func Default() *Registry {
+	return _Default
+}
+
+func Register(c ICollector) {
+	_Default.Register(c)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		nameToCollector: make(map[string]ICollector, 32),
+	}
+}
+
+type Registry struct {
+	mtx             sync.RWMutex
+	nameToCollector map[string]ICollector
+}
+
+// Register 重名时替换
+func (r *Registry) Register(c ICollector) {
+	r.mtx.Lock()
+	r.nameToCollector[c.Name()] = c
+	r.mtx.Unlock()
+}
+
+func (r *Registry) Unregister(name string) {
+	r.mtx.Lock()
+	delete(r.nameToCollector, name)
+	r.mtx.Unlock()
+}
+
+func (r *Registry) String() string {
+	r.mtx.RLock()
+	collectors := make([]ICollector, 0, len(r.nameToCollector))
+	for _, c := range r.nameToCollector {
+		collectors = append(collectors, c)
+	}
+	r.mtx.RUnlock()
+	sort.Slice(collectors, func(i, j int) bool {
+		return collectors[i].Name() < collectors[j].Name()
+	})
+	var b strings.Builder
+	for _, c := range collectors {
+		c.Write(&b)
+	}
+	return b.String()
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write([]byte(r.String()))
+}
+
+func Handler() http.Handler {
+	return _Default
+}
+
+// Serve 在addr上提供/metrics
+func Serve(addr string) *util.Err {
+	ln, e := net.Listen("tcp", addr)
+	if e != nil {
+		return util.WrapErr(util.EcListenErr, e)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(DefPath, _Default)
+	svr := &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+	go func() {
+		e := svr.Serve(ln)
+		if e != nil && !errors.Is(e, http.ErrServerClosed) {
+			kiwi.Error(util.WrapErr(util.EcListenErr, e))
+		}
+	}()
+	go func() {
+		<-util.Ctx().Done()
+		_ = svr.Close()
+	}()
+	kiwi.Info("metrics serve", util.M{
+		"addr": addr,
+		"path": DefPath,
+	})
+	return nil
+}
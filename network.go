@@ -34,6 +34,12 @@ type IAgent interface {
 	Enable() *util.Enable
 	// Send 发送数据
 	Send(bytes []byte) *util.Err
+	// SendShared 发送多个连接共享的数据,不会复制
+	SendShared(shared *util.SharedBytes) *util.Err
+	// Pending 已发送但还没有写出的包数量
+	Pending() int32
+	// SetCompress 开启或关闭发送压缩,对端需要能解压
+	SetCompress(enable bool)
 	// Dispose 释放
 	Dispose()
 	BindConnected(fn FnAgent)
@@ -78,10 +84,34 @@ type (
 		DeadlineSecs int
 		AgentMode    AgentRWMode
 		HeadLen      int
+		Compressor   ICompressor //不为空时可以解压对端压缩的包
+		CompressMin  int         //小于这个长度的包不压缩
+		CompressMode CompressMode
+		Crypto       bool   //连接后握手派生会话密钥,之后的包AES-GCM加密
+		CryptoPsk    []byte //预共享密钥,不能为空,参与派生会话密钥,双方不同时握手后无法解密
 	}
 	AgentOption func(o *AgentOpt)
 )
 
+// ICompressor 包体压缩
+type ICompressor interface {
+	Name() string
+	Compress(bytes []byte) ([]byte, *util.Err)
+	// Decompress 解压后超过maxLen返回错误
+	Decompress(bytes []byte, maxLen int) ([]byte, *util.Err)
+}
+
+type CompressMode uint8
+
+const (
+	// CompressOff 只解压不压缩,可以用SetCompress开启
+	CompressOff CompressMode = iota
+	// CompressNegotiate 收到对端压缩的包后开始压缩
+	CompressNegotiate
+	// CompressOn 开始就压缩
+	CompressOn
+)
+
 // AgentPacketMaxCap 最大包长
 func AgentPacketMaxCap(packetMaxCap int) AgentOption {
 	return func(o *AgentOpt) {
@@ -120,3 +150,23 @@ func AgentHeadLen(length int) AgentOption {
 		o.HeadLen = length
 	}
 }
+
+// AgentCrypto 不使用tls时的应用层加密,psk不能为空,用于认证对端;
+// AgentRW连接后双方发送X25519临时公钥,用psk和共享密钥派生会话密钥,
+// 单向连接由写端发送随机salt,用psk和salt派生,tcp、udp、websocket都支持
+func AgentCrypto(psk []byte) AgentOption {
+	return func(o *AgentOpt) {
+		o.Crypto = true
+		o.CryptoPsk = psk
+	}
+}
+
+// AgentCompress tcp用长度头的最高位标记压缩的包,udp在包前加一个字节标记,
+// websocket使用握手协商的permessage-deflate,不使用compressor
+func AgentCompress(compressor ICompressor, min int, mode CompressMode) AgentOption {
+	return func(o *AgentOpt) {
+		o.Compressor = compressor
+		o.CompressMin = min
+		o.CompressMode = mode
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"math"
 	"net"
 	"sync"
+	"sync/atomic"
 
 	"github.com/15mga/kiwi/ds"
 
@@ -32,7 +33,7 @@ func newAgent(addr string, receiver kiwi.FnAgentBytes, opts ...kiwi.AgentOption)
 		option:         opt,
 		enable:         util.NewEnable(),
 		receiver:       receiver,
-		bytesLink:      ds.NewLink[[]byte](),
+		bytesLink:      ds.NewLink[agentBytes](),
 		onConnected:    ds.NewFnLink1[kiwi.IAgent](),
 		onDisconnected: ds.NewFnLink2[kiwi.IAgent, *util.Err](),
 		head:           util.M{},
@@ -40,6 +41,12 @@ func newAgent(addr string, receiver kiwi.FnAgentBytes, opts ...kiwi.AgentOption)
 		mtx:            &sync.RWMutex{},
 	}
 	a.head.Set("addr", addr)
+	if opt.Compressor != nil && opt.CompressMode == kiwi.CompressOn {
+		a.compress = 1
+	}
+	if opt.Crypto {
+		a.crypto = newAgentCrypto(opt.CryptoPsk, opt.AgentMode)
+	}
 	return a
 }
 
@@ -53,19 +60,35 @@ type agent struct {
 	writeSignCh    chan struct{}
 	enable         *util.Enable
 	receiver       kiwi.FnAgentBytes
-	bytesLink      *ds.Link[[]byte]
+	bytesLink      *ds.Link[agentBytes]
 	onConnected    *ds.FnLink1[kiwi.IAgent]
 	onDisconnected *ds.FnLink2[kiwi.IAgent, *util.Err]
 	head           util.M
 	cache          util.M
 	mtx            *sync.RWMutex
+	pending        int32
+	compress       int32
+	crypto         *agentCrypto
 }
 
 func (a *agent) onStart(_ []any) {
 	a.writeSignCh = make(chan struct{}, 1)
+	if hello := a.cryptoHello(); hello != nil {
+		//握手的包在所有包之前发送
+		a.bytesLink.Push(agentBytes{bytes: hello, plain: true})
+		atomic.AddInt32(&a.pending, 1)
+		a.writeSignCh <- struct{}{}
+	}
 	go a.onConnected.Invoke(a)
 }
 
+func (a *agent) cryptoHello() []byte {
+	if a.crypto == nil {
+		return nil
+	}
+	return a.crypto.hello()
+}
+
 func (a *agent) start(ctx context.Context) {
 	a.ctx, a.cancel = context.WithCancel(ctx)
 	_ = a.enable.Enable(a.onStart)
@@ -194,9 +217,149 @@ func (a *agent) Send(bytes []byte) *util.Err {
 	return a.enable.WAction(agentPushByte, a, bytes)
 }
 
+// SendShared 发送共享的包,写出或失败后Release
+func (a *agent) SendShared(shared *util.SharedBytes) *util.Err {
+	l := len(shared.Bytes())
+	if l == 0 {
+		shared.Release()
+		return util.NewErr(util.EcEmpty, nil)
+	}
+	if l > a.option.PacketMaxCap {
+		shared.Release()
+		return util.NewErr(util.EcTooLong, util.M{
+			"length": l,
+		})
+	}
+	err := a.enable.WAction(agentPushShared, a, shared)
+	if err != nil {
+		shared.Release()
+	}
+	return err
+}
+
+func (a *agent) Pending() int32 {
+	return atomic.LoadInt32(&a.pending)
+}
+
+func (a *agent) written() {
+	atomic.AddInt32(&a.pending, -1)
+}
+
+func (a *agent) SetCompress(enable bool) {
+	if enable {
+		atomic.StoreInt32(&a.compress, 1)
+	} else {
+		atomic.StoreInt32(&a.compress, 0)
+	}
+}
+
+func (a *agent) compressing() bool {
+	return atomic.LoadInt32(&a.compress) == 1
+}
+
+// encode 开启压缩且不小于CompressMin时压缩,压缩后没有变小发送原始数据
+func (a *agent) encode(bytes []byte) ([]byte, bool) {
+	if !a.compressing() || len(bytes) < a.option.CompressMin {
+		return bytes, false
+	}
+	dst, err := a.option.Compressor.Compress(bytes)
+	if err != nil {
+		err.AddParam("addr", a.addr)
+		kiwi.Error(err)
+		return bytes, false
+	}
+	if len(dst) >= len(bytes) {
+		return bytes, false
+	}
+	return dst, true
+}
+
+// pack 压缩后加密,加密时等待握手完成
+func (a *agent) pack(b agentBytes) ([]byte, bool, *util.Err) {
+	if b.plain {
+		return b.bytes, false, nil
+	}
+	payload, compressed := a.encode(b.bytes)
+	if a.crypto != nil {
+		select {
+		case <-a.crypto.ready:
+		case <-a.ctx.Done():
+			return nil, false, util.NewErr(util.EcClosed, nil)
+		}
+		payload = a.crypto.seal(payload)
+	}
+	return payload, compressed, nil
+}
+
+// unpack 解密后解压,握手的包返回false
+func (a *agent) unpack(bytes []byte, compressed bool) ([]byte, bool, *util.Err) {
+	if a.crypto != nil {
+		if !a.crypto.done {
+			err := a.crypto.handshake(bytes)
+			if err != nil {
+				err.AddParam("addr", a.addr)
+			}
+			return nil, false, err
+		}
+		var err *util.Err
+		bytes, err = a.crypto.open(bytes)
+		if err != nil {
+			err.AddParam("addr", a.addr)
+			return nil, false, err
+		}
+	}
+	if !compressed {
+		return bytes, true, nil
+	}
+	bytes, err := a.decode(bytes)
+	return bytes, err == nil, err
+}
+
+// decode 协商模式下收到压缩的包后开始压缩
+func (a *agent) decode(bytes []byte) ([]byte, *util.Err) {
+	dst, err := a.option.Compressor.Decompress(bytes, a.option.PacketMaxCap)
+	if err != nil {
+		err.AddParam("addr", a.addr)
+		return nil, err
+	}
+	if a.option.CompressMode == kiwi.CompressNegotiate && !a.compressing() {
+		a.SetCompress(true)
+	}
+	return dst, nil
+}
+
+// agentBytes shared不为空时共享,写出后Release而不是回收;plain是握手的包,不压缩不加密
+type agentBytes struct {
+	bytes  []byte
+	shared *util.SharedBytes
+	plain  bool
+}
+
+func (b agentBytes) recycle() {
+	if b.plain {
+		return
+	}
+	if b.shared != nil {
+		b.shared.Release()
+		return
+	}
+	util.RecycleBytes(b.bytes)
+}
+
 func agentPushByte(params []any) {
 	a, bytes := util.SplitSlc2[*agent, []byte](params)
-	a.bytesLink.Push(bytes)
+	a.bytesLink.Push(agentBytes{bytes: bytes})
+	atomic.AddInt32(&a.pending, 1)
+	select {
+	case a.writeSignCh <- struct{}{}:
+	default:
+	}
+}
+
+func agentPushShared(params []any) {
+	a, shared := util.SplitSlc2[*agent, *util.SharedBytes](params)
+	a.bytesLink.Push(agentBytes{bytes: shared.Bytes(), shared: shared})
+	atomic.AddInt32(&a.pending, 1)
 	select {
 	case a.writeSignCh <- struct{}{}:
 	default:
@@ -0,0 +1,139 @@
+package network
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"sync"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+	"github.com/golang/snappy"
+)
+
+const (
+	CompressFlate  = "flate"
+	CompressSnappy = "snappy"
+	// DefCompressMin 默认小于这个长度的包不压缩
+	DefCompressMin = 512
+)
+
+const (
+	_UdpRaw        byte = 0
+	_UdpCompressed byte = 1
+)
+
+var (
+	_CompressorMtx sync.RWMutex
+	_Compressors   = map[string]kiwi.ICompressor{
+		CompressFlate:  NewFlate(flate.DefaultCompression),
+		CompressSnappy: NewSnappy(),
+	}
+)
+
+// RegisterCompressor 注册后节点之间可以按名称协商
+func RegisterCompressor(compressor kiwi.ICompressor) {
+	_CompressorMtx.Lock()
+	_Compressors[compressor.Name()] = compressor
+	_CompressorMtx.Unlock()
+}
+
+func GetCompressor(name string) (kiwi.ICompressor, bool) {
+	_CompressorMtx.RLock()
+	c, ok := _Compressors[name]
+	_CompressorMtx.RUnlock()
+	return c, ok
+}
+
+func NewFlate(level int) kiwi.ICompressor {
+	return &flateCompressor{
+		level: level,
+	}
+}
+
+type flateCompressor struct {
+	level   int
+	writers sync.Pool
+	readers sync.Pool
+}
+
+func (c *flateCompressor) Name() string {
+	return CompressFlate
+}
+
+func (c *flateCompressor) Compress(src []byte) ([]byte, *util.Err) {
+	var buf bytes.Buffer
+	buf.Grow(len(src) >> 1)
+	w, ok := c.writers.Get().(*flate.Writer)
+	if ok {
+		w.Reset(&buf)
+	} else {
+		var e error
+		w, e = flate.NewWriter(&buf, c.level)
+		if e != nil {
+			return nil, util.WrapErr(util.EcParamsErr, e)
+		}
+	}
+	_, e := w.Write(src)
+	if e == nil {
+		e = w.Close()
+	}
+	c.writers.Put(w)
+	if e != nil {
+		return nil, util.WrapErr(util.EcMarshallErr, e)
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *flateCompressor) Decompress(src []byte, maxLen int) ([]byte, *util.Err) {
+	r, ok := c.readers.Get().(io.ReadCloser)
+	if ok {
+		_ = r.(flate.Resetter).Reset(bytes.NewReader(src), nil)
+	} else {
+		r = flate.NewReader(bytes.NewReader(src))
+	}
+	defer c.readers.Put(r)
+	dst, e := io.ReadAll(io.LimitReader(r, int64(maxLen)+1))
+	if e != nil {
+		return nil, util.WrapErr(util.EcUnmarshallErr, e)
+	}
+	if len(dst) > maxLen {
+		return nil, util.NewErr(util.EcTooLong, util.M{
+			"max": maxLen,
+		})
+	}
+	return dst, nil
+}
+
+func NewSnappy() kiwi.ICompressor {
+	return snappyCompressor{}
+}
+
+type snappyCompressor struct {
+}
+
+func (c snappyCompressor) Name() string {
+	return CompressSnappy
+}
+
+func (c snappyCompressor) Compress(src []byte) ([]byte, *util.Err) {
+	return snappy.Encode(nil, src), nil
+}
+
+func (c snappyCompressor) Decompress(src []byte, maxLen int) ([]byte, *util.Err) {
+	l, e := snappy.DecodedLen(src)
+	if e != nil {
+		return nil, util.WrapErr(util.EcUnmarshallErr, e)
+	}
+	if l > maxLen {
+		return nil, util.NewErr(util.EcTooLong, util.M{
+			"length": l,
+			"max":    maxLen,
+		})
+	}
+	dst, e := snappy.Decode(nil, src)
+	if e != nil {
+		return nil, util.WrapErr(util.EcUnmarshallErr, e)
+	}
+	return dst, nil
+}
@@ -0,0 +1,78 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/15mga/kiwi"
+)
+
+func TestCompressor(t *testing.T) {
+	src := bytes.Repeat([]byte("kiwi compress "), 100)
+	for _, c := range []kiwi.ICompressor{NewFlate(-1), NewSnappy()} {
+		dst, err := c.Compress(src)
+		if err != nil {
+			t.Fatal(c.Name(), err)
+		}
+		if len(dst) >= len(src) {
+			t.Fatal(c.Name(), len(dst))
+		}
+		res, err := c.Decompress(dst, len(src))
+		if err != nil {
+			t.Fatal(c.Name(), err)
+		}
+		if !bytes.Equal(src, res) {
+			t.Fatal(c.Name(), "not equal")
+		}
+		_, err = c.Decompress(dst, len(src)-1)
+		if err == nil {
+			t.Fatal(c.Name(), "over max length")
+		}
+	}
+}
+
+func TestTcpCompressNegotiate(t *testing.T) {
+	src := bytes.Repeat([]byte("snapshot "), 150)
+	agentCh := make(chan *tcpAgent, 1)
+	listener := NewTcpListener("127.0.0.1:0", func(conn net.Conn) {
+		agent := NewTcpAgent(conn.RemoteAddr().String(), func(a kiwi.IAgent, bytes []byte) {
+			_ = a.Send(append([]byte(nil), bytes...))
+		}, kiwi.AgentCompress(NewSnappy(), 64, kiwi.CompressNegotiate))
+		agent.Start(context.Background(), conn)
+		agentCh <- agent
+	})
+	err := listener.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	ch := make(chan []byte, 1)
+	dialer := NewTcpDialer("test", fmt.Sprintf("127.0.0.1:%d", listener.Port()), func(_ kiwi.IAgent, bytes []byte) {
+		ch <- append([]byte(nil), bytes...)
+	}, kiwi.AgentCompress(NewSnappy(), 64, kiwi.CompressOn))
+	err = dialer.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dialer.Agent().Dispose()
+	err = dialer.Agent().Send(append([]byte(nil), src...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case res := <-ch:
+		if !bytes.Equal(src, res) {
+			t.Fatal("not equal")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout")
+	}
+	if !(<-agentCh).compressing() {
+		t.Fatal("not negotiated")
+	}
+}
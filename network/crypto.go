@@ -0,0 +1,171 @@
+package network
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+	"github.com/xtaci/kcp-go/v5"
+)
+
+// NewKcpBlockCrypt kcp的包加密,key用sha256派生,监听和拨号使用相同的key。
+// 所有连接共用这个key,只能防止明文被识别,key泄露后所有kcp流量都可以解密,
+// 会话之间的保密由AgentCrypto的AEAD保证
+func NewKcpBlockCrypt(key string) (kcp.BlockCrypt, *util.Err) {
+	sum := sha256.Sum256([]byte(key))
+	block, e := kcp.NewAESBlockCrypt(sum[:])
+	if e != nil {
+		return nil, util.WrapErr(util.EcParamsErr, e)
+	}
+	return block, nil
+}
+
+// agentCrypto 双向连接建立后双方先发送X25519临时公钥,
+// 共享密钥和psk派生两个方向的AES-GCM密钥,nonce使用包序号,传输都是可靠有序的;
+// 节点之间的单向连接由写端发送随机salt,只用psk和salt派生密钥,没有前向安全
+type agentCrypto struct {
+	psk     []byte
+	mode    kiwi.AgentRWMode
+	key     *ecdh.PrivateKey
+	salt    []byte
+	ready   chan struct{}
+	done    bool //只在读协程中使用
+	send    cipher.AEAD
+	recv    cipher.AEAD
+	sendSeq uint64 //只在写协程中使用
+	recvSeq uint64 //只在读协程中使用
+}
+
+// newAgentCrypto psk用于认证对端,不能为空,否则X25519可以被中间人替换公钥
+func newAgentCrypto(psk []byte, mode kiwi.AgentRWMode) *agentCrypto {
+	if len(psk) == 0 {
+		panic("crypto need psk")
+	}
+	c := &agentCrypto{
+		psk:   psk,
+		mode:  mode,
+		ready: make(chan struct{}),
+	}
+	switch mode {
+	case kiwi.AgentRW:
+		key, e := ecdh.X25519().GenerateKey(rand.Reader)
+		if e != nil {
+			panic(e)
+		}
+		c.key = key
+	case kiwi.AgentW:
+		c.salt = make([]byte, 32)
+		_, _ = rand.Read(c.salt)
+		var e error
+		c.send, e = newGcm(hmacSum(c.psk, c.salt))
+		if e != nil {
+			panic(e)
+		}
+		close(c.ready)
+	}
+	return c
+}
+
+// hello 在所有包之前发送的握手包,只读的连接不发送
+func (c *agentCrypto) hello() []byte {
+	switch c.mode {
+	case kiwi.AgentRW:
+		return c.publicKey()
+	case kiwi.AgentW:
+		return c.salt
+	default:
+		return nil
+	}
+}
+
+func (c *agentCrypto) publicKey() []byte {
+	return c.key.PublicKey().Bytes()
+}
+
+// handshake 收到对端公钥或salt后派生密钥
+func (c *agentCrypto) handshake(bytes []byte) *util.Err {
+	if c.mode == kiwi.AgentR {
+		if len(bytes) != 32 {
+			return util.NewErr(util.EcBadPacket, util.M{
+				"error": "bad salt",
+			})
+		}
+		var e error
+		c.recv, e = newGcm(hmacSum(c.psk, bytes))
+		if e != nil {
+			return util.WrapErr(util.EcParamsErr, e)
+		}
+		c.done = true
+		close(c.ready)
+		return nil
+	}
+	pub, e := ecdh.X25519().NewPublicKey(bytes)
+	if e != nil {
+		return util.WrapErr(util.EcBadPacket, e)
+	}
+	self := c.publicKey()
+	if pub.Equal(c.key.PublicKey()) {
+		return util.NewErr(util.EcBadPacket, util.M{
+			"error": "reflected public key",
+		})
+	}
+	secret, e := c.key.ECDH(pub)
+	if e != nil {
+		return util.WrapErr(util.EcBadPacket, e)
+	}
+	prk := hmacSum(c.psk, secret)
+	c.send, e = newGcm(hmacSum(prk, self))
+	if e != nil {
+		return util.WrapErr(util.EcParamsErr, e)
+	}
+	c.recv, e = newGcm(hmacSum(prk, bytes))
+	if e != nil {
+		return util.WrapErr(util.EcParamsErr, e)
+	}
+	c.done = true
+	close(c.ready)
+	return nil
+}
+
+func (c *agentCrypto) seal(bytes []byte) []byte {
+	nonce := seqNonce(c.sendSeq)
+	c.sendSeq++
+	return c.send.Seal(make([]byte, 0, len(bytes)+c.send.Overhead()), nonce, bytes, nil)
+}
+
+// open 在原切片上解密
+func (c *agentCrypto) open(bytes []byte) ([]byte, *util.Err) {
+	nonce := seqNonce(c.recvSeq)
+	c.recvSeq++
+	dst, e := c.recv.Open(bytes[:0], nonce, bytes, nil)
+	if e != nil {
+		return nil, util.WrapErr(util.EcBadPacket, e)
+	}
+	return dst, nil
+}
+
+func hmacSum(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func newGcm(key []byte) (cipher.AEAD, error) {
+	block, e := aes.NewCipher(key)
+	if e != nil {
+		return nil, e
+	}
+	return cipher.NewGCM(block)
+}
+
+func seqNonce(seq uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], seq)
+	return nonce
+}
@@ -0,0 +1,119 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+)
+
+func echoAgent(a kiwi.IAgent, bytes []byte) {
+	_ = a.Send(util.CopyBytes(bytes))
+}
+
+func testEcho(t *testing.T, dialer kiwi.IDialer, ch chan []byte) {
+	err := dialer.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dialer.Agent().Dispose()
+	src := bytes.Repeat([]byte("secret "), 100)
+	for i := 0; i < 3; i++ {
+		err = dialer.Agent().Send(util.CopyBytes(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case res := <-ch:
+			if !bytes.Equal(src, res) {
+				t.Fatal("not equal", i)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("timeout", i)
+		}
+	}
+}
+
+func TestTcpCrypto(t *testing.T) {
+	opts := []kiwi.AgentOption{
+		kiwi.AgentCrypto([]byte("psk")),
+		kiwi.AgentCompress(NewSnappy(), 64, kiwi.CompressOn),
+	}
+	listener := NewTcpListener("127.0.0.1:0", func(conn net.Conn) {
+		NewTcpAgent(conn.RemoteAddr().String(), echoAgent, opts...).Start(context.Background(), conn)
+	})
+	err := listener.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	ch := make(chan []byte, 3)
+	dialer := NewTcpDialer("test", fmt.Sprintf("127.0.0.1:%d", listener.Port()), func(_ kiwi.IAgent, bytes []byte) {
+		ch <- util.CopyBytes(bytes)
+	}, opts...)
+	testEcho(t, dialer, ch)
+}
+
+func TestUdpCrypto(t *testing.T) {
+	block, err := NewKcpBlockCrypt("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := NewUdpCryptListener("127.0.0.1:0", block, func(conn net.Conn) {
+		NewUdpAgent(conn.RemoteAddr().String(), echoAgent, kiwi.AgentCrypto([]byte("psk"))).Start(context.Background(), conn)
+	})
+	err = listener.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	ch := make(chan []byte, 3)
+	dialer := NewUdpCryptDialer("test", fmt.Sprintf("127.0.0.1:%d", listener.Port()), block, func(_ kiwi.IAgent, bytes []byte) {
+		ch <- util.CopyBytes(bytes)
+	}, kiwi.AgentCrypto([]byte("psk")))
+	testEcho(t, dialer, ch)
+}
+
+// TestTcpCryptoOneWay 节点之间的单向连接
+func TestTcpCryptoOneWay(t *testing.T) {
+	for _, psk := range []string{"psk", "other"} {
+		ch := make(chan []byte, 3)
+		listener := NewTcpListener("127.0.0.1:0", func(conn net.Conn) {
+			NewTcpAgent(conn.RemoteAddr().String(), func(_ kiwi.IAgent, bytes []byte) {
+				ch <- util.CopyBytes(bytes)
+			}, kiwi.AgentMode(kiwi.AgentR), kiwi.AgentCrypto([]byte(psk))).Start(context.Background(), conn)
+		})
+		err := listener.Start()
+		if err != nil {
+			t.Fatal(err)
+		}
+		dialer := NewTcpDialer("test", fmt.Sprintf("127.0.0.1:%d", listener.Port()), nil,
+			kiwi.AgentMode(kiwi.AgentW), kiwi.AgentCrypto([]byte("psk")))
+		err = dialer.Connect(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = dialer.Agent().Send([]byte("secret"))
+		select {
+		case res := <-ch:
+			if psk != "psk" || string(res) != "secret" {
+				t.Fatal(psk, string(res))
+			}
+		case <-time.After(500 * time.Millisecond):
+			if psk == "psk" {
+				t.Fatal("timeout")
+			}
+		}
+		dialer.Agent().Dispose()
+		listener.Close()
+	}
+}
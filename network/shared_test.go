@@ -0,0 +1,74 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+)
+
+func TestSendShared(t *testing.T) {
+	ch := make(chan []byte, 1)
+	listener := NewTcpListener("127.0.0.1:0", func(conn net.Conn) {
+		agent := NewTcpAgent(conn.RemoteAddr().String(), func(_ kiwi.IAgent, bytes []byte) {
+			ch <- append([]byte(nil), bytes...)
+		})
+		agent.Start(context.Background(), conn)
+	})
+	err := listener.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	dialer := NewTcpDialer("test", fmt.Sprintf("127.0.0.1:%d", listener.Port()), func(kiwi.IAgent, []byte) {},
+		kiwi.AgentPacketMaxCap(8))
+	err = dialer.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent := dialer.Agent()
+	disconnected := make(chan struct{})
+	agent.BindDisconnected(func(kiwi.IAgent, *util.Err) {
+		close(disconnected)
+	})
+
+	//超长的包立即释放
+	long := util.NewSharedBytes([]byte("0123456789"), 1)
+	if err = agent.SendShared(long); err == nil || err.Code() != util.EcTooLong || long.Ref() != 0 {
+		t.Fatal("oversize", err, long.Ref())
+	}
+
+	//写出后释放,其他引用保留
+	shared := util.NewSharedBytes([]byte("kiwi"), 2)
+	if err = agent.SendShared(shared); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case bytes := <-ch:
+		if string(bytes) != "kiwi" {
+			t.Fatal("bytes", string(bytes))
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout")
+	}
+
+	//写协程退出后才触发断开,之前写出的包都已经释放
+	agent.Dispose()
+	select {
+	case <-disconnected:
+	case <-time.After(3 * time.Second):
+		t.Fatal("not disconnected")
+	}
+	if shared.Ref() != 1 {
+		t.Fatal("written", shared.Ref())
+	}
+
+	//关闭后发送失败也释放
+	if err = agent.SendShared(shared); err == nil || shared.Ref() != 0 {
+		t.Fatal("closed", err, shared.Ref())
+	}
+}
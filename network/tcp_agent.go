@@ -22,19 +22,27 @@ func NewTcpAgent(addr string, receiver kiwi.FnAgentBytes, options ...kiwi.AgentO
 		ta.headReader = func(bytes []byte) int {
 			return int(bytes[0])<<8 | int(bytes[1])
 		}
-		ta.headWriter = func(buffer *util.ByteBuffer, bytes []byte) {
-			buffer.WUint16(uint16(len(bytes)))
+		ta.headWriter = func(buffer *util.ByteBuffer, l int) {
+			buffer.WUint16(uint16(l))
 		}
+		ta.flag = 1 << 15
 	case 4:
 		ta.headReader = func(bytes []byte) int {
 			return int(bytes[0])<<24 | int(bytes[1])<<16 | int(bytes[2])<<8 | int(bytes[3])
 		}
-		ta.headWriter = func(buffer *util.ByteBuffer, bytes []byte) {
-			buffer.WUint32(uint32(len(bytes)))
+		ta.headWriter = func(buffer *util.ByteBuffer, l int) {
+			buffer.WUint32(uint32(l))
 		}
+		ta.flag = 1 << 31
 	default:
 		panic("wrong head length")
 	}
+	if ta.option.Compressor == nil {
+		ta.flag = 0
+	} else if ta.option.PacketMaxCap >= ta.flag {
+		//最高位用来标记压缩
+		ta.option.PacketMaxCap = ta.flag - 1
+	}
 	return ta
 }
 
@@ -42,7 +50,8 @@ type tcpAgent struct {
 	agent
 	conn       net.Conn
 	headReader util.BytesToInt
-	headWriter func(buffer *util.ByteBuffer, bytes []byte)
+	headWriter func(buffer *util.ByteBuffer, l int)
+	flag       int
 }
 
 func (a *tcpAgent) Start(ctx context.Context, conn net.Conn) {
@@ -65,6 +74,8 @@ func (a *tcpAgent) read() {
 		buffer     = make([]byte, a.option.PacketMinCap)
 		ringBuffer = newRing(a.option.PacketMinCap, a.option.PacketMaxCap)
 		pkgLen     int
+		compressed bool
+		flag       = a.flag
 		err        *util.Err
 		headLen    = a.option.HeadLen
 		headReader = a.headReader
@@ -107,6 +118,8 @@ func (a *tcpAgent) read() {
 					}
 					_ = ringBuffer.Read(buffer, headLen)
 					pkgLen = headReader(buffer)
+					compressed = pkgLen&flag != 0
+					pkgLen &^= flag
 					if pkgLen == 0 {
 						err = util.NewErr(util.EcBadHead, nil)
 						return
@@ -120,7 +133,17 @@ func (a *tcpAgent) read() {
 				//	"len": pkgLen,
 				//	"hex": util.Hex(buffer[:pkgLen]),
 				//})
-				a.receiver(a, buffer[:pkgLen])
+				var (
+					pkg []byte
+					ok  bool
+				)
+				pkg, ok, err = a.unpack(buffer[:pkgLen], compressed)
+				if err != nil {
+					return
+				}
+				if ok {
+					a.receiver(a, pkg)
+				}
 				pkgLen = 0
 			}
 		}
@@ -142,7 +165,7 @@ func (a *tcpAgent) write() {
 		case <-a.ctx.Done():
 			return
 		case <-a.writeSignCh:
-			var elem *ds.LinkElem[[]byte]
+			var elem *ds.LinkElem[agentBytes]
 			a.enable.Mtx.Lock()
 			if a.enable.Disabled() {
 				a.enable.Mtx.Unlock()
@@ -155,17 +178,29 @@ func (a *tcpAgent) write() {
 			}
 
 			for ; elem != nil; elem = elem.Next {
-				bytes := elem.Value
 				//log.Debug("send", util.M{
-				//	"len": len(bytes),
-				//	"hex": util.Hex(bytes),
+				//	"len": len(elem.Value.bytes),
+				//	"hex": util.Hex(elem.Value.bytes),
 				//})
+				var (
+					payload    []byte
+					compressed bool
+				)
+				payload, compressed, err = a.pack(elem.Value)
+				if err != nil {
+					return
+				}
+				l := len(payload)
+				if compressed {
+					l |= a.flag
+				}
 				var buffer util.ByteBuffer
-				buffer.InitCap(len(bytes) + a.option.HeadLen)
-				headWriter(&buffer, bytes)
-				_, _ = buffer.Write(bytes)
+				buffer.InitCap(len(payload) + a.option.HeadLen)
+				headWriter(&buffer, l)
+				_, _ = buffer.Write(payload)
 				_, e := a.conn.Write(buffer.All())
-				util.RecycleBytes(bytes)
+				elem.Value.recycle()
+				a.written()
 				buffer.Dispose()
 				if e != nil {
 					err = util.WrapErr(util.EcIo, e)
@@ -2,6 +2,7 @@ package network
 
 import (
 	"context"
+	"crypto/tls"
 	"github.com/15mga/kiwi"
 	"net"
 
@@ -10,6 +11,7 @@ import (
 
 type tcpDialer struct {
 	name  string
+	tls   *tls.Config
 	agent *tcpAgent
 }
 
@@ -21,6 +23,19 @@ func NewTcpDialer(name, addr string, receiver kiwi.FnAgentBytes, options ...kiwi
 	return d
 }
 
+// NewTcpTlsDialer 使用tls连接,conf没有ServerName时使用地址中的host
+func NewTcpTlsDialer(name, addr string, conf *tls.Config, receiver kiwi.FnAgentBytes, options ...kiwi.AgentOption) kiwi.IDialer {
+	if conf.ServerName == "" {
+		conf = conf.Clone()
+		conf.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	return &tcpDialer{
+		name:  name,
+		tls:   conf,
+		agent: NewTcpAgent(addr, receiver, options...),
+	}
+}
+
 func (d *tcpDialer) Name() string {
 	return d.name
 }
@@ -43,7 +58,20 @@ func (d *tcpDialer) Connect(ctx context.Context) *util.Err {
 		})
 	}
 	_ = c.SetNoDelay(true)
-	d.agent.Start(ctx, c)
+	if d.tls == nil {
+		d.agent.Start(ctx, c)
+		return nil
+	}
+	tc := tls.Client(c, d.tls)
+	err = tc.HandshakeContext(ctx)
+	if err != nil {
+		_ = c.Close()
+		return util.NewErr(util.EcConnectErr, util.M{
+			"addr":  addr,
+			"error": err.Error(),
+		})
+	}
+	d.agent.Start(ctx, tc)
 	return nil
 }
 
@@ -1,6 +1,7 @@
 package network
 
 import (
+	"crypto/tls"
 	"github.com/15mga/kiwi"
 	"net"
 
@@ -14,8 +15,18 @@ func NewTcpListener(addr string, onConn func(conn net.Conn)) kiwi.IListener {
 	}
 }
 
+// NewTcpTlsListener 接收的连接使用tls
+func NewTcpTlsListener(addr string, conf *tls.Config, onConn func(conn net.Conn)) kiwi.IListener {
+	return &tcpListener{
+		addr:   addr,
+		tls:    conf,
+		onConn: onConn,
+	}
+}
+
 type tcpListener struct {
 	addr     string
+	tls      *tls.Config
 	listener *net.TCPListener
 	onConn   func(conn net.Conn)
 }
@@ -53,6 +64,10 @@ func (l *tcpListener) Start() *util.Err {
 				kiwi.Error(util.WrapErr(util.EcAcceptErr, err))
 				return
 			}
+			if l.tls != nil {
+				l.onConn(tls.Server(conn, l.tls))
+				continue
+			}
 			l.onConn(conn)
 		}
 	}()
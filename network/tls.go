@@ -0,0 +1,162 @@
+package network
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+)
+
+var (
+	// CertCheckDur 检查证书文件是否更新的间隔
+	CertCheckDur = 10 * time.Second
+)
+
+// CertReloader 证书文件修改后在下一次握手时重新加载
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	mtx      sync.Mutex
+	cert     *tls.Certificate
+	modTs    time.Time
+	checkTs  time.Time
+}
+
+func NewCertReloader(certFile, keyFile string) (*CertReloader, *util.Err) {
+	r := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	err := r.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload 立即重新加载
+func (r *CertReloader) Reload() *util.Err {
+	cert, e := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if e != nil {
+		return util.NewErr(util.EcParamsErr, util.M{
+			"cert":  r.certFile,
+			"key":   r.keyFile,
+			"error": e.Error(),
+		})
+	}
+	r.mtx.Lock()
+	r.cert = &cert
+	r.modTs = r.fileModTs()
+	r.checkTs = time.Now()
+	r.mtx.Unlock()
+	return nil
+}
+
+func (r *CertReloader) fileModTs() time.Time {
+	var ts time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, e := os.Stat(file)
+		if e == nil && info.ModTime().After(ts) {
+			ts = info.ModTime()
+		}
+	}
+	return ts
+}
+
+func (r *CertReloader) get() *tls.Certificate {
+	r.mtx.Lock()
+	now := time.Now()
+	if now.Sub(r.checkTs) < CertCheckDur {
+		cert := r.cert
+		r.mtx.Unlock()
+		return cert
+	}
+	r.checkTs = now
+	changed := r.fileModTs().After(r.modTs)
+	r.mtx.Unlock()
+	if changed {
+		err := r.Reload()
+		if err != nil {
+			kiwi.Error(err)
+		} else {
+			kiwi.Info("certificate reloaded", util.M{
+				"cert": r.certFile,
+			})
+		}
+	}
+	r.mtx.Lock()
+	cert := r.cert
+	r.mtx.Unlock()
+	return cert
+}
+
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.get(), nil
+}
+
+func (r *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.get(), nil
+}
+
+func LoadCertPool(caFile string) (*x509.CertPool, *util.Err) {
+	bytes, e := os.ReadFile(caFile)
+	if e != nil {
+		return nil, util.WrapErr(util.EcIo, e)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bytes) {
+		return nil, util.NewErr(util.EcParseErr, util.M{
+			"ca": caFile,
+		})
+	}
+	return pool, nil
+}
+
+// NewTlsServerConf 监听使用的配置,caFile不为空时要求客户端证书(mTLS)
+func NewTlsServerConf(certFile, keyFile, caFile string) (*tls.Config, *util.Err) {
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	conf := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if caFile != "" {
+		pool, err := LoadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return conf, nil
+}
+
+// NewTlsClientConf 连接使用的配置,certFile不为空时提供客户端证书,
+// caFile为空时使用系统根证书
+func NewTlsClientConf(certFile, keyFile, caFile, serverName string) (*tls.Config, *util.Err) {
+	conf := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+	}
+	if certFile != "" {
+		reloader, err := NewCertReloader(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.GetClientCertificate = reloader.GetClientCertificate
+	}
+	if caFile != "" {
+		pool, err := LoadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = pool
+	}
+	return conf, nil
+}
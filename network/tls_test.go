@@ -0,0 +1,103 @@
+package network
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/15mga/kiwi"
+)
+
+func writeCert(t *testing.T, dir, name string, tpl, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if parent == nil {
+		parent, parentKey = tpl, key
+	}
+	der, e := x509.CreateCertificate(rand.Reader, tpl, parent, &key.PublicKey, parentKey)
+	if e != nil {
+		t.Fatal(e)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	_ = os.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	_ = os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func TestTcpMTls(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	ca, caKey := writeCert(t, dir, "ca", &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kiwi ca"},
+		NotBefore:             now,
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	writeCert(t, dir, "node", &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "node"},
+		NotBefore:    now,
+		NotAfter:     now.Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+
+	file := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+	serverConf, err := NewTlsServerConf(file("node.crt"), file("node.key"), file("ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConf, err := NewTlsClientConf(file("node.crt"), file("node.key"), file("ca.crt"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan string, 1)
+	listener := NewTcpTlsListener("127.0.0.1:0", serverConf, func(conn net.Conn) {
+		agent := NewTcpAgent(conn.RemoteAddr().String(), func(agent kiwi.IAgent, bytes []byte) {
+			ch <- string(bytes)
+		}, kiwi.AgentMode(kiwi.AgentR))
+		agent.Start(context.Background(), conn)
+	})
+	err = listener.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	addr := fmt.Sprintf("127.0.0.1:%d", listener.Port())
+	dialer := NewTcpTlsDialer("test", addr, clientConf, func(kiwi.IAgent, []byte) {})
+	err = dialer.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dialer.Agent().Dispose()
+	err = dialer.Agent().Send([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case str := <-ch:
+		if str != "hello" {
+			t.Fatal(str)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout")
+	}
+}
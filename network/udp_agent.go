@@ -69,7 +69,23 @@ func (a *udpAgent) read() {
 				err = util.WrapErr(util.EcIo, e)
 				return
 			}
-			a.receiver(a, newData[:newLen])
+			pkg := newData[:newLen]
+			compressed := false
+			if a.option.Compressor != nil {
+				if newLen == 0 {
+					continue
+				}
+				compressed = pkg[0] == _UdpCompressed
+				pkg = pkg[1:]
+			}
+			var ok bool
+			pkg, ok, err = a.unpack(pkg, compressed)
+			if err != nil {
+				return
+			}
+			if ok {
+				a.receiver(a, pkg)
+			}
 		}
 	}
 }
@@ -87,7 +103,7 @@ func (a *udpAgent) write() {
 		case <-a.ctx.Done():
 			return
 		case <-a.writeSignCh:
-			var elem *ds.LinkElem[[]byte]
+			var elem *ds.LinkElem[agentBytes]
 			a.enable.Mtx.Lock()
 			if a.enable.Disabled() {
 				a.enable.Mtx.Unlock()
@@ -100,9 +116,32 @@ func (a *udpAgent) write() {
 			}
 
 			for ; elem != nil; elem = elem.Next {
-				bytes := elem.Value
-				_, e := a.conn.Write(bytes)
-				util.RecycleBytes(bytes)
+				var (
+					payload    []byte
+					compressed bool
+				)
+				payload, compressed, err = a.pack(elem.Value)
+				if err != nil {
+					return
+				}
+				var e error
+				if a.option.Compressor != nil {
+					//压缩标记放在包前
+					flag := _UdpRaw
+					if compressed {
+						flag = _UdpCompressed
+					}
+					var buffer util.ByteBuffer
+					buffer.InitCap(len(payload) + 1)
+					buffer.WUint8(flag)
+					_, _ = buffer.Write(payload)
+					_, e = a.conn.Write(buffer.All())
+					buffer.Dispose()
+				} else {
+					_, e = a.conn.Write(payload)
+				}
+				elem.Value.recycle()
+				a.written()
 				if e != nil {
 					err = util.WrapErr(util.EcIo, e)
 					return
@@ -10,6 +10,7 @@ import (
 
 type udpDialer struct {
 	name  string
+	block kcp.BlockCrypt
 	agent kiwi.IAgent
 }
 
@@ -21,13 +22,22 @@ func NewUdpDialer(name, addr string, receiver kiwi.FnAgentBytes, options ...kiwi
 	return d
 }
 
+// NewUdpCryptDialer kcp的包使用block加密,与监听使用相同的key
+func NewUdpCryptDialer(name, addr string, block kcp.BlockCrypt, receiver kiwi.FnAgentBytes, options ...kiwi.AgentOption) kiwi.IDialer {
+	return &udpDialer{
+		name:  name,
+		block: block,
+		agent: NewUdpAgent(addr, receiver, options...),
+	}
+}
+
 func (d *udpDialer) Name() string {
 	return d.name
 }
 
 func (d *udpDialer) Connect(ctx context.Context) *util.Err {
 	addr := d.agent.Addr()
-	c, err := kcp.Dial(addr)
+	c, err := kcp.DialWithOptions(addr, d.block, 0, 0)
 	if err != nil {
 		return util.NewErr(util.EcConnectErr, util.M{
 			"addr":  addr,
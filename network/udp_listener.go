@@ -15,8 +15,18 @@ func NewUdpListener(addr string, onConn func(conn net.Conn)) kiwi.IListener {
 	}
 }
 
+// NewUdpCryptListener kcp的包使用block加密,可以用NewKcpBlockCrypt创建
+func NewUdpCryptListener(addr string, block kcp.BlockCrypt, onConn func(conn net.Conn)) kiwi.IListener {
+	return &udpListener{
+		addr:   addr,
+		block:  block,
+		onConn: onConn,
+	}
+}
+
 type udpListener struct {
 	addr     string
+	block    kcp.BlockCrypt
 	onConn   func(conn net.Conn)
 	listener *kcp.Listener
 }
@@ -34,7 +44,7 @@ func (l *udpListener) Start() *util.Err {
 	kiwi.Info("start udp listener", util.M{
 		"addr": l.addr,
 	})
-	listener, err := kcp.Listen(l.addr)
+	listener, err := kcp.ListenWithOptions(l.addr, l.block, 0, 0)
 	if err != nil {
 		return util.NewErr(util.EcListenErr, util.M{
 			"addr":  l.addr,
@@ -42,7 +52,7 @@ func (l *udpListener) Start() *util.Err {
 		})
 	}
 
-	l.listener = listener.(*kcp.Listener)
+	l.listener = listener
 	go func() {
 		for {
 			conn, err := l.listener.AcceptKCP()
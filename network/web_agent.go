@@ -81,7 +81,17 @@ func (a *webAgent) read() {
 			if newLen == 0 {
 				break
 			}
-			a.receiver(a, bytes)
+			var (
+				pkg []byte
+				ok  bool
+			)
+			pkg, ok, err = a.unpack(bytes, false)
+			if err != nil {
+				return
+			}
+			if ok {
+				a.receiver(a, pkg)
+			}
 		}
 	}
 }
@@ -101,7 +111,7 @@ func (a *webAgent) write() {
 		case <-a.ctx.Done():
 			return
 		case <-a.writeSignCh:
-			var elem *ds.LinkElem[[]byte]
+			var elem *ds.LinkElem[agentBytes]
 			a.enable.Mtx.Lock()
 			if a.enable.Disabled() {
 				a.enable.Mtx.Unlock()
@@ -114,9 +124,22 @@ func (a *webAgent) write() {
 			}
 
 			for ; elem != nil; elem = elem.Next {
-				bytes := elem.Value
+				bytes := elem.Value.bytes
+				if a.option.Compressor != nil {
+					//握手没有协商permessage-deflate时不生效
+					c.EnableWriteCompression(a.compressing() && len(bytes) >= a.option.CompressMin)
+				}
+				if a.crypto != nil && !elem.Value.plain {
+					select {
+					case <-a.crypto.ready:
+					case <-a.ctx.Done():
+						return
+					}
+					bytes = a.crypto.seal(bytes)
+				}
 				e := c.WriteMessage(msgType, bytes)
-				util.RecycleBytes(bytes)
+				elem.Value.recycle()
+				a.written()
 				if e != nil {
 					err = util.WrapErr(util.EcIo, e)
 					return
@@ -32,7 +32,15 @@ func (d *websocketDialer) Name() string {
 }
 
 func (d *websocketDialer) Connect(ctx context.Context) *util.Err {
-	conn, _, err := websocket.DefaultDialer.Dial(d.url, d.header)
+	dialer := websocket.DefaultDialer
+	if d.agent.option.Compressor != nil {
+		dialer = &websocket.Dialer{
+			Proxy:             websocket.DefaultDialer.Proxy,
+			HandshakeTimeout:  websocket.DefaultDialer.HandshakeTimeout,
+			EnableCompression: true,
+		}
+	}
+	conn, _, err := dialer.Dial(d.url, d.header)
 	if err != nil {
 		return util.NewErr(util.EcConnectErr, util.M{
 			"url":   d.url,
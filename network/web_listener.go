@@ -1,6 +1,7 @@
 package network
 
 import (
+	"crypto/tls"
 	"github.com/15mga/kiwi"
 	"github.com/15mga/kiwi/util"
 	"github.com/fasthttp/websocket"
@@ -11,6 +12,7 @@ type webOption struct {
 	addr      string
 	upgrader  *websocket.Upgrader
 	resHeader http.Header
+	tls       *tls.Config
 }
 
 type WebOption func(option *webOption)
@@ -33,6 +35,13 @@ func WebResHeader(header http.Header) WebOption {
 	}
 }
 
+// WebTls 使用wss
+func WebTls(conf *tls.Config) WebOption {
+	return func(option *webOption) {
+		option.tls = conf
+	}
+}
+
 func NewWebListener(onConn func(conn *websocket.Conn), opts ...WebOption) kiwi.IListener {
 	o := &webOption{
 		addr:     ":7737",
@@ -75,11 +84,22 @@ func (l *webListener) Start() *util.Err {
 	kiwi.Info("start websocket listener", util.M{
 		"addr": l.option.addr,
 	})
-	http.HandleFunc("/", l.handler)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", l.handler)
+	l.server = &http.Server{
+		Addr:      l.option.addr,
+		Handler:   mux,
+		TLSConfig: l.option.tls,
+	}
 
 	go func() {
-		e := http.ListenAndServe(l.option.addr, nil)
-		if e != nil {
+		var e error
+		if l.option.tls != nil {
+			e = l.server.ListenAndServeTLS("", "")
+		} else {
+			e = l.server.ListenAndServe()
+		}
+		if e != nil && e != http.ErrServerClosed {
 			panic(e)
 		}
 	}()
@@ -87,5 +107,8 @@ func (l *webListener) Start() *util.Err {
 }
 
 func (l *webListener) Close() {
-	l.server.Close()
+	if l.server == nil {
+		return
+	}
+	_ = l.server.Close()
 }
@@ -35,6 +35,10 @@ type INode interface {
 	Notify(ntf ISndNotice)
 	ReceiveWatchNotice(nodeId int64, methods []TCode)
 	SendToNode(nodeId int64, bytes []byte, fnErr util.FnErr)
+	// Drain 远程节点进入排空状态,不再被选择
+	Drain(nodeId int64)
+	// Dispose 关闭监听
+	Dispose()
 }
 
 type INodeHandler interface {
@@ -26,6 +26,7 @@ type NodeMeta struct {
 	Data      util.M
 	Mode      string
 	Services  map[TSvc]string
+	Draining  bool
 }
 
 func (n *NodeMeta) Init(id int64) {
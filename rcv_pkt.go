@@ -1,10 +1,15 @@
 package kiwi
 
-import "github.com/15mga/kiwi/util"
+import (
+	"context"
+
+	"github.com/15mga/kiwi/util"
+)
 
 type FnRcvPkt func(IRcvPkt)
 type FnRcvPus func(IRcvPush)
 type FnRcvReq func(IRcvRequest)
+type FnRcvStream func(IRcvStream)
 
 type EWorker uint8
 
@@ -28,6 +33,10 @@ type IRcvPkt interface {
 	SetWorker(typ EWorker, key string)
 	Worker() EWorker
 	WorkerKey() string
+	// Deadline 请求方的截止时间戳(毫秒)
+	Deadline() (int64, bool)
+	// Expired 请求方已不再等待结果
+	Expired() bool
 	InitWithBytes(msgType uint8, tid int64, head util.M, json bool, bytes []byte) *util.Err
 	InitWithMsg(msgType uint8, tid int64, head util.M, json bool, msg util.IMsg)
 	Complete()
@@ -49,3 +58,29 @@ type IRcvRequest interface {
 type IRcvNotice interface {
 	IRcvPkt
 }
+
+// IStream 流的一端,Send受对端授予的额度限制,额度用完时阻塞
+type IStream interface {
+	Tid() int64
+	Svc() TSvc
+	Code() TCode
+	Head() util.M
+	// Context 流取消或超时后结束
+	Context() context.Context
+	Send(msg util.IMsg) *util.Err
+	// Recv 对端CloseSend后msg为空,code为对端的结束码
+	Recv() (msg util.IMsg, code uint16)
+	// CloseSend 不再发送,code不为0表示失败
+	CloseSend(code uint16)
+	// Cancel 取消流,对端的Send、Recv返回code
+	Cancel(code uint16)
+}
+
+// IRcvStream 服务端的流,Msg是打开流时的请求,客户端流可以为空;
+// 客户端发送请求消息,服务端发送ReqToResCode对应的响应消息
+type IRcvStream interface {
+	IStream
+	SenderId() int64
+	Json() bool
+	Msg() util.IMsg
+}
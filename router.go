@@ -23,8 +23,11 @@ type IRouter interface {
 	DelRequest(tid int64)
 	BindPus(svc TSvc, code TCode, fn FnRcvPus)
 	BindReq(svc TSvc, code TCode, fn FnRcvReq)
+	// BindStream 流的处理在单独的协程中执行
+	BindStream(svc TSvc, code TCode, fn FnRcvStream)
 	OnPush(pkt IRcvPush)
 	OnRequest(pkt IRcvRequest)
+	OnStream(stream IRcvStream)
 	OnResponseOk(tid int64, head util.M, msg util.IMsg)
 	OnResponseOkBytes(tid int64, head util.M, bytes []byte)
 	OnResponseFail(tid int64, head util.M, code uint16)
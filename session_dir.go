@@ -0,0 +1,12 @@
+package kiwi
+
+import "github.com/15mga/kiwi/util"
+
+// ISessionDir 连接id到所在网关节点的目录
+type ISessionDir interface {
+	Set(id string, nodeId int64) *util.Err
+	// Del 只删除仍然指向nodeId的记录,id可能已经在其他网关登录
+	Del(id string, nodeId int64) *util.Err
+	// Get 不存在的id不在结果中
+	Get(ids ...string) (map[string]int64, *util.Err)
+}
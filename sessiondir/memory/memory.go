@@ -0,0 +1,48 @@
+package memory
+
+import (
+	"sync"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+)
+
+// New 进程内的目录,多个节点在同一进程测试时共享
+func New() kiwi.ISessionDir {
+	return &dir{
+		idToNode: make(map[string]int64),
+	}
+}
+
+type dir struct {
+	mtx      sync.RWMutex
+	idToNode map[string]int64
+}
+
+func (d *dir) Set(id string, nodeId int64) *util.Err {
+	d.mtx.Lock()
+	d.idToNode[id] = nodeId
+	d.mtx.Unlock()
+	return nil
+}
+
+func (d *dir) Del(id string, nodeId int64) *util.Err {
+	d.mtx.Lock()
+	if d.idToNode[id] == nodeId {
+		delete(d.idToNode, id)
+	}
+	d.mtx.Unlock()
+	return nil
+}
+
+func (d *dir) Get(ids ...string) (map[string]int64, *util.Err) {
+	m := make(map[string]int64, len(ids))
+	d.mtx.RLock()
+	for _, id := range ids {
+		if nodeId, ok := d.idToNode[id]; ok {
+			m[id] = nodeId
+		}
+	}
+	d.mtx.RUnlock()
+	return m, nil
+}
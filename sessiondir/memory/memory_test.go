@@ -0,0 +1,19 @@
+package memory
+
+import "testing"
+
+func TestDir(t *testing.T) {
+	d := New()
+	_ = d.Set("a", 1)
+	_ = d.Set("b", 2)
+	_ = d.Del("a", 2)
+	m, _ := d.Get("a", "b", "c")
+	if len(m) != 2 || m["a"] != 1 || m["b"] != 2 {
+		t.Fatal(m)
+	}
+	_ = d.Del("a", 1)
+	m, _ = d.Get("a")
+	if len(m) != 0 {
+		t.Fatal(m)
+	}
+}
@@ -0,0 +1,141 @@
+package redis
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/15mga/kiwi"
+	"github.com/15mga/kiwi/util"
+	"github.com/15mga/kiwi/util/rds"
+	"github.com/gomodule/redigo/redis"
+)
+
+var (
+	// KeyPrefix 每个id一个key,值是网关节点id
+	KeyPrefix = "gate.session."
+	// Ttl 记录的过期秒数,每Ttl/3刷新一次,网关节点崩溃后记录自动过期
+	Ttl int64 = 30
+)
+
+// 只删除仍然指向本节点的记录
+var _DelScript = redis.NewScript(1, `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`)
+
+// 只刷新仍然指向本节点或已经过期的记录,不覆盖其他节点
+var _RefreshScript = redis.NewScript(1, `local v = redis.call("GET", KEYS[1])
+if v == false or v == ARGV[1] then return redis.call("SET", KEYS[1], ARGV[1], "EX", ARGV[2]) end return 0`)
+
+// New 使用rds.InitRedis初始化的连接池,util.Ctx结束时停止刷新
+func New() kiwi.ISessionDir {
+	d := &dir{
+		idToNode: make(map[string]int64),
+	}
+	go d.heartbeat()
+	return d
+}
+
+type dir struct {
+	mtx      sync.Mutex
+	idToNode map[string]int64
+}
+
+func (d *dir) Set(id string, nodeId int64) *util.Err {
+	d.mtx.Lock()
+	d.idToNode[id] = nodeId
+	d.mtx.Unlock()
+	return rds.FnSpawnConn(func(conn redis.Conn) *util.Err {
+		_, e := conn.Do(rds.SET, KeyPrefix+id, nodeId, "EX", Ttl)
+		if e != nil {
+			return util.WrapErr(util.EcRedisErr, e)
+		}
+		return nil
+	})
+}
+
+func (d *dir) heartbeat() {
+	ticker := time.NewTicker(time.Duration(Ttl) * time.Second / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-util.Ctx().Done():
+			return
+		case <-ticker.C:
+			err := d.refresh()
+			if err != nil {
+				kiwi.Error(err)
+			}
+		}
+	}
+}
+
+func (d *dir) refresh() *util.Err {
+	d.mtx.Lock()
+	idToNode := make(map[string]int64, len(d.idToNode))
+	for id, nodeId := range d.idToNode {
+		idToNode[id] = nodeId
+	}
+	d.mtx.Unlock()
+	if len(idToNode) == 0 {
+		return nil
+	}
+	return rds.FnSpawnConn(func(conn redis.Conn) *util.Err {
+		for id, nodeId := range idToNode {
+			e := _RefreshScript.Send(conn, KeyPrefix+id, strconv.FormatInt(nodeId, 10), Ttl)
+			if e != nil {
+				return util.WrapErr(util.EcRedisErr, e)
+			}
+		}
+		_, e := conn.Do("")
+		if e != nil {
+			return util.WrapErr(util.EcRedisErr, e)
+		}
+		return nil
+	})
+}
+
+func (d *dir) Del(id string, nodeId int64) *util.Err {
+	d.mtx.Lock()
+	if n, ok := d.idToNode[id]; ok && n == nodeId {
+		delete(d.idToNode, id)
+	}
+	d.mtx.Unlock()
+	return rds.FnSpawnConn(func(conn redis.Conn) *util.Err {
+		_, e := _DelScript.Do(conn, KeyPrefix+id, strconv.FormatInt(nodeId, 10))
+		if e != nil {
+			return util.WrapErr(util.EcRedisErr, e)
+		}
+		return nil
+	})
+}
+
+func (d *dir) Get(ids ...string) (map[string]int64, *util.Err) {
+	m := make(map[string]int64, len(ids))
+	if len(ids) == 0 {
+		return m, nil
+	}
+	err := rds.FnSpawnConn(func(conn redis.Conn) *util.Err {
+		keys := make([]any, len(ids))
+		for i, id := range ids {
+			keys[i] = KeyPrefix + id
+		}
+		vals, e := redis.Values(conn.Do("MGET", keys...))
+		if e != nil {
+			return util.WrapErr(util.EcRedisErr, e)
+		}
+		for i, val := range vals {
+			if val == nil {
+				continue
+			}
+			nodeId, e := redis.Int64(val, nil)
+			if e != nil {
+				return util.WrapErr(util.EcRedisErr, e)
+			}
+			m[ids[i]] = nodeId
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -23,6 +23,9 @@ type ISndRequest interface {
 	OkBytes(head util.M, bytes []byte)
 	Ok(head util.M, msg util.IMsg)
 	Fail(head util.M, code uint16)
+	// SetNodeId 记录处理请求的远程节点
+	SetNodeId(nodeId int64)
+	NodeId() int64
 }
 
 type ISndPush interface {
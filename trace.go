@@ -0,0 +1,155 @@
+package kiwi
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/15mga/kiwi/util"
+)
+
+// W3C trace context
+const (
+	HeadTraceParent = "traceparent"
+	HeadTraceState  = "tracestate"
+	traceVersion    = "00"
+	traceSampled    = "01"
+)
+
+var (
+	// TraceCtxTtl 收到的包的链路上下文最多保留多久,包没有完成时也会被清理
+	TraceCtxTtl    = time.Minute
+	_TidToTraceCtx sync.Map
+	_TraceCtxSweep sync.Once
+)
+
+type traceCtx struct {
+	traceId string
+	flags   string
+	state   string
+	expire  int64
+}
+
+// sweepTraceCtx 定时清理过期的链路上下文
+func sweepTraceCtx() {
+	for range time.Tick(TraceCtxTtl / 2) {
+		now := time.Now().UnixNano()
+		_TidToTraceCtx.Range(func(key, value any) bool {
+			if ctx := value.(*traceCtx); ctx.expire > 0 && ctx.expire < now {
+				_TidToTraceCtx.Delete(key)
+			}
+			return true
+		})
+	}
+}
+
+// ParseTraceParent 解析traceparent,span id与tid一一对应
+func ParseTraceParent(str string) (traceId string, spanId int64, flags string, ok bool) {
+	slc := strings.Split(str, "-")
+	if len(slc) != 4 || len(slc[1]) != 32 || len(slc[2]) != 16 || len(slc[3]) != 2 {
+		return
+	}
+	if slc[1] == "00000000000000000000000000000000" {
+		return
+	}
+	if _, e := hex.DecodeString(slc[1]); e != nil {
+		return
+	}
+	id, e := strconv.ParseUint(slc[2], 16, 64)
+	if e != nil || id == 0 {
+		return
+	}
+	return slc[1], int64(id), slc[3], true
+}
+
+func FormatTraceParent(traceId string, spanId int64, flags string) string {
+	return traceVersion + "-" + traceId + "-" + SpanIdToHex(spanId) + "-" + flags
+}
+
+func SpanIdToHex(id int64) string {
+	s := strconv.FormatUint(uint64(id), 16)
+	if len(s) < 16 {
+		s = strings.Repeat("0", 16-len(s)) + s
+	}
+	return s
+}
+
+func NewTraceId() string {
+	var bytes [16]byte
+	_, _ = rand.Read(bytes[:])
+	return hex.EncodeToString(bytes[:])
+}
+
+// BindTraceCtx 记录收到的包的链路上下文,以tid为pid发出的包会延续该链路
+func BindTraceCtx(tid int64, head util.M) {
+	str, ok := util.MGet[string](head, HeadTraceParent)
+	if !ok {
+		return
+	}
+	traceId, _, flags, ok := ParseTraceParent(str)
+	if !ok {
+		return
+	}
+	state, _ := util.MGet[string](head, HeadTraceState)
+	_TraceCtxSweep.Do(func() {
+		go sweepTraceCtx()
+	})
+	_TidToTraceCtx.Store(tid, &traceCtx{
+		traceId: traceId,
+		flags:   flags,
+		state:   state,
+		expire:  time.Now().Add(TraceCtxTtl).UnixNano(),
+	})
+}
+
+func UnbindTraceCtx(tid int64) {
+	_TidToTraceCtx.Delete(tid)
+}
+
+// BoundTraceParent 返回BindTraceCtx记录的链路,span id为收到的tid
+func BoundTraceParent(tid int64) (string, bool) {
+	v, ok := _TidToTraceCtx.Load(tid)
+	if !ok {
+		return "", false
+	}
+	ctx := v.(*traceCtx)
+	return FormatTraceParent(ctx.traceId, tid, ctx.flags), true
+}
+
+// propagateTrace 为新的tid生成traceparent,返回父span,外部传入的traceparent作为父span
+func propagateTrace(pid, tid int64, head util.M) int64 {
+	var ctx *traceCtx
+	if str, ok := util.MGet[string](head, HeadTraceParent); ok {
+		traceId, spanId, flags, ok := ParseTraceParent(str)
+		if ok {
+			state, _ := util.MGet[string](head, HeadTraceState)
+			ctx = &traceCtx{
+				traceId: traceId,
+				flags:   flags,
+				state:   state,
+			}
+			if pid == 0 {
+				pid = spanId
+			}
+		}
+	}
+	if ctx == nil && pid != 0 {
+		if v, ok := _TidToTraceCtx.Load(pid); ok {
+			ctx = v.(*traceCtx)
+		}
+	}
+	if ctx == nil {
+		ctx = &traceCtx{
+			traceId: NewTraceId(),
+			flags:   traceSampled,
+		}
+	}
+	head[HeadTraceParent] = FormatTraceParent(ctx.traceId, tid, ctx.flags)
+	if ctx.state != "" {
+		head[HeadTraceState] = ctx.state
+	}
+	return pid
+}
@@ -51,6 +51,8 @@ const (
 	EcDbErr
 	EcRedisErr
 	EcEtcdErr
+	EcCanceled
+	EcFlood
 )
 
 var (
@@ -98,6 +100,8 @@ var (
 		EcWriteFail:     "write_fail",
 		EcFail:          "fail_code",
 		EcTooSlow:       "too_slow",
+		EcCanceled:      "canceled",
+		EcFlood:         "flood",
 	}
 )
 
@@ -80,6 +80,14 @@ func PutWithTtl(key, val string, ttl int64) (int64, *util.Err) {
 	return id, util.WrapErr(util.EcEtcdErr, e)
 }
 
+func PutWithLease(key, val string, leaseId int64) *util.Err {
+	_, e := _Etcd.Put(util.Ctx(), key, val, etcd.WithLease(etcd.LeaseID(leaseId)))
+	if e != nil {
+		return util.WrapErr(util.EcEtcdErr, e)
+	}
+	return nil
+}
+
 func Lock(key string, fn util.ToErr) *util.Err {
 	if fn == nil {
 		return nil
@@ -6,6 +6,7 @@ import (
 
 	"github.com/15mga/kiwi/util"
 	"github.com/go-redsync/redsync/v4"
+	"github.com/go-redsync/redsync/v4/redis/redigo"
 	"github.com/gomodule/redigo/redis"
 )
 
@@ -272,9 +273,13 @@ func NewRedis(opts ...RedisOption) *Redis {
 	for _, o := range opts {
 		o(opt)
 	}
-	return &Redis{
+	r := &Redis{
 		redisRedisOption: opt,
 	}
+	if opt.connPool != nil {
+		r.locker = redsync.New(redigo.NewPool(opt.connPool))
+	}
+	return r
 }
 
 type Redis struct {
@@ -284,7 +289,10 @@ type Redis struct {
 
 func (r *Redis) GetConn() (redis.Conn, *util.Err) {
 	conn, err := r.redisRedisOption.connFac()
-	return conn, util.WrapErr(util.EcRedisErr, err)
+	if err != nil {
+		return nil, util.WrapErr(util.EcRedisErr, err)
+	}
+	return conn, nil
 }
 
 func (r *Redis) SpawnConn() redis.Conn {
@@ -302,7 +310,10 @@ func (r *Redis) Lock(key string, fn func(), opts ...redsync.Option) *util.Err {
 	}
 	fn()
 	_, e = m.Unlock()
-	return util.WrapErr(util.EcRedisErr, e)
+	if e != nil {
+		return util.WrapErr(util.EcRedisErr, e)
+	}
+	return nil
 }
 
 var (
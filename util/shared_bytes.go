@@ -0,0 +1,41 @@
+package util
+
+import "sync/atomic"
+
+// SharedBytes 多个连接共享的包,所有引用Release后回收
+type SharedBytes struct {
+	bytes []byte
+	ref   int32
+}
+
+func NewSharedBytes(bytes []byte, ref int) *SharedBytes {
+	return &SharedBytes{
+		bytes: bytes,
+		ref:   int32(ref),
+	}
+}
+
+func (s *SharedBytes) Bytes() []byte {
+	return s.bytes
+}
+
+// Ref 剩余的引用数
+func (s *SharedBytes) Ref() int32 {
+	return atomic.LoadInt32(&s.ref)
+}
+
+// Release 引用数为0后多余的Release忽略,避免重复回收
+func (s *SharedBytes) Release() {
+	for {
+		ref := atomic.LoadInt32(&s.ref)
+		if ref <= 0 {
+			return
+		}
+		if atomic.CompareAndSwapInt32(&s.ref, ref, ref-1) {
+			if ref == 1 {
+				RecycleBytes(s.bytes)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package util
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestSharedBytes(t *testing.T) {
+	s := NewSharedBytes(SpawnBytes(), 100)
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Release()
+		}()
+	}
+	wg.Wait()
+	if s.Ref() != 0 {
+		t.Fatal("ref", s.Ref())
+	}
+	s.Release()
+	if s.Ref() != 0 {
+		t.Fatal("below zero", s.Ref())
+	}
+}
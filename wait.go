@@ -46,6 +46,7 @@ type waitInfo struct {
 
 var (
 	_WaitExitInfos = make([]*waitInfo, 0, 1)
+	_ExitDrain     bool
 )
 
 func BeforeExitFn(name string, fn util.Fn) {
@@ -55,6 +56,17 @@ func BeforeExitFn(name string, fn util.Fn) {
 	})
 }
 
+// BeforeExitDrain 退出前排空节点,排空完成时注销发现服务,
+// 退出函数是并发执行的,其他退出时的注销需要通过ExitDrain跳过
+func BeforeExitDrain(drain util.Fn) {
+	_ExitDrain = true
+	BeforeExitFn("node drain", drain)
+}
+
+func ExitDrain() bool {
+	return _ExitDrain
+}
+
 func BeforeExitCh(name string) chan<- struct{} {
 	ch := make(chan struct{})
 	BeforeExitFn(name, func() {
@@ -2,6 +2,7 @@ package worker
 
 import (
 	"github.com/15mga/kiwi/util"
+	"sync/atomic"
 	"time"
 
 	"github.com/15mga/kiwi/ds"
@@ -11,6 +12,7 @@ const (
 	cmdActiveCheck   = "check"
 	cmdActiveDispose = "dispose"
 	cmdActivePush    = "push"
+	cmdActiveInfo    = "info"
 )
 
 type (
@@ -96,6 +98,7 @@ type active struct {
 	activeWorkers     *ds.KSet[string, *activeWorker]
 	activeStopSeconds int64
 	activeTimeStamp   *ds.KSet[string, *activeData]
+	len               int64
 }
 
 func (a *active) Dispose() {
@@ -103,7 +106,18 @@ func (a *active) Dispose() {
 	a.worker.Dispose()
 }
 
+// Info 活跃协程的id与各自的队列长度
+func (a *active) Info(fn util.FnM) {
+	a.worker.Push(cmdActiveInfo, fn)
+}
+
+// Len 所有活跃协程中未处理的数量
+func (a *active) Len() int64 {
+	return atomic.LoadInt64(&a.len)
+}
+
 func (a *active) Push(id string, fn util.FnAnySlc, params ...any) {
+	atomic.AddInt64(&a.len, 1)
 	a.worker.Push(cmdActivePush, id, fn, params)
 }
 
@@ -125,6 +139,13 @@ func (a *active) process(job *Job) {
 		a.activeWorkers.Iter(func(item *activeWorker) {
 			item.Dispose()
 		})
+	case cmdActiveInfo:
+		fn := util.SplitSlc1[util.FnM](job.Data)
+		m := make(util.M, a.activeWorkers.Count())
+		a.activeWorkers.Iter(func(item *activeWorker) {
+			m[item.id] = item.Len()
+		})
+		fn(m)
 	case cmdActivePush:
 		id, fn, params := util.SplitSlc3[string, util.FnAnySlc, []any](job.Data)
 		worker, ok := a.activeWorkers.Get(id)
@@ -133,7 +154,7 @@ func (a *active) process(job *Job) {
 			d, _ := a.activeTimeStamp.Get(id)
 			d.ts = now
 		} else {
-			worker = newActiveWorker(id)
+			worker = newActiveWorker(id, &a.len)
 			worker.Start()
 			a.activeWorkers.Set(worker)
 			_ = a.activeTimeStamp.Add(&activeData{
@@ -145,10 +166,15 @@ func (a *active) process(job *Job) {
 	}
 }
 
-func newActiveWorker(id string) *activeWorker {
+func newActiveWorker(id string, counter *int64) *activeWorker {
 	a := &activeWorker{
-		FnWorker: NewFnWorker(),
-		id:       id,
+		FnWorker: &FnWorker{
+			Worker: NewWorker[FnJobData](func(data FnJobData) {
+				atomic.AddInt64(counter, -1)
+				data.Fn(data.Params)
+			}),
+		},
+		id: id,
 	}
 	return a
 }
@@ -32,6 +32,10 @@ func (o *global) Push(fn util.FnAnySlc, params ...any) {
 	o.worker.Push(fn, params...)
 }
 
+func (o *global) Len() int64 {
+	return o.worker.Len()
+}
+
 func (o *global) Dispose() {
 	o.worker.Dispose()
 }
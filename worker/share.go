@@ -98,3 +98,11 @@ func MemHashString(str string) int64 {
 	ss := (*stringStruct)(unsafe.Pointer(&str))
 	return int64(memhash(ss.str, 0, uintptr(ss.len)))
 }
+
+func (s *fnShare) Len() int64 {
+	var l int64
+	for _, w := range s.workers {
+		l += w.Len()
+	}
+	return l
+}
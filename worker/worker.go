@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/15mga/kiwi"
 	"sync"
+	"sync/atomic"
 
 	"github.com/15mga/kiwi/util"
 )
@@ -29,6 +30,7 @@ type Worker[T any] struct {
 	curr *job[T]
 	fn   func(T)
 	pool sync.Pool
+	len  int64
 }
 
 func (w *Worker[T]) Start() {
@@ -66,7 +68,13 @@ func (w *Worker[T]) Dispose() {
 	close(w.ch)
 }
 
+// Len 队列中未处理的数量
+func (w *Worker[T]) Len() int64 {
+	return atomic.LoadInt64(&w.len)
+}
+
 func (w *Worker[T]) Push(item T) {
+	atomic.AddInt64(&w.len, 1)
 	e := w.pool.Get().(*job[T])
 	e.value = item
 	w.mtx.Lock()
@@ -98,6 +106,7 @@ func (w *Worker[T]) do() {
 		w.curr = j.next
 		j.next = nil
 		w.pool.Put(j)
+		atomic.AddInt64(&w.len, -1)
 		w.fn(val)
 	}
 }